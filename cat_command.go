@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/remote_glob"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+/*
+*	This function turns 'cf download-cat APP_NAME PATH... [flags]' into the download it stands
+*	for, 'cf download APP_NAME PATH... --stdout [flags]'.
+ */
+func CatArgs(args []string) []string {
+	return append([]string{"download"}, append(args[1:], "--stdout")...)
+}
+
+/*
+*	This function writes the files at 'paths' to stdout instead of to disk. A single file is
+*	written as it is, with nothing added, so it can be piped into another command. Several files,
+*	the matches of a glob, directories (paths ending in a slash) or --tar are written as a tar
+*	stream holding the files below their app paths. Messages go to stderr.
+ */
+func catFiles(cmdExec cmd_exec.CmdExec, args, paths []string, filterList []string, flagVals flagVal, onWindows bool) {
+	var wg sync.WaitGroup
+	fetcher := downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, false, onWindows)
+	fetcher.SetInstanceManager(instances)
+	defer fetcher.Close()
+
+	if !CatAsTar(args, paths, flagVals.Tar_flag) {
+		content, err := fetcher.Fetch(catPath(paths[0]))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, createMessage("Error: cannot download "+catPath(paths[0]), "red+b", onWindows))
+			os.Exit(1)
+		}
+
+		os.Stdout.Write(content)
+		return
+	}
+
+	failed := WriteTar(os.Stdout, fetcher, parser, paths, filterList, flagVals.Verbose_flag, onWindows)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d files or directories could not be downloaded.\n", failed)
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function writes the files at 'paths', and every file below those that are directories, to
+*	'out' as a tar stream and returns how many files or directories could not be added. The
+*	directories are walked first, then the files are fetched and written one after another, as
+*	the tar stream can only be written by one goroutine.
+ */
+func WriteTar(out io.Writer, fetcher downloader.Downloader, parser dir_parser.Parser, paths, filterList []string, verbose, onWindows bool) int {
+	var mu sync.Mutex
+	var readPaths []string
+	failed := 0
+
+	w := walker.NewWalker(parser, walker.DefaultWorkers, walker.DefaultWorkers)
+	for _, val := range paths {
+		readPath := catPath(val)
+		if !strings.HasSuffix(readPath, "/") {
+			readPaths = append(readPaths, readPath)
+			continue
+		}
+
+		var found []string
+		w.Walk(readPath, filterList, func(listing walker.Listing) bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if listing.Failed {
+				failed++
+			}
+			for _, file := range listing.Files {
+				found = append(found, file.ReadPath)
+			}
+			return true
+		})
+		sort.Strings(found)
+		readPaths = append(readPaths, found...)
+	}
+
+	archive := tar.NewWriter(out)
+	for _, val := range readPaths {
+		content, err := fetcher.Fetch(val)
+		if err == nil {
+			err = archive.WriteHeader(&tar.Header{
+				Name:     strings.TrimPrefix(val, "/"),
+				Mode:     0644,
+				Size:     int64(len(content)),
+				ModTime:  time.Now(),
+				Typeflag: tar.TypeReg,
+			})
+		}
+		if err == nil {
+			_, err = archive.Write(content)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, createMessage(" Error: cannot download "+val, "yellow", onWindows))
+		} else if verbose {
+			fmt.Fprintln(os.Stderr, "Writing file:", val)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		failed++
+	}
+
+	return failed
+}
+
+/*
+*	This function reports whether the expanded 'paths' are written as a tar stream rather than
+*	as a single file. 'args' are the arguments as given, before globs were expanded.
+ */
+func CatAsTar(args, paths []string, tarFlag bool) bool {
+	if tarFlag || len(paths) != 1 || strings.HasSuffix(paths[0], "/") {
+		return true
+	}
+
+	for _, val := range args {
+		if strings.HasPrefix(val, "-") {
+			break
+		}
+		if remote_glob.IsPattern(val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the app path of a PATH argument or glob match, keeping the trailing slash of a directory
+func catPath(p string) string {
+	readPath := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && readPath != "/" {
+		readPath += "/"
+	}
+
+	return readPath
+}
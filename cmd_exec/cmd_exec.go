@@ -4,6 +4,11 @@ import "os/exec"
 
 type CmdExec interface {
 	GetFile(appName, readPath, instance string) ([]byte, error)
+	GetAppStatus(appName string) ([]byte, error)
+	GetAppGuid(appName string) ([]byte, error)
+	Curl(path string) ([]byte, error)
+	CurlToFile(path, file string) ([]byte, error)
+	Ssh(appName, instance, command string) ([]byte, error)
 }
 
 type cmdExec struct {
@@ -20,3 +25,42 @@ func (c *cmdExec) GetFile(appName, readPath, instance string) ([]byte, error) {
 
 	return output, err
 }
+
+func (c *cmdExec) GetAppStatus(appName string) ([]byte, error) {
+	// call cf app using os/exec, the output lists the state of every instance
+	cmd := exec.Command("cf", "app", appName)
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
+
+func (c *cmdExec) GetAppGuid(appName string) ([]byte, error) {
+	cmd := exec.Command("cf", "app", appName, "--guid")
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
+
+// calls the cloud controller API, 'path' is e.g. /v3/apps/GUID
+func (c *cmdExec) Curl(path string) ([]byte, error) {
+	cmd := exec.Command("cf", "curl", path)
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
+
+// like Curl(), but the response body is written to 'file', for large or binary responses
+func (c *cmdExec) CurlToFile(path, file string) ([]byte, error) {
+	cmd := exec.Command("cf", "curl", path, "--output", file)
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
+
+// runs a shell command in the app instance with cf ssh, it fails if ssh is disabled for the app
+func (c *cmdExec) Ssh(appName, instance, command string) ([]byte, error) {
+	cmd := exec.Command("cf", "ssh", appName, "-i", instance, "-c", command)
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
@@ -1,6 +1,7 @@
 package cmd_exec_fake
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 )
@@ -9,15 +10,45 @@ type FakeCmdExec interface {
 	GetFile(appName, readPath, instance string) ([]byte, error)
 	SetOutput(output string)
 	SetFakeDir(flag bool)
+	GetAppStatus(appName string) ([]byte, error)
+	SetAppStatus(output string)
+	SetFailingInstance(instance string)
+	SetFailingPath(readPath string)
+	SetListings(listings map[string]string)
+	SetListingFunc(listing func(readPath string) (string, bool))
+	GetAppGuid(appName string) ([]byte, error)
+	SetAppGuid(output string)
+	Curl(path string) ([]byte, error)
+	SetCurlOutput(path, output string)
+	CurlToFile(path, file string) ([]byte, error)
+	Ssh(appName, instance, command string) ([]byte, error)
+	SetSshOutput(output string)
+	GetSshCommand() string
 }
 
 type cmdExec struct {
-	output     string
-	useFakeDir bool
+	output          string
+	useFakeDir      bool
+	appStatus       string
+	failingInstance string
+	failingPath     string
+	listing         func(readPath string) (string, bool)
+	appGuid         string
+	curlOutput      map[string]string
+	sshOutput       string
+	sshCommand      string
 }
 
 func NewCmdExec() FakeCmdExec {
-	return &cmdExec{}
+	return &cmdExec{curlOutput: make(map[string]string)}
+}
+
+// returns a fake answering cf files requests with the given listings, see SetListings()
+func NewListingCmdExec(listings map[string]string) FakeCmdExec {
+	c := NewCmdExec()
+	c.SetListings(listings)
+
+	return c
 }
 
 func (c *cmdExec) SetOutput(output string) {
@@ -28,8 +59,96 @@ func (c *cmdExec) SetFakeDir(flag bool) {
 	c.useFakeDir = flag
 }
 
+func (c *cmdExec) SetAppStatus(output string) {
+	c.appStatus = output
+}
+
+// requests made against the failing instance behave as if the instance had crashed
+func (c *cmdExec) SetFailingInstance(instance string) {
+	c.failingInstance = instance
+}
+
+// requests for the failing path fail the way cf files does for a missing file
+func (c *cmdExec) SetFailingPath(readPath string) {
+	c.failingPath = readPath
+}
+
+// answers requests from a map of paths to listings, paths missing from the map fail the way cf
+// files does for an unknown path
+func (c *cmdExec) SetListings(listings map[string]string) {
+	c.SetListingFunc(func(readPath string) (string, bool) {
+		listing, ok := listings[readPath]
+		return listing, ok
+	})
+}
+
+// answers requests with the listing returned for the path, like SetListings() for trees that are
+// too large to hold in a map
+func (c *cmdExec) SetListingFunc(listing func(readPath string) (string, bool)) {
+	c.listing = listing
+}
+
+func (c *cmdExec) GetAppStatus(appName string) ([]byte, error) {
+	return []byte(c.appStatus), nil
+}
+
+func (c *cmdExec) SetAppGuid(output string) {
+	c.appGuid = output
+}
+
+func (c *cmdExec) GetAppGuid(appName string) ([]byte, error) {
+	return []byte(c.appGuid), nil
+}
+
+func (c *cmdExec) SetCurlOutput(path, output string) {
+	c.curlOutput[path] = output
+}
+
+func (c *cmdExec) Curl(path string) ([]byte, error) {
+	return []byte(c.curlOutput[path]), nil
+}
+
+func (c *cmdExec) CurlToFile(path, file string) ([]byte, error) {
+	return nil, ioutil.WriteFile(file, []byte(c.curlOutput[path]), 0644)
+}
+
+// without output ssh behaves as if it was disabled for the app
+func (c *cmdExec) SetSshOutput(output string) {
+	c.sshOutput = output
+}
+
+// returns the command of the last Ssh() call
+func (c *cmdExec) GetSshCommand() string {
+	return c.sshCommand
+}
+
+func (c *cmdExec) Ssh(appName, instance, command string) ([]byte, error) {
+	c.sshCommand = command
+	if c.sshOutput == "" {
+		return []byte("Error opening SSH connection: ssh is disabled for this app"), errors.New("exit status 1")
+	}
+
+	return []byte(c.sshOutput), nil
+}
+
 func (c *cmdExec) GetFile(appName, readPath, instance string) ([]byte, error) {
 	var output []byte
+	if c.failingInstance != "" && instance == c.failingInstance {
+		return []byte("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error, status code: 400, error code: 220001, message: Instances error\n"), nil
+	}
+
+	if c.failingPath != "" && readPath == c.failingPath {
+		return []byte("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error, status code: 404, error code: 190001, message: File not found\n"), nil
+	}
+
+	if c.listing != nil {
+		listing, ok := c.listing(readPath)
+		if !ok {
+			return []byte("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error, status code: 404, error code: 190001, message: File not found\n"), nil
+		}
+		return []byte("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\n\n" + listing), nil
+	}
+
 	if c.useFakeDir == false {
 		return []byte(c.output), nil
 	}
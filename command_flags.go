@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the flags every subcommand takes, Instance_flag is empty for a command without -i
+type commonFlagVal struct {
+	Omit_flag     string
+	Instance_flag string
+	Verbose_flag  bool
+}
+
+// the flag set of a subcommand, holding the flags every subcommand takes
+type commandFlags struct {
+	*flag.FlagSet
+	command  string
+	omit     *string
+	instance *int
+	verbose  *bool
+}
+
+/*
+*	This function is called first by every subcommand but download. It exits with the help of the
+*	command unless 'args' holds 'positional' arguments after the command name that are not flags,
+*	'missing' names them in the error. It also refuses to run with CF_TRACE set, which breaks parsing.
+ */
+func StartCommand(args []string, positional int, missing string) {
+	onWindows := IsWindows()
+
+	for i := 1; i <= positional; i++ {
+		if len(args) <= i || strings.HasPrefix(args[i], "-") {
+			fmt.Println(createMessage("\nError: Missing "+missing, "red+b", onWindows))
+			printCommandHelp(args[0])
+			os.Exit(1)
+		}
+	}
+
+	if os.Getenv("CF_TRACE") == "true" {
+		fmt.Println("\nError: environment variable CF_TRACE is set to true. This prevents " + args[0] + " from succeeding.")
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function returns the flag set of a subcommand that takes none of the common flags.
+ */
+func newFlagSet(command string) *commandFlags {
+	return &commandFlags{FlagSet: flag.NewFlagSet("f1", flag.ContinueOnError), command: command}
+}
+
+/*
+*	This function returns the flag set of a subcommand with --omit, --verbose and, unless
+*	'instance' is false, -i defined.
+ */
+func newCommandFlags(command string, instance bool) *commandFlags {
+	f := newFlagSet(command)
+
+	f.omit = f.String("omit", "", "--omit path/to/some/file")
+	if instance {
+		f.instance = f.Int("i", 0, "-i [instanceNum]")
+	}
+	f.verbose = f.Bool("verbose", false, "--verbose")
+
+	return f
+}
+
+/*
+*	ParsePath() reads the optional PATH at args[index] as a directory of the app, the whole app
+*	without one, and parses the flags that follow it.
+ */
+func (f *commandFlags) ParsePath(args []string, index int) (string, error) {
+	readPath := "/"
+	if len(args) > index && !strings.HasPrefix(args[index], "-") {
+		readPath = DirPath(args[index])
+		index++
+	}
+	if index > len(args) {
+		index = len(args)
+	}
+
+	return readPath, f.Parse(args[index:])
+}
+
+/*
+*	Common() returns the values of the flags every subcommand takes.
+ */
+func (f *commandFlags) Common() commonFlagVal {
+	flagVals := commonFlagVal{
+		Omit_flag:    *f.omit,
+		Verbose_flag: *f.verbose,
+	}
+	if f.instance != nil {
+		flagVals.Instance_flag = strconv.Itoa(*f.instance)
+	}
+
+	return flagVals
+}
+
+/*
+*	ExitOnError() prints a parsing error with the help of the command and exits.
+ */
+func (f *commandFlags) ExitOnError(err error) {
+	if err == nil {
+		return
+	}
+
+	fmt.Println("\nError: ", err)
+	fmt.Println("")
+	printCommandHelp(f.command)
+	os.Exit(1)
+}
+
+/*
+*	This function turns a PATH argument into the app path of a directory, with a leading and a
+*	trailing slash.
+ */
+func DirPath(arg string) string {
+	readPath := path.Clean("/" + filepath.ToSlash(arg))
+	if readPath != "/" {
+		readPath += "/"
+	}
+
+	return readPath
+}
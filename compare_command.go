@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/tree_diff"
+)
+
+// contains the flag values of cf download-compare
+type compareFlagVal struct {
+	commonFlagVal
+	Patch_flag bool
+	Json_flag  bool
+}
+
+// one side of cf download-compare, given as APP_NAME or APP_NAME:INSTANCE
+type compareTarget struct {
+	App      string `json:"app"`
+	Instance string `json:"instance"`
+}
+
+// the result of cf download-compare as written with --json
+type compareResult struct {
+	A       compareTarget   `json:"a"`
+	B       compareTarget   `json:"b"`
+	Path    string          `json:"path"`
+	Changes []compareChange `json:"changes"`
+	Failed  []string        `json:"failed"`
+}
+
+type compareChange struct {
+	tree_diff.Change
+	Patch string `json:"patch,omitempty"`
+}
+
+/*
+*	RunCompare() is the entry point of cf download-compare. It walks the files below PATH, or the
+*	whole app, on two app instances at the same time and reports what only one of them has and
+*	what differs in size. It exits with 1 if the two differ.
+ */
+func RunCompare(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 2, "the two apps to compare")
+
+	flagVals, readPath := ParseCompareArgs(args)
+	a, b := ParseTarget(args[1]), ParseTarget(args[2])
+
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterList(flagVals.Omit_flag, flagVals.Verbose_flag)
+
+	sourceA := tree_diff.NewRemoteSource(cmdExec, a.App, a.Instance, readPath, onWindows, flagVals.Verbose_flag)
+	sourceB := tree_diff.NewRemoteSource(cmdExec, b.App, b.Instance, readPath, onWindows, flagVals.Verbose_flag)
+	differ := tree_diff.NewDiffer(sourceA, sourceB, readPath, filterList, tree_diff.DefaultWorkers)
+
+	if !flagVals.Json_flag {
+		fmt.Printf("Comparing %s instance %s with %s instance %s below %s\n\n", a.App, a.Instance, b.App, b.Instance, readPath)
+	}
+
+	result := compareResult{A: a, B: b, Path: readPath, Changes: []compareChange{}}
+	for _, val := range differ.Diff() {
+		change := compareChange{Change: val}
+		if flagVals.Patch_flag && val.Kind == tree_diff.Modified {
+			patch, err := tree_diff.Patch(sourceA, sourceB, val)
+			if err != nil {
+				patch = "could not be compared: " + err.Error() + "\n"
+			}
+			change.Patch = patch
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	result.Failed = append([]string{}, differ.GetFailed()...)
+
+	if flagVals.Json_flag {
+		output, err := json.MarshalIndent(result, "", "  ")
+		check(err, "Called by: MarshalIndent")
+		fmt.Println(string(output))
+	} else {
+		printCompareResult(result, onWindows)
+	}
+
+	if len(result.Changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-compare and returns the app path to compare,
+*	given as the optional argument after the two apps.
+ */
+func ParseCompareArgs(args []string) (compareFlagVal, string) {
+	// the instances are part of the two targets, so there is no -i
+	f1 := newCommandFlags("download-compare", false)
+	patchp := f1.Bool("patch", false, "--patch")
+	jsonp := f1.Bool("json", false, "--json")
+
+	readPath, err := f1.ParsePath(args, 3)
+	f1.ExitOnError(err)
+
+	flagVals := compareFlagVal{
+		commonFlagVal: f1.Common(),
+		Patch_flag:    *patchp,
+		Json_flag:     *jsonp,
+	}
+
+	return flagVals, readPath
+}
+
+/*
+*	This function splits APP_NAME:INSTANCE, the instance defaults to 0.
+ */
+func ParseTarget(arg string) compareTarget {
+	target := compareTarget{App: arg, Instance: "0"}
+
+	if i := strings.LastIndex(arg, ":"); i > 0 {
+		if instance, err := strconv.Atoi(arg[i+1:]); err == nil && instance >= 0 {
+			target.App = arg[:i]
+			target.Instance = strconv.Itoa(instance)
+		}
+	}
+
+	return target
+}
+
+// prints the result of cf download-compare as text
+func printCompareResult(result compareResult, onWindows bool) {
+	nameA := result.A.App + ":" + result.A.Instance
+	nameB := result.B.App + ":" + result.B.Instance
+
+	onlyA, onlyB, modified := 0, 0, 0
+	for _, val := range result.Changes {
+		switch val.Kind {
+		case tree_diff.Removed:
+			onlyA++
+			fmt.Println(createMessage("<  "+val.Path+" ("+formatDiffSize(val.SizeA)+", only on "+nameA+")", "red", onWindows))
+		case tree_diff.Added:
+			onlyB++
+			fmt.Println(createMessage(">  "+val.Path+" ("+formatDiffSize(val.SizeB)+", only on "+nameB+")", "green", onWindows))
+		case tree_diff.Modified:
+			modified++
+			fmt.Println(createMessage("M  "+val.Path+" ("+formatDiffSize(val.SizeA)+" -> "+formatDiffSize(val.SizeB)+")", "yellow", onWindows))
+		}
+	}
+
+	for _, val := range result.Changes {
+		if val.Patch != "" {
+			fmt.Print("\n" + val.Patch)
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		fmt.Println("\nThe following directories could not be listed and were not compared:")
+		PrintSlice(result.Failed)
+	}
+
+	fmt.Printf("\n%d differences: %d only on %s, %d only on %s, %d modified.\n", len(result.Changes), onlyA, nameA, onlyB, nameB, modified)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/tree_diff"
+)
+
+// contains the flag values of cf download-diff
+type diffFlagVal struct {
+	commonFlagVal
+	Patch_flag bool
+}
+
+/*
+*	RunDiff() is the entry point of cf download-diff. It compares the app's files below PATH, or
+*	the whole app, with LOCAL_DIR and exits with 1 if they differ.
+ */
+func RunDiff(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 2, "App Name or LOCAL_DIR")
+
+	flagVals, readPath := ParseDiffArgs(args)
+	appName = args[1]
+	localDir := args[2]
+
+	if info, err := os.Stat(localDir); err != nil || !info.IsDir() {
+		fmt.Println(createMessage("\nError: "+localDir+" is not a local directory.", "red+b", onWindows))
+		os.Exit(1)
+	}
+
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterList(flagVals.Omit_flag, flagVals.Verbose_flag)
+
+	local := tree_diff.NewLocalSource(localDir)
+	remote := tree_diff.NewRemoteSource(cmdExec, appName, flagVals.Instance_flag, readPath, onWindows, flagVals.Verbose_flag)
+	differ := tree_diff.NewDiffer(local, remote, readPath, filterList, tree_diff.DefaultWorkers)
+
+	fmt.Printf("Comparing %s (instance %s) %s with %s\n\n", appName, flagVals.Instance_flag, readPath, localDir)
+	changes := differ.Diff()
+
+	counts := map[tree_diff.Kind]int{}
+	for _, val := range changes {
+		counts[val.Kind]++
+		printChange(val, onWindows)
+	}
+
+	if flagVals.Patch_flag {
+		for _, val := range changes {
+			if val.Kind != tree_diff.Modified {
+				continue
+			}
+
+			patch, err := tree_diff.Patch(local, remote, val)
+			if err != nil {
+				fmt.Println(createMessage(" Server Error: '"+readPath+val.Path+"' could not be compared: "+err.Error(), "yellow", onWindows))
+				continue
+			}
+			fmt.Print("\n" + patch)
+		}
+	}
+
+	failed := differ.GetFailed()
+	if len(failed) > 0 {
+		fmt.Println("\nThe following directories could not be listed and were not compared:")
+		PrintSlice(failed)
+	}
+
+	fmt.Printf("\n%d differences: %d added, %d removed, %d modified.\n", len(changes), counts[tree_diff.Added], counts[tree_diff.Removed], counts[tree_diff.Modified])
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-diff and returns the app path to compare,
+*	given as the optional argument after LOCAL_DIR.
+ */
+func ParseDiffArgs(args []string) (diffFlagVal, string) {
+	f1 := newCommandFlags("download-diff", true)
+	patchp := f1.Bool("patch", false, "--patch")
+
+	readPath, err := f1.ParsePath(args, 3)
+	f1.ExitOnError(err)
+
+	flagVals := diffFlagVal{
+		commonFlagVal: f1.Common(),
+		Patch_flag:    *patchp,
+	}
+
+	return flagVals, readPath
+}
+
+// prints one difference, e.g. "M  app/server.js (2.0K -> 2.1K)"
+func printChange(change tree_diff.Change, onWindows bool) {
+	switch change.Kind {
+	case tree_diff.Added:
+		fmt.Println(createMessage("A  "+change.Path+" ("+formatDiffSize(change.SizeB)+")", "green", onWindows))
+	case tree_diff.Removed:
+		fmt.Println(createMessage("D  "+change.Path, "red", onWindows))
+	case tree_diff.Modified:
+		fmt.Println(createMessage("M  "+change.Path+" ("+formatDiffSize(change.SizeA)+" -> "+formatDiffSize(change.SizeB)+")", "yellow", onWindows))
+	}
+}
+
+func formatDiffSize(size int64) string {
+	if size < 0 {
+		return "?"
+	}
+
+	return dir_parser.FormatSize(size)
+}
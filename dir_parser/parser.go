@@ -3,38 +3,75 @@ package dir_parser
 import (
 	"fmt"
 	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/instance_manager"
 	"github.com/mgutz/ansi"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Parser interface {
+	ListDir(readPath string) ([]Entry, bool)
 	ExecParseDir(readPath string) ([]string, []string)
 	GetFailedDownloads() []string
+	GetFailedCount() int
 	GetDirectory(readPath string) (string, string)
+	SetInstanceManager(instances instance_manager.InstanceManager)
+}
+
+// what a listed entry is
+type Kind int
+
+const (
+	File Kind = iota
+	Dir
+)
+
+/*
+*	Entry is one entry of a directory listing. Name is as listed, directories end in a slash so the
+*	entry's path is the directory's path followed by Name. Size is in bytes, only as precise as the
+*	listing (e.g. 1.1K), and -1 if unknown. cf files lists neither modification times nor modes, so
+*	ModTime is zero and Mode 0 unless the listing has them.
+ */
+type Entry struct {
+	Name    string
+	Kind    Kind
+	Size    int64
+	ModTime time.Time
+	Mode    os.FileMode
 }
 
 type parser struct {
 	cmdExec         cmd_exec.CmdExec
 	appName         string
-	instance        string
+	instances       instance_manager.InstanceManager
 	onWindows       bool
 	verbose         bool
 	failedDownloads []string
+	mu              sync.Mutex
 }
 
 func NewParser(cmdExec cmd_exec.CmdExec, appName, instance string, onWindows, verbose bool) *parser {
 	return &parser{
 		cmdExec:   cmdExec,
 		appName:   appName,
-		instance:  instance,
+		instances: instance_manager.NewInstanceManager(cmdExec, appName, instance, false),
 		onWindows: onWindows,
 		verbose:   verbose,
 	}
 }
 
+/*
+*	SetInstanceManager() replaces the fixed instance given to NewParser with a manager that
+*	can move the download to another instance when the current one goes away.
+ */
+func (p *parser) SetInstanceManager(instances instance_manager.InstanceManager) {
+	p.instances = instances
+}
+
 /*
 *	execParseDir() uses os/exec to shell out commands to cf files with the given readPath. The returned
 *	text contains file and directory structure which is then parsed into two slices, dirs and files. dirs
@@ -42,37 +79,66 @@ func NewParser(cmdExec cmd_exec.CmdExec, appName, instance string, onWindows, ve
 * 	to be downloaded by download() and downloadFile() respectively.
  */
 func (p *parser) ExecParseDir(readPath string) ([]string, []string) {
-	dir, status := p.GetDirectory(readPath)
+	entries, _ := p.ListDir(readPath)
 
+	var files, dirs []string
+	for _, val := range entries {
+		if val.Kind == Dir {
+			dirs = append(dirs, val.Name)
+		} else {
+			files = append(files, val.Name)
+		}
+	}
+
+	return files, dirs
+}
+
+/*
+*	ListDir() lists the directory at 'readPath' with cf files. It also reports whether the
+*	directory could be listed, an empty directory is listed but has no entries.
+ */
+func (p *parser) ListDir(readPath string) ([]Entry, bool) {
+	dir, status := p.GetDirectory(readPath)
 	if status == "OK" {
-		// parse the returned output into files and dirs slices
-		filesSlice := strings.Fields(dir)
-		var files, dirs []string
-		var name string
-		for i := 0; i < len(filesSlice); i++ {
-			if strings.HasSuffix(filesSlice[i], "/") {
-				name += filesSlice[i]
-				dirs = append(dirs, name)
-				name = ""
-			} else if isDelimiter(filesSlice[i]) {
-				if len(name) > 0 {
-					name = strings.TrimSuffix(name, " ")
-					files = append(files, name)
-				}
-				name = ""
-			} else {
-				name += filesSlice[i] + " "
-			}
+		return ParseListing(dir), true
+	}
+
+	//error was already logged in GetDirectory if --verbose was used
+	if readPath == "/" {
+		os.Exit(1)
+	}
+
+	return nil, status == "noFiles"
+}
+
+/*
+*	ParseListing() parses the body of a cf files listing, one entry per line: the name, padding,
+*	then the size column. Only the padding before the size is taken off, so names keep runs of
+*	spaces and tabs. A name cannot end in whitespace, that is indistinguishable from the padding.
+ */
+func ParseListing(listing string) []Entry {
+	var entries []Entry
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+
+		split := strings.LastIndexAny(line, " \t")
+		if split < 0 || !isDelimiter(line[split+1:]) {
+			continue
 		}
-		return files, dirs
-	} else {
-		//error was already logged in GetDirectory if --verbose was used
-		if readPath == "/" {
-			os.Exit(1)
+		name := strings.TrimRight(line[:split], " \t")
+		if name == "" {
+			continue
 		}
+
+		entry := Entry{Name: name, Kind: File, Size: ParseSize(line[split+1:])}
+		if strings.HasSuffix(name, "/") {
+			entry.Kind = Dir
+			entry.Size = -1
+		}
+		entries = append(entries, entry)
 	}
 
-	return nil, nil
+	return entries
 }
 
 /*
@@ -82,7 +148,8 @@ func (p *parser) ExecParseDir(readPath string) ([]string, []string) {
 func (p *parser) GetDirectory(readPath string) (string, string) {
 
 	// make the cf files call using exec
-	output, err := p.cmdExec.GetFile(p.appName, readPath, p.instance)
+	instance := p.instances.Current()
+	output, err := p.cmdExec.GetFile(p.appName, readPath, instance)
 	dirSlice := strings.SplitAfterN(string(output), "\n", 3)
 
 	// if cf files fails to get directory, retry (this code is not covered in tests)
@@ -90,13 +157,24 @@ func (p *parser) GetDirectory(readPath string) (string, string) {
 		iterations := 0
 		for len(dirSlice) < 2 && iterations < 10 {
 			time.Sleep(3 * time.Second)
-			output, err = p.cmdExec.GetFile(p.appName, readPath, p.instance)
+			output, err = p.cmdExec.GetFile(p.appName, readPath, instance)
 			dirSlice = strings.SplitAfterN(string(output), "\n", 3)
 			iterations++
 		}
 	}
 
+	// retry on another instance if the current one has gone away
+	if !instance_manager.ResponseOK(output) {
+		if next, switched := p.instances.Failover(instance); switched {
+			instance = next
+			output, err = p.cmdExec.GetFile(p.appName, readPath, instance)
+			dirSlice = strings.SplitAfterN(string(output), "\n", 3)
+		}
+	}
+
 	if len(dirSlice) >= 2 && strings.Contains(dirSlice[1], "OK") {
+		p.instances.Served(readPath, instance)
+
 		if strings.Contains(dirSlice[2], "No files found") {
 			return "", "noFiles"
 		}
@@ -104,7 +182,9 @@ func (p *parser) GetDirectory(readPath string) (string, string) {
 	} else {
 		message := createMessage(" Server Error: '"+readPath+"' not downloaded", "yellow", p.onWindows)
 
+		p.mu.Lock()
 		p.failedDownloads = append(p.failedDownloads, message)
+		p.mu.Unlock()
 
 		if p.verbose {
 			fmt.Println(message)
@@ -119,17 +199,74 @@ func (p *parser) GetDirectory(readPath string) (string, string) {
 }
 
 func (p *parser) GetFailedDownloads() []string {
-	return p.failedDownloads
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.failedDownloads...)
 }
 
+func (p *parser) GetFailedCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.failedDownloads)
+}
+
+var delimiterRegexp = regexp.MustCompile("^[0-9]([0-9]|.)*(G|M|B|K)$")
+
 func isDelimiter(str string) bool {
-	match, _ := regexp.MatchString("^[0-9]([0-9]|.)*(G|M|B|K)$", str)
+	match := delimiterRegexp.MatchString(str)
 	if match == true || str == "-" {
 		return true
 	}
 	return false
 }
 
+/*
+*	ParseSize() converts a size column such as 136B, 1.1K or 2.0M into bytes. Unknown values,
+*	including the '-' shown for directories, are returned as -1.
+ */
+func ParseSize(str string) int64 {
+	units := map[string]float64{"B": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+
+	if len(str) < 2 {
+		return -1
+	}
+
+	multiplier, ok := units[str[len(str)-1:]]
+	if !ok {
+		return -1
+	}
+
+	value, err := strconv.ParseFloat(str[:len(str)-1], 64)
+	if err != nil {
+		return -1
+	}
+
+	return int64(value * multiplier)
+}
+
+/*
+*	FormatSize() formats bytes the way cf files lists them, e.g. 136B, 1.1K or 2.0M. A local file
+*	and a listed size describe the same size if both format alike, which absorbs the rounding of
+*	the listing.
+ */
+func FormatSize(bytes int64) string {
+	if bytes < 1<<10 {
+		return strconv.FormatInt(bytes, 10) + "B"
+	}
+
+	units := []string{"K", "M", "G"}
+	value := float64(bytes) / (1 << 10)
+	unit := 0
+	for value >= 1<<10 && unit < len(units)-1 {
+		value /= 1 << 10
+		unit++
+	}
+
+	return strconv.FormatFloat(value, 'f', 1, 64) + units[unit]
+}
+
 // prints slices in readable format
 func PrintSlice(slice []string) error {
 	for index, val := range slice {
@@ -1,6 +1,7 @@
 package dir_parser_test
 
 import (
+	"fmt"
 	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
 	. "github.com/ibmjstart/cf-download/dir_parser"
 	. "github.com/onsi/ginkgo"
@@ -46,6 +47,99 @@ var _ = Describe("DirParser", func() {
 		})
 	})
 
+	Describe("Test ListDir()", func() {
+		// names as cf files would list them, padded to the size column
+		corpus := []Entry{
+			{Name: "plain.txt", Kind: File, Size: 136},
+			{Name: "two  spaces.txt", Kind: File, Size: 1126},
+			{Name: "tab\tinside.txt", Kind: File, Size: 95},
+			{Name: " leading space.txt", Kind: File, Size: 2048},
+			{Name: "dir with spaces/", Kind: Dir, Size: -1},
+			{Name: "-dash.log", Kind: File, Size: 1},
+			{Name: "1.5K", Kind: File, Size: 2097152},
+			{Name: "looks like 2.0M", Kind: File, Size: 3},
+			{Name: "ünïcödé ファイル.txt", Kind: File, Size: 20480},
+			{Name: "semi;colon#hash$dollar.txt", Kind: File, Size: 0},
+			{Name: "archive.tar.gz", Kind: File, Size: 775372},
+			{Name: "-/", Kind: Dir, Size: -1},
+		}
+		sizes := []string{"136B", "1.1K", "95B", "2.0K", "-", "1B", "2.0M", "3B", "20.0K", "0B", "757.2K", "-"}
+
+		listing := "\n"
+		for i, val := range corpus {
+			listing += fmt.Sprintf("%-42s%s\n", val.Name, sizes[i])
+		}
+
+		It("Should keep unusual names intact", func() {
+			Ω(ParseListing(listing)).To(Equal(corpus))
+		})
+
+		It("Should list through cf files", func() {
+			cmdExec.SetOutput("Getting files for app smithInTheHouse in org jstart / space evans as email@us.ibm.com...\nOK\n" + listing)
+			entries, listed := p.ListDir("/app/")
+			Ω(listed).To(BeTrue())
+			Ω(entries).To(Equal(corpus))
+
+			files, dirs := p.ExecParseDir("/app/")
+			Ω(files).To(ContainElement("two  spaces.txt"))
+			Ω(dirs).To(Equal([]string{"dir with spaces/", "-/"}))
+		})
+
+		It("Should accept tabs and carriage returns around the size column", func() {
+			Ω(ParseListing("app.js\t\t2.0K\r\nlib/ \t-\r\n")).To(Equal([]Entry{
+				{Name: "app.js", Kind: File, Size: 2048},
+				{Name: "lib/", Kind: Dir, Size: -1},
+			}))
+		})
+
+		It("Should return the listed size of every file", func() {
+			cmdExec.SetOutput("Getting files for app smithInTheHouse in org jstart / space evans as email@us.ibm.com...\nOK\n\n.npmignore 136B\nLICENSE 1.1K\nbin/ -\njade.js 757.2K\nlogs.tar 2.0M\n")
+			entries, _ := p.ListDir("readPath")
+			Ω(entries).To(Equal([]Entry{
+				{Name: ".npmignore", Kind: File, Size: 136},
+				{Name: "LICENSE", Kind: File, Size: 1126},
+				{Name: "bin/", Kind: Dir, Size: -1},
+				{Name: "jade.js", Kind: File, Size: 775372},
+				{Name: "logs.tar", Kind: File, Size: 2097152},
+			}))
+		})
+
+		It("Should list nothing for an empty or failed directory", func() {
+			cmdExec.SetOutput("Getting files for app\nOK\nNo files found")
+			entries, listed := p.ListDir("/app/")
+			Ω(entries).To(BeEmpty())
+			Ω(listed).To(BeTrue())
+
+			cmdExec.SetOutput("Getting files for app\nFAILED\nServer error, status code: 500")
+			_, listed = p.ListDir("/app/")
+			Ω(listed).To(BeFalse())
+		})
+	})
+
+	Describe("Test ParseSize()", func() {
+		It("Should convert listed sizes to bytes", func() {
+			Ω(ParseSize("95B")).To(BeEquivalentTo(95))
+			Ω(ParseSize("20.0K")).To(BeEquivalentTo(20480))
+			Ω(ParseSize("1G")).To(BeEquivalentTo(1 << 30))
+			Ω(ParseSize("-")).To(BeEquivalentTo(-1))
+		})
+	})
+
+	Describe("Test FormatSize()", func() {
+		It("Should format bytes the way cf files lists them", func() {
+			Ω(FormatSize(95)).To(Equal("95B"))
+			Ω(FormatSize(1100)).To(Equal("1.1K"))
+			Ω(FormatSize(20480)).To(Equal("20.0K"))
+			Ω(FormatSize(3 << 30)).To(Equal("3.0G"))
+		})
+
+		It("Should match listed sizes after parsing them", func() {
+			for _, val := range []string{"136B", "1.1K", "20.0K", "2.4M", "1.0G"} {
+				Ω(FormatSize(ParseSize(val))).To(Equal(val))
+			}
+		})
+	})
+
 	Describe("Test GetDirectory()", func() {
 		It("test when app is not found", func() {
 			cmdExec.SetOutput("Getting files for app\nFAILED\nApp APP_NAME not found")
@@ -0,0 +1,188 @@
+package disk_usage
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+type Counter interface {
+	Count(root string, filterList []string) Report
+}
+
+/*
+*	The listed size of a directory and everything below it. Files with an unknown size are
+*	counted but add nothing to Size. Dirs is the number of directories below it.
+ */
+type Usage struct {
+	Path  string
+	Size  int64
+	Files int
+	Dirs  int
+}
+
+// a directory worth leaving out of downloads, Reason says what it holds
+type Recommendation struct {
+	Usage
+	Reason string
+}
+
+/*
+*	The result of Count(). Total covers the root, Heaviest holds every directory below it,
+*	heaviest first. Failed lists the directories that could not be listed, their contents are
+*	missing from the totals.
+ */
+type Report struct {
+	Total    Usage
+	Heaviest []Usage
+	Failed   []string
+}
+
+// directories package managers and buildpacks fill, which can be recreated instead of downloaded
+var dependencyDirs = map[string]string{
+	"node_modules":     "npm dependencies, reinstall them with npm install",
+	"bower_components": "bower dependencies, reinstall them with bower install",
+	"vendor":           "vendored dependencies, reinstall them with the package manager",
+	"vendors":          "Composer dependencies, reinstall them with composer install",
+	".bundle":          "bundler settings and gems, reinstall them with bundle install",
+	".java":            "the Java runtime installed by the buildpack",
+	".liberty":         "the Liberty runtime installed by the buildpack",
+	".java-buildpack":  "the Java buildpack's runtime and frameworks",
+	".heroku":          "the runtime and packages installed by the buildpack",
+	"site-packages":    "Python packages, reinstall them with pip",
+	"__pycache__":      "compiled Python files",
+	".npm":             "the npm cache",
+	".m2":              "the Maven repository",
+	".gradle":          "the Gradle cache",
+	".cache":           "a cache",
+}
+
+type counter struct {
+	parser  dir_parser.Parser
+	workers int
+}
+
+/*
+*	NewCounter() returns a counter listing 'workers' directories at the same time through the
+*	same bounded walker as a download, so only one total per directory is kept in memory however
+*	many files the app has.
+ */
+func NewCounter(parser dir_parser.Parser, workers int) *counter {
+	return &counter{
+		parser:  parser,
+		workers: workers,
+	}
+}
+
+/*
+*	Count() totals the listed sizes and file counts below 'root', leaving out the paths on the
+*	filter list.
+ */
+func (c *counter) Count(root string, filterList []string) Report {
+	var mu sync.Mutex
+	own := make(map[string]*Usage)
+	var failed []string
+
+	w := walker.NewWalker(c.parser, c.workers, c.workers)
+	w.Walk(root, filterList, func(listing walker.Listing) bool {
+		usage := &Usage{Path: listing.ReadPath, Files: len(listing.Files), Dirs: len(listing.Dirs)}
+		for _, val := range listing.Files {
+			if val.Size > 0 {
+				usage.Size += val.Size
+			}
+		}
+
+		mu.Lock()
+		own[listing.ReadPath] = usage
+		if listing.Failed {
+			failed = append(failed, listing.ReadPath)
+		}
+		mu.Unlock()
+
+		return true
+	})
+
+	// add every directory to its parent, deepest first so totals are complete when passed up
+	paths := make([]string, 0, len(own))
+	for val := range own {
+		paths = append(paths, val)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") > strings.Count(paths[j], "/")
+	})
+
+	report := Report{Failed: failed}
+	for _, val := range paths {
+		usage := own[val]
+		if val == root {
+			report.Total = *usage
+			continue
+		}
+
+		if parent, ok := own[parentDir(val)]; ok {
+			parent.Size += usage.Size
+			parent.Files += usage.Files
+			parent.Dirs += usage.Dirs
+		}
+		report.Heaviest = append(report.Heaviest, *usage)
+	}
+
+	sort.Slice(report.Heaviest, func(i, j int) bool {
+		if report.Heaviest[i].Size != report.Heaviest[j].Size {
+			return report.Heaviest[i].Size > report.Heaviest[j].Size
+		}
+		return report.Heaviest[i].Path < report.Heaviest[j].Path
+	})
+	sort.Strings(report.Failed)
+
+	return report
+}
+
+/*
+*	Top() returns the 'n' heaviest directories, or all of them if there are fewer.
+ */
+func (r Report) Top(n int) []Usage {
+	if n < len(r.Heaviest) {
+		return r.Heaviest[:n]
+	}
+
+	return r.Heaviest
+}
+
+/*
+*	Recommend() returns the dependency and buildpack directories of at least 'threshold' bytes,
+*	heaviest first. A directory inside one that is already recommended is left out.
+ */
+func (r Report) Recommend(threshold int64) []Recommendation {
+	var recommended []Recommendation
+	for _, val := range r.Heaviest {
+		reason, ok := dependencyDirs[path.Base(val.Path)]
+		if !ok || val.Size < threshold {
+			continue
+		}
+
+		inside := false
+		for _, rec := range recommended {
+			inside = inside || strings.HasPrefix(val.Path, rec.Path)
+		}
+		if !inside {
+			recommended = append(recommended, Recommendation{Usage: val, Reason: reason})
+		}
+	}
+
+	return recommended
+}
+
+// the directory holding 'readPath', with a trailing slash
+func parentDir(readPath string) string {
+	parent := path.Dir(strings.TrimSuffix(readPath, "/"))
+	if parent == "/" {
+		return parent
+	}
+
+	return parent + "/"
+}
@@ -0,0 +1,13 @@
+package disk_usage_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DiskUsage Suite")
+}
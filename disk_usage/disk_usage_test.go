@@ -0,0 +1,82 @@
+package disk_usage_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	. "github.com/ibmjstart/cf-download/disk_usage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// lists 'count' files of 'size' each
+func files(count int, size string) string {
+	var listing []string
+	for i := 0; i < count; i++ {
+		listing = append(listing, fmt.Sprintf("file%d.js                                %s", i, size))
+	}
+
+	return strings.Join(listing, "\n") + "\n"
+}
+
+// the listings of the fake app, directories missing from the map cannot be listed
+var app = map[string]string{
+	"/":                          "app/                                      -\nlogs/                                     -\n",
+	"/app/":                      "server.js                                 2.0K\nnode_modules/                             -\n.java/                                    -\n",
+	"/app/node_modules/":         "express/                                  -\n" + files(3, "1.0M"),
+	"/app/node_modules/express/": "node_modules/                             -\n" + files(10, "1.0M"),
+	"/app/node_modules/express/node_modules/": files(20, "1.0M"),
+	"/app/.java/": files(2, "4.0K"),
+}
+
+var _ = Describe("DiskUsage", func() {
+	var counter Counter
+
+	BeforeEach(func() {
+		counter = NewCounter(dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(app), "du", "0", false, false), 3)
+	})
+
+	Describe("Test Count()", func() {
+		It("Should total every directory", func() {
+			report := counter.Count("/", nil)
+
+			Ω(report.Total.Size).To(Equal(int64(33<<20 + 2048 + 8192)))
+			Ω(report.Total.Files).To(Equal(36))
+			Ω(report.Total.Dirs).To(Equal(6))
+			Ω(report.Failed).To(Equal([]string{"/logs/"}))
+
+			Ω(report.Top(3)).To(Equal([]Usage{
+				{Path: "/app/", Size: 33<<20 + 2048 + 8192, Files: 36, Dirs: 4},
+				{Path: "/app/node_modules/", Size: 33 << 20, Files: 33, Dirs: 2},
+				{Path: "/app/node_modules/express/", Size: 30 << 20, Files: 30, Dirs: 1},
+			}))
+			Ω(report.Top(100)).To(HaveLen(6))
+		})
+
+		It("Should leave out omitted paths", func() {
+			report := counter.Count("/app/", []string{"/app/node_modules"})
+
+			Ω(report.Total).To(Equal(Usage{Path: "/app/", Size: 2048 + 8192, Files: 3, Dirs: 1}))
+			Ω(report.Failed).To(BeEmpty())
+		})
+	})
+
+	Describe("Test Recommend()", func() {
+		It("Should recommend the outermost dependency directories over the threshold", func() {
+			recommended := counter.Count("/", nil).Recommend(10 << 20)
+
+			Ω(recommended).To(HaveLen(1))
+			Ω(recommended[0].Path).To(Equal("/app/node_modules/"))
+			Ω(recommended[0].Reason).To(ContainSubstring("npm install"))
+		})
+
+		It("Should recommend small buildpack directories with a low threshold", func() {
+			recommended := counter.Count("/", nil).Recommend(1024)
+
+			Ω(recommended).To(HaveLen(2))
+			Ω(recommended[1].Path).To(Equal("/app/.java/"))
+		})
+	})
+})
@@ -13,41 +13,145 @@ import (
 	"github.com/ibmjstart/cf-download/cmd_exec"
 	"github.com/ibmjstart/cf-download/dir_parser"
 	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/scheduler"
+	"github.com/ibmjstart/cf-download/snapshot"
+	"github.com/ibmjstart/cf-download/walker"
 	"github.com/mgutz/ansi"
 )
 
 type Downloader interface {
 	Download(files, dirs []string, readPath, writePath string, filterList []string) error
+	DownloadDir(readPath, writePath string, filterList []string) error
 	DownloadFile(readPath, writePath string) error
 	WriteFile(readPath, writePath string, output []byte, err error) error
 	CheckDownload(readPath string, file []string, err error) error
 	GetFilesDownloadedCount() int
 	GetFailedDownloads() []string
+	SetInstanceManager(instances instance_manager.InstanceManager)
+	SetRestartGuard(guard instance_manager.RestartGuard)
+	Recheck(readRoot, writeRoot string, filterList []string) int
+	SetSchedulePolicy(policy scheduler.Policy)
+	SetLimits(limits Limits)
+	GetAbortReason() string
+	GetBytesDownloaded() int64
+	GetSkippedCount() int
+	SetSync(enabled bool)
+	GetSyncCounts() (int, int, int)
+	SetMirror(enabled bool)
+	SetDryRun(enabled bool)
+	IsDryRun() bool
+	GetRemoved() []string
+	GetPlan() []Plan
+	SetSnapshot(recorder snapshot.Recorder)
+	SetMatch(match filter.Predicate)
+	Fetch(readPath string) ([]byte, error)
+	Close()
 }
 
 type downloader struct {
 	cmdExec         cmd_exec.CmdExec
 	appName         string
-	instance        string
+	instances       instance_manager.InstanceManager
+	guard           instance_manager.RestartGuard
 	verbose         bool
 	onWindows       bool
 	failedDownloads []string
 	filesDownloaded int
+	bytesWritten    int64
+	limits          Limits
+	filesReserved   int
+	bytesReserved   int64
+	abortReason     string
+	skipped         int
+	sync            bool
+	syncNew         int
+	syncUpdated     int
+	syncUnchanged   int
+	mirror          bool
+	dryRun          bool
+	removed         []string
+	plans           []*Plan
+	snapshot        snapshot.Recorder
+	match           filter.Predicate
 	parser          dir_parser.Parser
+	scheduler       scheduler.Scheduler
+	walker          walker.Walker
 	wg              *sync.WaitGroup
+	mu              sync.Mutex
 }
 
 func NewDownloader(cmdExec cmd_exec.CmdExec, WG *sync.WaitGroup, appName, instance string, verbose, onWindows bool) *downloader {
 
-	return &downloader{
+	d := &downloader{
 		cmdExec:   cmdExec,
 		appName:   appName,
-		instance:  instance,
+		instances: instance_manager.NewInstanceManager(cmdExec, appName, instance, false),
 		verbose:   verbose,
 		onWindows: onWindows,
 		parser:    dir_parser.NewParser(cmdExec, appName, instance, onWindows, verbose),
 		wg:        WG,
 	}
+	d.scheduler = scheduler.NewScheduler(scheduler.DefaultWorkers, scheduler.DefaultQueueSize, func(file scheduler.File) {
+		d.DownloadFile(file.ReadPath, file.WritePath)
+	})
+	d.walker = walker.NewWalker(d.parser, walker.DefaultWorkers, walker.DefaultWorkers)
+
+	return d
+}
+
+/*
+*	SetInstanceManager() lets the downloader, and the parser it lists directories with, follow
+*	the instance selected by the given manager instead of the fixed instance passed to NewDownloader.
+ */
+func (d *downloader) SetInstanceManager(instances instance_manager.InstanceManager) {
+	d.instances = instances
+	d.parser.SetInstanceManager(instances)
+}
+
+/*
+*	SetRestartGuard() reports every written file to the guard so it can tell which files were
+*	fetched after a restart of the instance.
+ */
+func (d *downloader) SetRestartGuard(guard instance_manager.RestartGuard) {
+	d.guard = guard
+}
+
+/*
+*	SetSnapshot() makes the downloader add every file to a snapshot instead of writing it below
+*	the write path. No local directories are created.
+ */
+func (d *downloader) SetSnapshot(recorder snapshot.Recorder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.snapshot = recorder
+}
+
+/*
+*	SetMatch() limits the download to the files 'match' selects. Local directories are then only
+*	created for files that are fetched.
+ */
+func (d *downloader) SetMatch(match filter.Predicate) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.match = match
+}
+
+/*
+*	Close() stops the download workers once the queued files are written. A later download starts
+*	them again.
+ */
+func (d *downloader) Close() {
+	d.scheduler.Close()
+}
+
+/*
+*	SetSchedulePolicy() sets the order in which queued files are downloaded.
+ */
+func (d *downloader) SetSchedulePolicy(policy scheduler.Policy) {
+	d.scheduler.SetPolicy(policy)
 }
 
 // error struct that allows appending error messages
@@ -59,9 +163,9 @@ type cliError struct {
 /*
 *	given file and directory names, download() will download the files from
 * 	'readPath' and write them to disk on the 'writepath'.
-* 	the function calls it's self recursively for each directory as it travels down the tree.
-* 	Each call runs on a seperate go routine and and calls a go routine for every
-* 	file download.
+* 	the sub directories are listed and downloaded by the walker as it travels down the tree.
+* 	File downloads are handed to the scheduler, which runs them on its workers in the
+* 	order set by the schedule policy.
  */
 func (d *downloader) Download(files, dirs []string, readPath, writePath string, filterList []string) error {
 	defer d.wg.Done()
@@ -70,37 +174,103 @@ func (d *downloader) Download(files, dirs []string, readPath, writePath string,
 	err := os.MkdirAll(writePath, 0755)
 	check(err, "Error D1: failed to create directory.")
 
-	// download each file
+	// queue each file, their sizes are unknown
+	var jobs []scheduler.File
 	for _, val := range files {
-		fileWPath := writePath + val
 		fileRPath := readPath + val
 
 		if filter.CheckToFilter(fileRPath, filterList) {
 			continue
 		}
 
-		d.wg.Add(1)
-		go d.DownloadFile(fileRPath, fileWPath)
+		jobs = append(jobs, scheduler.File{ReadPath: fileRPath, WritePath: writePath + val, Size: -1})
 	}
+	d.wg.Add(len(jobs))
+	d.scheduler.Submit(jobs)
 
-	// call download on every sub directory
+	// walk every sub directory
 	for _, val := range dirs {
-		dirWPath := writePath + filepath.FromSlash(val)
 		dirRPath := readPath + val
 
 		if filter.CheckToFilter(strings.TrimSuffix(dirRPath, "/"), filterList) {
 			continue
 		}
 
-		err := os.MkdirAll(dirWPath, 0755)
-		check(err, "Error D2: failed to create directory.")
+		d.walker.Walk(dirRPath, filterList, d.visitor(dirRPath, writePath+filepath.FromSlash(val), filterList))
+	}
+	return nil
+}
+
+/*
+*	DownloadDir() lists 'readPath' and downloads it like Download(). Unlike a caller passing in
+*	names, the listing gives the scheduler the size of every file.
+ */
+func (d *downloader) DownloadDir(readPath, writePath string, filterList []string) error {
+	defer d.wg.Done()
+
+	d.walker.Walk(readPath, filterList, d.visitor(readPath, writePath, filterList))
+
+	return nil
+}
+
+/*
+*	visitor() returns the function the walker calls for each directory below 'readRoot'. It creates
+*	the matching local directory below 'writeRoot', removes what the instance no longer has when
+*	mirroring, and queues the directory's files. Queueing blocks while the scheduler is full, so the
+*	walk never gets far ahead of the downloads.
+ */
+func (d *downloader) visitor(readRoot, writeRoot string, filterList []string) walker.VisitFunc {
+	return func(listing walker.Listing) bool {
+		// stop walking once a threshold was exceeded, a failed listing may have done it
+		d.mu.Lock()
+		d.checkFailures()
+		aborted := d.abortReason != ""
+		d.mu.Unlock()
+		if aborted {
+			d.walker.Stop()
+			return false
+		}
+
+		writePath := writeRoot + filepath.FromSlash(strings.TrimPrefix(listing.ReadPath, readRoot))
+
+		d.mu.Lock()
+		dryRun, snapshotting, match := d.dryRun, d.snapshot != nil, d.match
+		d.mu.Unlock()
+
+		if !dryRun && !snapshotting && match.Empty() {
+			err := os.MkdirAll(writePath, 0755)
+			check(err, "Error D2: failed to create directory.")
+		}
+		d.prune(listing, writePath, writeRoot, filterList)
+
+		jobs := make([]scheduler.File, 0, len(listing.Files))
+		for _, val := range listing.Files {
+			if match.Match(val.Name, false, val.Size) {
+				jobs = append(jobs, scheduler.File{ReadPath: val.ReadPath, WritePath: writePath + val.Name, Size: val.Size})
+			}
+		}
+		jobs = d.unchanged(jobs)
+
+		// with a match, only the directories holding a selected file are created
+		if !dryRun && !snapshotting && !match.Empty() && len(jobs) > 0 {
+			err := os.MkdirAll(writePath, 0755)
+			check(err, "Error D2: failed to create directory.")
+		}
 
-		files, dirs = d.parser.ExecParseDir(dirRPath)
+		// count what would be fetched instead of fetching it
+		if dryRun {
+			for _, val := range jobs {
+				d.countSynced(d.replacing(val.WritePath))
+			}
+			d.addPlan(readRoot, listing, jobs)
+			return true
+		}
 
-		d.wg.Add(1)
-		d.Download(files, dirs, dirRPath, dirWPath, filterList)
+		d.wg.Add(len(jobs))
+		d.scheduler.Submit(jobs)
+
+		return true
 	}
-	return nil
 }
 
 /*
@@ -111,13 +281,79 @@ func (d *downloader) Download(files, dirs []string, readPath, writePath string,
 func (d *downloader) DownloadFile(readPath, writePath string) error {
 	defer d.wg.Done()
 
-	output, err := d.cmdExec.GetFile(d.appName, readPath, d.instance)
+	if d.skipIfAborted() {
+		return nil
+	}
+
+	if d.IsDryRun() {
+		d.countSynced(d.replacing(writePath))
+		d.addPlanFile(readPath)
+		return nil
+	}
+
+	instance := d.instances.Current()
+	output, err := d.cmdExec.GetFile(d.appName, readPath, instance)
+
+	// retry on another instance if the current one has gone away
+	if !instance_manager.ResponseOK(output) {
+		if next, switched := d.instances.Failover(instance); switched {
+			output, err = d.cmdExec.GetFile(d.appName, readPath, next)
+		}
+	}
 
 	d.WriteFile(readPath, writePath, output, err)
 
 	return nil
 }
 
+/*
+*	Recheck() downloads the files of every directory below 'readRoot' again if its listing came from
+*	an instance other than the current one. Files fetched before a failover may belong to the old
+*	instance's filesystem, so they are refreshed from the instance that finished the download.
+*	Sub directories are not followed, they carry their own record. The number of re-checked
+*	directories is returned.
+ */
+func (d *downloader) Recheck(readRoot, writeRoot string, filterList []string) int {
+	current := d.instances.Current()
+	rechecked := 0
+
+	d.mu.Lock()
+	match := d.match
+	d.mu.Unlock()
+
+	for _, served := range d.instances.GetServed() {
+		if served.Instance == current || !strings.HasPrefix(served.ReadPath, readRoot) {
+			continue
+		}
+		if filter.CheckToFilter(strings.TrimSuffix(served.ReadPath, "/"), filterList) {
+			continue
+		}
+
+		if d.verbose {
+			fmt.Printf("Re-checking directory: %s (listed by instance %s)\n", served.ReadPath, served.Instance)
+		}
+
+		writePath := writeRoot + filepath.FromSlash(strings.TrimPrefix(served.ReadPath, readRoot))
+		entries, _ := d.parser.ListDir(served.ReadPath)
+		var jobs []scheduler.File
+		for _, val := range entries {
+			fileRPath := served.ReadPath + val.Name
+
+			if val.Kind == dir_parser.Dir || filter.CheckToFilter(fileRPath, filterList) || !match.Match(val.Name, false, val.Size) {
+				continue
+			}
+
+			jobs = append(jobs, scheduler.File{ReadPath: fileRPath, WritePath: writePath + val.Name, Size: val.Size})
+		}
+		d.wg.Add(len(jobs))
+		d.scheduler.Submit(jobs)
+		rechecked++
+	}
+	d.wg.Wait()
+
+	return rechecked
+}
+
 func (d *downloader) WriteFile(readPath, writePath string, output []byte, err error) error {
 	file := strings.SplitAfterN(string(output), "\n", 3)
 
@@ -125,51 +361,105 @@ func (d *downloader) WriteFile(readPath, writePath string, output []byte, err er
 	downloadErr := d.CheckDownload(readPath, file, err)
 
 	if downloadErr == nil {
-		fileAsString := file[2]
+		fileAsString := fileContent(file)
 
-		// there is currently an issue open to change the behavior for empty files
-		// https://github.com/cloudfoundry/cli/issues/869
-		if strings.Contains(fileAsString, "No files found") {
-			fileAsString = ""
+		size := int64(len(fileAsString))
+		if !d.reserve(size) {
+			return errors.New("download stopped")
 		}
 
 		if d.verbose {
 			fmt.Printf("Writing file: %s\n", readPath)
 		}
+		replaced := d.replacing(writePath)
 
-		// write downloaded file to writePath
-		err = ioutil.WriteFile(writePath, []byte(fileAsString), 0644)
-
-		for i := 1; i <= 32 && err != nil; i *= 2 {
-			time.Sleep(time.Duration(i) * time.Second)
+		if d.snapshot != nil {
+			// snapshots keep the file in their store instead of at writePath
+			err = d.snapshot.Add(readPath, []byte(fileAsString))
+		} else {
+			// write downloaded file to writePath
 			err = ioutil.WriteFile(writePath, []byte(fileAsString), 0644)
+
+			for i := 1; i <= 32 && err != nil; i *= 2 {
+				time.Sleep(time.Duration(i) * time.Second)
+				err = ioutil.WriteFile(writePath, []byte(fileAsString), 0644)
+			}
 		}
 
 		if err == nil {
 			// increment download counter for commandline display
 			// see consoleWriter() in main.go
+			d.mu.Lock()
 			d.filesDownloaded++
+			d.bytesWritten += size
+			d.mu.Unlock()
+			d.countSynced(replaced)
+
+			if d.guard != nil {
+				d.guard.Fetched(readPath)
+			}
 		} else {
+			d.release(size)
 			errMsg := createMessage(" Write Error: '"+readPath+"' encountered error while writing to local file", "yellow", d.onWindows)
-			d.failedDownloads = append(d.failedDownloads, errMsg)
+			d.addFailedDownload(errMsg)
 			if d.verbose {
 				fmt.Println(errMsg)
 				fmt.Println(err)
 			}
 		}
 
+	} else if err == nil {
+		err = downloadErr
 	}
 
 	return err
 }
 
+/*
+*	Fetch() returns the content of the file at 'readPath' without writing it anywhere. Like a
+*	download it switches to another instance if the current one has gone away.
+ */
+func (d *downloader) Fetch(readPath string) ([]byte, error) {
+	instance := d.instances.Current()
+	output, err := d.cmdExec.GetFile(d.appName, readPath, instance)
+
+	// retry on another instance if the current one has gone away
+	if !instance_manager.ResponseOK(output) {
+		if next, switched := d.instances.Failover(instance); switched {
+			output, err = d.cmdExec.GetFile(d.appName, readPath, next)
+		}
+	}
+
+	file := strings.SplitAfterN(string(output), "\n", 3)
+	if err := d.CheckDownload(readPath, file, err); err != nil {
+		return nil, err
+	}
+
+	return []byte(fileContent(file)), nil
+}
+
+// the content of a file from the output of cf files split into the two header lines and the rest
+func fileContent(file []string) string {
+	if len(file) < 3 {
+		return ""
+	}
+
+	// there is currently an issue open to change the behavior for empty files
+	// https://github.com/cloudfoundry/cli/issues/869
+	if strings.Contains(file[2], "No files found") {
+		return ""
+	}
+
+	return file[2]
+}
+
 func (d *downloader) CheckDownload(readPath string, file []string, err error) error {
 	if len(file) >= 2 && strings.Contains(file[1], "OK") {
 		return nil
 	} else {
 		errMsg := createMessage(" Server Error: '"+readPath+"' not downloaded", "yellow", d.onWindows)
 
-		d.failedDownloads = append(d.failedDownloads, errMsg)
+		d.addFailedDownload(errMsg)
 
 		if d.verbose {
 			fmt.Println(errMsg)
@@ -183,11 +473,26 @@ func (d *downloader) CheckDownload(readPath string, file []string, err error) er
 }
 
 func (d *downloader) GetFilesDownloadedCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	return d.filesDownloaded
 }
 
+// includes the directories the downloader's parser failed to list
 func (d *downloader) GetFailedDownloads() []string {
-	return d.failedDownloads
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append(d.parser.GetFailedDownloads(), d.failedDownloads...)
+}
+
+func (d *downloader) addFailedDownload(errMsg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failedDownloads = append(d.failedDownloads, errMsg)
+	d.checkFailures()
 }
 
 // error check function
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
 	. "github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/metadata"
+	"github.com/ibmjstart/cf-download/snapshot"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 )
 
 var _ = Describe("Downloader tests", func() {
@@ -25,6 +29,11 @@ var _ = Describe("Downloader tests", func() {
 	cmdExec = cmd_exec_fake.NewCmdExec()
 	d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
 
+	// stop the workers of the downloader the last test used
+	AfterEach(func() {
+		d.Close()
+	})
+
 	// downloadfile also tests the following functions
 	// WriteFile(), CheckDownload()
 	Describe("Test DownloadFile function", func() {
@@ -197,9 +206,7 @@ var _ = Describe("Downloader tests", func() {
 				rootInfo, _ := os.Stat(writePath + "/testFiles/")
 				Ω(rootInfo.IsDir()).To(BeTrue())
 
-				rootFile, _ := os.Open(writePath + "/testFiles/")
-				rootContents, _ := rootFile.Readdir(0)
-				rootFile.Close()
+				rootContents, _ := ioutil.ReadDir(writePath + "/testFiles/")
 				Ω(rootContents[0].Name()).To(Equal("app_content"))
 				Ω(rootContents[1].Name()).To(Equal("ignore.go"))
 				Ω(rootContents[2].Name()).To(Equal("ignoreDir"))
@@ -207,9 +214,7 @@ var _ = Describe("Downloader tests", func() {
 
 				// test the contents of the app_contents directory
 				Ω(rootContents[0].IsDir()).To(BeTrue())
-				appContentFolder, _ := os.Open(writePath + "/testFiles/" + rootContents[0].Name())
-				appContents, _ := appContentFolder.Readdir(0)
-				appContentFolder.Close()
+				appContents, _ := ioutil.ReadDir(writePath + "/testFiles/" + rootContents[0].Name())
 				Ω(appContents[0].Name()).To(Equal("app.go"))
 				Ω(appContents[1].Name()).To(Equal("server.go"))
 
@@ -243,17 +248,13 @@ var _ = Describe("Downloader tests", func() {
 				rootInfo, _ := os.Stat(writePath + "/testFiles/")
 				Ω(rootInfo.IsDir()).To(BeTrue())
 
-				rootFile, _ := os.Open(writePath + "/testFiles/")
-				rootContents, _ := rootFile.Readdir(0)
-				rootFile.Close()
+				rootContents, _ := ioutil.ReadDir(writePath + "/testFiles/")
 				Ω(rootContents[0].Name()).To(Equal("app_content"))
 				Ω(rootContents[1].Name()).To(Equal("notignored.go"))
 
 				// test the contents of the app_contents directory
 				Ω(rootContents[0].IsDir()).To(BeTrue())
-				appContentFolder, _ := os.Open(writePath + "/testFiles/" + rootContents[0].Name())
-				appContents, _ := appContentFolder.Readdir(0)
-				appContentFolder.Close()
+				appContents, _ := ioutil.ReadDir(writePath + "/testFiles/" + rootContents[0].Name())
 				Ω(appContents[0].Name()).To(Equal("app.go"))
 				Ω(appContents[1].Name()).To(Equal("server.go"))
 
@@ -263,4 +264,339 @@ var _ = Describe("Downloader tests", func() {
 			})
 		})
 	})
+
+	Describe("Test SetLimits() Function", func() {
+		okOutput := "Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\nHello World"
+		failOutput := "Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error"
+
+		download := func(name string) {
+			wg.Add(1)
+			go d.DownloadFile("/"+name, currentDirectory+"/testFiles/"+name)
+			wg.Wait()
+		}
+
+		AfterEach(func() {
+			for _, val := range []string{"limit1.txt", "limit2.txt", "limit3.txt"} {
+				os.RemoveAll(currentDirectory + "/testFiles/" + val)
+			}
+		})
+
+		It("should stop once --max-files is reached", func() {
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetLimits(Limits{MaxFiles: 2})
+			cmdExec.SetOutput(okOutput)
+
+			download("limit1.txt")
+			download("limit2.txt")
+			Ω(d.GetAbortReason()).To(Equal(""))
+			download("limit3.txt")
+
+			Ω(d.GetFilesDownloadedCount()).To(Equal(2))
+			Ω(d.GetBytesDownloaded()).To(BeEquivalentTo(22))
+			Ω(d.GetSkippedCount()).To(Equal(1))
+			Ω(d.GetAbortReason()).To(ContainSubstring("--max-files limit of 2"))
+			_, err := os.Stat(currentDirectory + "/testFiles/limit3.txt")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should not write a file that would go past --max-bytes", func() {
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetLimits(Limits{MaxBytes: 15})
+			cmdExec.SetOutput(okOutput)
+
+			download("limit1.txt")
+			download("limit2.txt")
+
+			Ω(d.GetFilesDownloadedCount()).To(Equal(1))
+			Ω(d.GetBytesDownloaded()).To(BeEquivalentTo(11))
+			Ω(d.GetAbortReason()).To(ContainSubstring("--max-bytes limit of 15"))
+			_, err := os.Stat(currentDirectory + "/testFiles/limit2.txt")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should skip the remaining files once --max-failures is exceeded", func() {
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetLimits(Limits{MaxFailures: 1})
+			cmdExec.SetOutput(failOutput)
+
+			download("limit1.txt")
+			Ω(d.GetAbortReason()).To(Equal(""))
+			download("limit2.txt")
+			Ω(d.GetAbortReason()).To(ContainSubstring("--max-failures limit of 1"))
+
+			cmdExec.SetOutput(okOutput)
+			download("limit3.txt")
+
+			Ω(d.GetFailedDownloads()).To(HaveLen(2))
+			Ω(d.GetFilesDownloadedCount()).To(Equal(0))
+			Ω(d.GetSkippedCount()).To(Equal(1))
+		})
+	})
+
+	Describe("Test SetSync() Function", func() {
+		It("should only download files that are new or differ in size", func() {
+			readPath := currentDirectory + "/testFiles/"
+			writePath := currentDirectory + "/test-download/"
+			filterList := []string{readPath + ".DS_Store", readPath + "app_content/.DS_Store"}
+			defer os.RemoveAll(writePath)
+
+			cmdExec.SetFakeDir(true)
+			defer cmdExec.SetFakeDir(false)
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			// the fake lists every file as 1B, so a 1 byte local copy looks unchanged
+			ioutil.WriteFile(writePath+"app_content/app.go", []byte("x"), 0644)
+			os.Remove(writePath + "notignored.go")
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSync(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			added, updated, unchanged := d.GetSyncCounts()
+			Ω(added).To(Equal(1))
+			Ω(updated).To(Equal(3))
+			Ω(unchanged).To(Equal(1))
+			Ω(d.GetFilesDownloadedCount()).To(Equal(4))
+
+			contents, _ := ioutil.ReadFile(writePath + "app_content/app.go")
+			Ω(string(contents)).To(Equal("x"))
+			_, err := os.Stat(writePath + "notignored.go")
+			Ω(err).To(BeNil())
+		})
+	})
+
+	Describe("Test Fetch() Function", func() {
+		It("should return the file without the cf files header", func() {
+			cmdExec.SetOutput("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\n{\"key\": \"value\"}\n")
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+
+			content, err := d.Fetch("/app/config.json")
+			Ω(err).To(BeNil())
+			Ω(string(content)).To(Equal("{\"key\": \"value\"}\n"))
+			Ω(d.GetFilesDownloadedCount()).To(Equal(0))
+		})
+
+		It("should return an empty file and fail for a missing one", func() {
+			cmdExec.SetOutput("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\nNo files found")
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			content, err := d.Fetch("/app/empty.txt")
+			Ω(err).To(BeNil())
+			Ω(content).To(BeEmpty())
+
+			cmdExec.SetOutput("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error, status code: 404")
+			_, err = d.Fetch("/app/missing.txt")
+			Ω(err).To(HaveOccurred())
+			Ω(d.GetFailedDownloads()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Test SetMatch() Function", func() {
+		It("should only download the selected files and create their directories", func() {
+			readPath := currentDirectory + "/testFiles/"
+			writePath := currentDirectory + "/test-download/"
+			defer os.RemoveAll(writePath)
+
+			cmdExec.SetFakeDir(true)
+			defer cmdExec.SetFakeDir(false)
+
+			match, err := filter.ParsePredicate("s*.go", "", "")
+			Ω(err).To(BeNil())
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetMatch(match)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, nil)
+			wg.Wait()
+
+			Ω(d.GetFilesDownloadedCount()).To(Equal(1))
+			_, err = os.Stat(writePath + "app_content/server.go")
+			Ω(err).To(BeNil())
+			_, err = os.Stat(writePath + "ignoreDir")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Test SetMirror() Function", func() {
+		var readPath, writePath string
+		var filterList []string
+
+		BeforeEach(func() {
+			readPath = currentDirectory + "/testFiles/"
+			writePath = currentDirectory + "/test-download/"
+			filterList = []string{readPath + ".DS_Store", readPath + "app_content/.DS_Store", readPath + "ignoreDir", readPath + "local.txt"}
+
+			cmdExec.SetFakeDir(true)
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			// gone from the app, and a local file on the omit list
+			os.MkdirAll(writePath+"app_content/old/", 0755)
+			ioutil.WriteFile(writePath+"app_content/old/stale.txt", []byte("stale"), 0644)
+			ioutil.WriteFile(writePath+"stale.txt", []byte("stale"), 0644)
+			ioutil.WriteFile(writePath+"local.txt", []byte("keep"), 0644)
+		})
+
+		AfterEach(func() {
+			cmdExec.SetFakeDir(false)
+			os.RemoveAll(writePath)
+		})
+
+		It("should remove local entries the app no longer has", func() {
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSync(true)
+			d.SetMirror(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			Ω(d.GetRemoved()).To(ConsistOf(writePath+"stale.txt", writePath+"app_content/old"))
+			_, err := os.Stat(writePath + "stale.txt")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(writePath + "app_content/old")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(writePath + "local.txt")
+			Ω(err).To(BeNil())
+			_, err = os.Stat(writePath + "app_content/app.go")
+			Ω(err).To(BeNil())
+		})
+
+		It("should keep the download's metadata and git repository", func() {
+			ioutil.WriteFile(writePath+metadata.FileName, []byte("{}"), 0644)
+			os.MkdirAll(writePath+".git/", 0755)
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSync(true)
+			d.SetMirror(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			Ω(d.GetRemoved()).NotTo(ContainElement(writePath + metadata.FileName))
+			_, err := os.Stat(writePath + metadata.FileName)
+			Ω(err).To(BeNil())
+			_, err = os.Stat(writePath + ".git")
+			Ω(err).To(BeNil())
+		})
+
+		It("should only report what it would remove on a dry run", func() {
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSync(true)
+			d.SetMirror(true)
+			d.SetDryRun(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			Ω(d.GetRemoved()).To(ConsistOf(writePath+"stale.txt", writePath+"app_content/old"))
+			Ω(d.GetFilesDownloadedCount()).To(Equal(0))
+			_, err := os.Stat(writePath + "stale.txt")
+			Ω(err).To(BeNil())
+			_, err = os.Stat(writePath + "app_content/old/stale.txt")
+			Ω(err).To(BeNil())
+		})
+
+		It("should not remove anything from a directory that failed to list", func() {
+			cmdExec.SetFakeDir(false)
+			cmdExec.SetOutput("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nFAILED\nServer error")
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSync(true)
+			d.SetMirror(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			Ω(d.GetRemoved()).To(BeEmpty())
+			Ω(d.GetFailedDownloads()).To(HaveLen(1))
+			_, err := os.Stat(writePath + "stale.txt")
+			Ω(err).To(BeNil())
+		})
+	})
+
+	Describe("Test GetPlan() Function", func() {
+		It("should plan a dry run per path without writing anything", func() {
+			readPath := currentDirectory + "/testFiles/"
+			writePath := currentDirectory + "/test-download/"
+			filterList := []string{readPath + ".DS_Store", readPath + "app_content/.DS_Store", readPath + "ignoreDir"}
+
+			cmdExec.SetFakeDir(true)
+			defer cmdExec.SetFakeDir(false)
+
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetDryRun(true)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			plans := d.GetPlan()
+			Ω(plans).To(HaveLen(1))
+			Ω(plans[0].ReadPath).To(Equal(readPath))
+			Ω(plans[0].Files).To(Equal(4))
+			Ω(plans[0].Dirs).To(Equal(1))
+			Ω(plans[0].Bytes).To(BeEquivalentTo(4))
+			Ω(plans[0].Listings).To(Equal(2))
+			Ω(plans[0].Omitted).To(ContainElement(readPath + "ignoreDir/"))
+
+			_, err := os.Stat(writePath)
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Test SetSnapshot() Function", func() {
+		It("should record files in the snapshot store instead of the working directory", func() {
+			readPath := currentDirectory + "/testFiles/"
+			writePath := currentDirectory + "/test-download/"
+			storePath := currentDirectory + "/test-snapshots/"
+			filterList := []string{readPath + ".DS_Store", readPath + "app_content/.DS_Store", readPath + "ignoreDir"}
+			defer os.RemoveAll(storePath)
+
+			cmdExec.SetFakeDir(true)
+			defer cmdExec.SetFakeDir(false)
+
+			store := snapshot.NewStore(storePath)
+			recorder := snapshot.NewRecorder(store, "appName", "0")
+			d = NewDownloader(cmdExec, &wg, "appName", "0", false, false)
+			d.SetSnapshot(recorder)
+			wg.Add(1)
+			d.DownloadDir(readPath, writePath, filterList)
+			wg.Wait()
+
+			manifest, err := recorder.Finish([]string{readPath}, true)
+			Ω(err).To(BeNil())
+			Ω(manifest.Files).To(HaveLen(4))
+			Ω(d.GetFilesDownloadedCount()).To(Equal(4))
+
+			_, err = os.Stat(writePath)
+			Ω(os.IsNotExist(err)).To(BeTrue())
+
+			manifests, _ := store.List()
+			Ω(manifests).To(HaveLen(1))
+		})
+	})
+
+	Describe("Test EstimateTime() Function", func() {
+		plans := []Plan{{Files: 40, Bytes: 10 << 20, Listings: 4, Latency: 4 * time.Second}}
+
+		It("should estimate a round of requests per batch of workers", func() {
+			// 2 rounds of 20 files and 1 round of listings at 1s each
+			Ω(EstimateTime(plans, 20, 0, 0)).To(Equal(3 * time.Second))
+		})
+
+		It("should not estimate less than the rate limits allow", func() {
+			Ω(EstimateTime(plans, 20, 1<<20, 0)).To(Equal(10 * time.Second))
+			Ω(EstimateTime(plans, 20, 0, 2)).To(Equal(22 * time.Second))
+		})
+
+		It("should not estimate without a measured listing", func() {
+			Ω(EstimateTime([]Plan{{Files: 1}}, 20, 0, 0)).To(BeZero())
+		})
+	})
 })
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"strconv"
+)
+
+// thresholds that stop a download once they are exceeded, 0 means no limit
+type Limits struct {
+	MaxBytes    int64
+	MaxFiles    int
+	MaxFailures int
+}
+
+/*
+*	SetLimits() sets the thresholds enforced while downloading. They hold for everything this
+*	downloader fetches, across all the paths it is given.
+ */
+func (d *downloader) SetLimits(limits Limits) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.limits = limits
+}
+
+/*
+*	GetAbortReason() returns why the download was stopped, or "" if no threshold was exceeded.
+ */
+func (d *downloader) GetAbortReason() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.abortReason
+}
+
+func (d *downloader) GetBytesDownloaded() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.bytesWritten
+}
+
+/*
+*	GetSkippedCount() returns the number of files that were queued but not downloaded because the
+*	download was stopped.
+ */
+func (d *downloader) GetSkippedCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.skipped
+}
+
+// reports whether the download was stopped, counting the caller's file as skipped if so
+func (d *downloader) skipIfAborted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.abortReason != "" {
+		d.skipped++
+		return true
+	}
+	return false
+}
+
+/*
+*	reserve() claims a file of 'size' bytes against the file and byte thresholds before it is
+*	written. Claims are made under the lock, so workers writing at the same time can not overshoot
+*	a threshold together. It returns false, and stops the download, if the file does not fit.
+ */
+func (d *downloader) reserve(size int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.abortReason != "" {
+		d.skipped++
+		return false
+	}
+
+	if d.limits.MaxFiles > 0 && d.filesReserved >= d.limits.MaxFiles {
+		d.abortReason = "the --max-files limit of " + strconv.Itoa(d.limits.MaxFiles) + " files was reached"
+		d.skipped++
+		return false
+	}
+
+	if d.limits.MaxBytes > 0 && d.bytesReserved+size > d.limits.MaxBytes {
+		d.abortReason = "the --max-bytes limit of " + strconv.FormatInt(d.limits.MaxBytes, 10) + " bytes was reached"
+		d.skipped++
+		return false
+	}
+
+	d.filesReserved++
+	d.bytesReserved += size
+	return true
+}
+
+// gives back a claim whose file could not be written
+func (d *downloader) release(size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.filesReserved--
+	d.bytesReserved -= size
+}
+
+// must be called with the lock held, listing failures of the parser count as well
+func (d *downloader) checkFailures() {
+	if d.limits.MaxFailures <= 0 || d.abortReason != "" {
+		return
+	}
+
+	if len(d.failedDownloads)+d.parser.GetFailedCount() > d.limits.MaxFailures {
+		d.abortReason = "the --max-failures limit of " + strconv.Itoa(d.limits.MaxFailures) + " failures was exceeded"
+	}
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/metadata"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+/*
+*	SetMirror() makes the downloader remove local files and directories that are no longer listed
+*	on the instance. Only directories that were listed successfully are cleaned up, and entries on
+*	the filter list are never removed, nor is a .git directory at the top of the destination.
+ */
+func (d *downloader) SetMirror(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mirror = enabled
+}
+
+/*
+*	SetDryRun() makes the downloader walk the app and work out what it would fetch and remove
+*	without writing, fetching or removing anything.
+ */
+func (d *downloader) SetDryRun(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dryRun = enabled
+}
+
+func (d *downloader) IsDryRun() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dryRun
+}
+
+/*
+*	GetRemoved() returns the local paths removed by mirroring, or that would be removed on a dry run.
+ */
+func (d *downloader) GetRemoved() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.removed...)
+}
+
+/*
+*	prune() removes the entries of the local directory 'writePath' that 'listing' does not contain.
+*	'writeRoot' is the destination of the download, nothing outside of it is removed.
+ */
+func (d *downloader) prune(listing walker.Listing, writePath, writeRoot string, filterList []string) {
+	d.mu.Lock()
+	mirror, dryRun := d.mirror, d.dryRun
+	d.mu.Unlock()
+
+	// entries of a directory that could not be listed are unknown, not gone
+	if !mirror || listing.Failed {
+		return
+	}
+
+	// an entry is kept if the instance has it with the same type, omitted entries are always kept
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, val := range listing.Files {
+		files[val.Name] = true
+	}
+	for _, val := range listing.Dirs {
+		dirs[strings.TrimSuffix(val, "/")] = true
+	}
+	for _, val := range listing.Omitted {
+		files[strings.TrimSuffix(val, "/")] = true
+		dirs[strings.TrimSuffix(val, "/")] = true
+	}
+
+	local, err := ioutil.ReadDir(writePath)
+	if err != nil {
+		return
+	}
+
+	for _, val := range local {
+		if (val.IsDir() && dirs[val.Name()]) || (!val.IsDir() && files[val.Name()]) || filter.CheckToFilter(listing.ReadPath+val.Name(), filterList) {
+			continue
+		}
+
+		// the download's own metadata and a --git repository are not part of the app
+		if (val.Name() == metadata.FileName || val.Name() == ".git") && filepath.Clean(writePath) == filepath.Clean(writeRoot) {
+			continue
+		}
+
+		path := filepath.Join(writePath, val.Name())
+		if !inside(writeRoot, path) {
+			d.addFailedDownload(createMessage(" Mirror Error: refusing to remove '"+path+"' outside of "+writeRoot, "yellow", d.onWindows))
+			continue
+		}
+
+		if !dryRun {
+			if d.verbose {
+				fmt.Printf("Removing: %s\n", path)
+			}
+
+			// removes a symbolic link itself, never what it points to
+			if err := os.RemoveAll(path); err != nil {
+				d.addFailedDownload(createMessage(" Mirror Error: '"+path+"' could not be removed", "yellow", d.onWindows))
+				continue
+			}
+		}
+
+		d.mu.Lock()
+		d.removed = append(d.removed, path)
+		d.mu.Unlock()
+	}
+}
+
+// reports whether 'path' lies below 'root', the root itself does not count
+func inside(root, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	return !filepath.IsAbs(rel)
+}
@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"math"
+	"time"
+
+	"github.com/ibmjstart/cf-download/scheduler"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+/*
+*	Plan is what a dry run found below one of the paths given to the downloader. Files and Bytes
+*	count the files that would be fetched, Unknown those of them whose size was not listed.
+*	Listings and Latency add up the directory listings made and the time they took.
+ */
+type Plan struct {
+	ReadPath string
+	Files    int
+	Dirs     int
+	Bytes    int64
+	Unknown  int
+	Omitted  []string
+	Listings int
+	Latency  time.Duration
+}
+
+/*
+*	GetPlan() returns the plan of every path walked on a dry run, in the order they were given.
+ */
+func (d *downloader) GetPlan() []Plan {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	plans := make([]Plan, 0, len(d.plans))
+	for _, val := range d.plans {
+		plan := *val
+		plan.Omitted = append([]string(nil), val.Omitted...)
+		plans = append(plans, plan)
+	}
+
+	return plans
+}
+
+// adds one listing and the files that would be fetched from it to the plan of 'readRoot'
+func (d *downloader) addPlan(readRoot string, listing walker.Listing, jobs []scheduler.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	plan := d.plan(readRoot)
+	plan.Files += len(jobs)
+	plan.Dirs += len(listing.Dirs)
+	plan.Listings++
+	plan.Latency += listing.Elapsed
+	for _, val := range jobs {
+		if val.Size < 0 {
+			plan.Unknown++
+			continue
+		}
+		plan.Bytes += val.Size
+	}
+	for _, val := range listing.Omitted {
+		plan.Omitted = append(plan.Omitted, listing.ReadPath+val)
+	}
+}
+
+// adds a single file given by path, its size is not known without listing its directory
+func (d *downloader) addPlanFile(readPath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	plan := d.plan(readPath)
+	plan.Files++
+	plan.Unknown++
+}
+
+// must be called with the lock held
+func (d *downloader) plan(readRoot string) *Plan {
+	for _, val := range d.plans {
+		if val.ReadPath == readRoot {
+			return val
+		}
+	}
+
+	plan := &Plan{ReadPath: readRoot}
+	d.plans = append(d.plans, plan)
+	return plan
+}
+
+/*
+*	EstimateTime() estimates how long downloading the planned files takes. Each file is one cf
+*	request, so the estimate is the measured latency of a listing for every round of 'workers'
+*	files, plus listing the directories again. It is raised to what the rate limits allow, a
+*	limit of 0 is no limit. Without any measured listing there is nothing to go on and 0 is returned.
+ */
+func EstimateTime(plans []Plan, workers int, limitRate int64, maxRps float64) time.Duration {
+	var files, listings int
+	var bytes int64
+	var latency time.Duration
+	for _, val := range plans {
+		files += val.Files
+		listings += val.Listings
+		bytes += val.Bytes
+		latency += val.Latency
+	}
+
+	if listings == 0 {
+		return 0
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	average := latency / time.Duration(listings)
+	rounds := (files + workers - 1) / workers
+	listRounds := (listings + walker.DefaultWorkers - 1) / walker.DefaultWorkers
+	estimate := time.Duration(rounds+listRounds) * average
+
+	if maxRps > 0 {
+		requests := float64(files + listings)
+		estimate = time.Duration(math.Max(float64(estimate), requests/maxRps*float64(time.Second)))
+	}
+	if limitRate > 0 {
+		estimate = time.Duration(math.Max(float64(estimate), float64(bytes)/float64(limitRate)*float64(time.Second)))
+	}
+
+	return estimate
+}
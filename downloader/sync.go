@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"os"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/scheduler"
+)
+
+/*
+*	SetSync() makes the downloader skip files whose local copy has the size the listing gives,
+*	so that a download can be re-run into the same directory and only fetch what changed.
+ */
+func (d *downloader) SetSync(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sync = enabled
+}
+
+/*
+*	GetSyncCounts() returns the number of files that were new, that replaced an outdated local
+*	copy, and that were skipped as unchanged. On a dry run the files that would be fetched are
+*	counted.
+ */
+func (d *downloader) GetSyncCounts() (int, int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.syncNew, d.syncUpdated, d.syncUnchanged
+}
+
+/*
+*	unchanged() drops the files whose local copy matches the listing. cf files lists sizes rounded
+*	to one decimal, so the local size is rounded the same way before comparing; an edit that
+*	keeps the rounded size is not noticed. Files of unknown size are always fetched.
+ */
+func (d *downloader) unchanged(jobs []scheduler.File) []scheduler.File {
+	d.mu.Lock()
+	enabled := d.sync
+	d.mu.Unlock()
+
+	if !enabled {
+		return jobs
+	}
+
+	changed := jobs[:0]
+	skipped := 0
+	for _, val := range jobs {
+		if val.Size >= 0 {
+			info, err := os.Stat(val.WritePath)
+			if err == nil && info.Mode().IsRegular() && dir_parser.FormatSize(info.Size()) == dir_parser.FormatSize(val.Size) {
+				skipped++
+				continue
+			}
+		}
+		changed = append(changed, val)
+	}
+
+	d.mu.Lock()
+	d.syncUnchanged += skipped
+	d.mu.Unlock()
+
+	return changed
+}
+
+// reports whether a file is about to replace a local copy, so it can be counted once it is written
+func (d *downloader) replacing(writePath string) bool {
+	_, err := os.Stat(writePath)
+	return err == nil
+}
+
+// counts a written file as new or updated
+func (d *downloader) countSynced(replaced bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.sync && !d.dryRun {
+		return
+	}
+
+	if replaced {
+		d.syncUpdated++
+	} else {
+		d.syncNew++
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/metadata"
+	"github.com/ibmjstart/cf-download/tree_diff"
+)
+
+// contains the flag values of cf download-drift
+type driftFlagVal struct {
+	commonFlagVal
+	Droplet_flag  string
+	Download_flag string
+}
+
+/*
+*	RunDrift() is the entry point of cf download-drift. It compares the files below PATH, or the
+*	whole app, on a running instance with the droplet the instance was started from, i.e. what
+*	staging produced, and exits with 1 if the app changed at runtime.
+ */
+func RunDrift(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 1, "App Name")
+
+	flagVals, readPath := ParseDriftArgs(args)
+	appName = args[1]
+
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterList(flagVals.Omit_flag, flagVals.Verbose_flag)
+
+	tarball := flagVals.Droplet_flag
+	if tarball == "" {
+		fmt.Println("Downloading the current droplet of", appName, "...")
+		tarball = FetchDroplet(cmdExec, appName)
+		defer os.Remove(tarball)
+	}
+
+	droplet, err := tree_diff.NewDropletSource(tarball, readPath)
+	if err != nil {
+		fmt.Println(createMessage("\nError: "+err.Error(), "red+b", onWindows))
+		if flagVals.Droplet_flag == "" {
+			fmt.Println("Download the droplet yourself and pass it with --droplet.")
+		}
+		os.Remove(tarball)
+		os.Exit(1)
+	}
+
+	live := tree_diff.NewRemoteSource(cmdExec, appName, flagVals.Instance_flag, readPath, onWindows, flagVals.Verbose_flag)
+	differ := tree_diff.NewDiffer(droplet, live, readPath, filterList, tree_diff.DefaultWorkers)
+
+	fmt.Printf("Comparing %s (instance %s) %s with its droplet\n\n", appName, flagVals.Instance_flag, readPath)
+	changes := differ.Diff()
+
+	counts := map[tree_diff.Kind]int{}
+	for _, val := range changes {
+		counts[val.Kind]++
+		printChange(val, onWindows)
+	}
+
+	failed := differ.GetFailed()
+	if len(failed) > 0 {
+		fmt.Println("\nThe following directories could not be listed and were not compared:")
+		PrintSlice(failed)
+	}
+
+	fmt.Printf("\n%d files drifted: %d created, %d deleted, %d changed in size at runtime.\n", len(changes), counts[tree_diff.Added], counts[tree_diff.Removed], counts[tree_diff.Modified])
+
+	if flagVals.Download_flag != "" {
+		downloadDrift(cmdExec, changes, readPath, flagVals, onWindows)
+	}
+
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-drift and returns the app path to compare,
+*	given as the optional argument after APP_NAME.
+ */
+func ParseDriftArgs(args []string) (driftFlagVal, string) {
+	f1 := newCommandFlags("download-drift", true)
+	dropletp := f1.String("droplet", "", "--droplet droplet.tgz")
+	downloadp := f1.String("download", "", "--download dir")
+
+	readPath, err := f1.ParsePath(args, 2)
+	f1.ExitOnError(err)
+
+	flagVals := driftFlagVal{
+		commonFlagVal: f1.Common(),
+		Droplet_flag:  *dropletp,
+		Download_flag: *downloadp,
+	}
+
+	return flagVals, readPath
+}
+
+/*
+*	This function downloads the app's current droplet to a temporary file and returns its path.
+ */
+func FetchDroplet(cmdExec cmd_exec.CmdExec, appName string) string {
+	_, dropletGuid, err := metadata.LookupApp(cmdExec, appName)
+	check(err, "")
+	if dropletGuid == "" {
+		fmt.Println("\nError: cannot find the current droplet of", appName+". Download it yourself and pass it with --droplet.")
+		os.Exit(1)
+	}
+
+	file, err := ioutil.TempFile("", "droplet-*.tgz")
+	check(err, "Cannot create a temporary file for the droplet")
+	file.Close()
+
+	output, err := cmdExec.CurlToFile("/v3/droplets/"+dropletGuid+"/download", file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		fmt.Printf("\nError: cannot download droplet %s: %s\n", dropletGuid, strings.TrimSpace(string(output)))
+		fmt.Println("Download it yourself and pass it with --droplet.")
+		os.Exit(1)
+	}
+
+	return file.Name()
+}
+
+/*
+*	This function fetches the files that were created or changed at runtime from the instance,
+*	to the same relative path below --download.
+ */
+func downloadDrift(cmdExec cmd_exec.CmdExec, changes []tree_diff.Change, readPath string, flagVals driftFlagVal, onWindows bool) {
+	var wg sync.WaitGroup
+	dloader := downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, flagVals.Verbose_flag, onWindows)
+	defer dloader.Close()
+
+	var paths []string
+	for _, val := range changes {
+		if val.Kind != tree_diff.Removed {
+			paths = append(paths, val.Path)
+		}
+	}
+	fetchFiles(dloader, &wg, readPath, flagVals.Download_flag, paths, onWindows)
+
+	fmt.Printf("\nDownloaded %d drifted files to %s.\n", dloader.GetFilesDownloadedCount(), flagVals.Download_flag)
+	if failed := dloader.GetFailedDownloads(); len(failed) > 0 {
+		fmt.Println("The following files could not be downloaded:")
+		PrintSlice(failed)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/disk_usage"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+// contains the flag values of cf download-du
+type duFlagVal struct {
+	commonFlagVal
+	Top_flag       int
+	Threshold_flag int64
+}
+
+// the default --top and --threshold
+const (
+	defaultTop       = 10
+	defaultThreshold = 10 * 1024 * 1024
+)
+
+/*
+*	RunDu() is the entry point of cf download-du. It totals the listed sizes and file counts below
+*	PATH, or the whole app, prints the heaviest directories and recommends what to omit.
+ */
+func RunDu(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 1, "App Name")
+
+	flagVals, readPath := ParseDuArgs(args)
+	appName = args[1]
+
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterList(flagVals.Omit_flag, flagVals.Verbose_flag)
+	parser := dir_parser.NewParser(cmdExec, appName, flagVals.Instance_flag, onWindows, flagVals.Verbose_flag)
+
+	fmt.Printf("Listing %s (instance %s) %s ...\n\n", appName, flagVals.Instance_flag, readPath)
+	report := disk_usage.NewCounter(parser, walker.DefaultWorkers).Count(readPath, filterList)
+
+	fmt.Printf("%10s %10s  %s\n", "SIZE", "FILES", "DIRECTORY")
+	for _, val := range report.Top(flagVals.Top_flag) {
+		fmt.Printf("%10s %10d  %s\n", dir_parser.FormatSize(val.Size), val.Files, val.Path)
+	}
+
+	total := report.Total
+	fmt.Printf("\nTotal: %s in %d files and %d directories below %s\n", dir_parser.FormatSize(total.Size), total.Files, total.Dirs, readPath)
+
+	if len(report.Failed) > 0 {
+		fmt.Println(createMessage("\nThe following directories could not be listed and are missing from the totals:", "yellow", onWindows))
+		PrintSlice(report.Failed)
+	}
+
+	printRecommendations(report.Recommend(flagVals.Threshold_flag), flagVals.Threshold_flag, total.Size)
+}
+
+/*
+*	This function sets the flag values of cf download-du and returns the app path to total,
+*	given as the optional argument after APP_NAME.
+ */
+func ParseDuArgs(args []string) (duFlagVal, string) {
+	f1 := newCommandFlags("download-du", true)
+	topp := f1.Int("top", defaultTop, "--top count")
+	thresholdp := f1.String("threshold", "", "--threshold size")
+
+	readPath, err := f1.ParsePath(args, 2)
+	threshold := int64(defaultThreshold)
+	if err == nil && *thresholdp != "" {
+		threshold, err = ParseSizeFlag(*thresholdp)
+	}
+	if err == nil && *topp < 1 {
+		err = errors.New("--top must be at least 1")
+	}
+	f1.ExitOnError(err)
+
+	flagVals := duFlagVal{
+		commonFlagVal:  f1.Common(),
+		Top_flag:       *topp,
+		Threshold_flag: threshold,
+	}
+
+	return flagVals, readPath
+}
+
+/*
+*	This function prints the recommended omit entries and an --omit value to copy.
+ */
+func printRecommendations(recommended []disk_usage.Recommendation, threshold, total int64) {
+	if len(recommended) == 0 {
+		fmt.Printf("\nNo dependency or buildpack directories over %s to omit.\n", dir_parser.FormatSize(threshold))
+		return
+	}
+
+	fmt.Printf("\nRecommended --omit entries (dependency and buildpack directories over %s):\n", dir_parser.FormatSize(threshold))
+
+	var omits []string
+	var saved int64
+	for _, val := range recommended {
+		omit := strings.Trim(val.Path, "/")
+		omits = append(omits, omit)
+		saved += val.Size
+		fmt.Printf("  %-40s %10s %8d files  %s\n", omit, dir_parser.FormatSize(val.Size), val.Files, val.Reason)
+	}
+
+	fmt.Printf("\nOmitting them saves %s of %s:\n  --omit \"%s\"\n", dir_parser.FormatSize(saved), dir_parser.FormatSize(total), strings.Join(omits, ";"))
+}
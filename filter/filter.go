@@ -2,11 +2,21 @@ package filter
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 )
 
 func GetFilterList(omitString string, verbose bool) []string {
+	return GetFilterListTo(os.Stdout, omitString, verbose)
+}
+
+/*
+*	GetFilterListTo() works like GetFilterList() but prints the info about .cfignore to 'out',
+*	so commands writing machine readable output can keep it apart.
+ */
+func GetFilterListTo(out io.Writer, omitString string, verbose bool) []string {
 	// POST: FCTVAL== slice of strings (paths and files) to filter
 	filterList := []string{} // filtered list to be returned
 
@@ -14,19 +24,19 @@ func GetFilterList(omitString string, verbose bool) []string {
 	content, err := ioutil.ReadFile(".cfignore")
 
 	if err != nil && verbose {
-		fmt.Println("[ Info: ", err, "]")
+		fmt.Fprintln(out, "[ Info: ", err, "]")
 	} else {
 		lines := strings.Split(string(content), "\n") // get each line in .cfignore
 
 		if verbose && len(lines) > 1 {
-			fmt.Println("[ Info: using .cfignore ] \nContents: ")
+			fmt.Fprintln(out, "[ Info: using .cfignore ] \nContents: ")
 			for _, val := range lines {
-				fmt.Println(val)
+				fmt.Fprintln(out, val)
 			}
-			fmt.Println("")
+			fmt.Fprintln(out, "")
 		} else if len(lines) > 0 {
 			if lines[0] != "" {
-				fmt.Println("[ Info: using .cfignore ]")
+				fmt.Fprintln(out, "[ Info: using .cfignore ]")
 			}
 		}
 
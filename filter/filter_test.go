@@ -0,0 +1,76 @@
+package filter_test
+
+import (
+	. "github.com/ibmjstart/cf-download/filter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	Describe("Test ParsePredicate()", func() {
+		It("Should select everything without predicates", func() {
+			p, err := ParsePredicate("", "", "")
+			Ω(err).To(BeNil())
+			Ω(p.Empty()).To(BeTrue())
+			Ω(p.Match("app.js", false, 10)).To(BeTrue())
+			Ω(p.Match("lib/", true, -1)).To(BeTrue())
+		})
+
+		It("Should reject invalid values", func() {
+			_, err := ParsePredicate("[a-", "", "")
+			Ω(err).To(HaveOccurred())
+			_, err = ParsePredicate("", "+ten", "")
+			Ω(err).To(HaveOccurred())
+			_, err = ParsePredicate("", "", "l")
+			Ω(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Test Predicate.Match()", func() {
+		It("Should match names against a glob", func() {
+			p, _ := ParsePredicate("*.log", "", "")
+			Ω(p.Match("app.log", false, 10)).To(BeTrue())
+			Ω(p.Match("app.log.1", false, 10)).To(BeFalse())
+			Ω(p.Match("old.log/", true, -1)).To(BeTrue())
+		})
+
+		It("Should compare sizes the way find does", func() {
+			larger, _ := ParsePredicate("", "+10M", "")
+			Ω(larger.Match("big", false, 11<<20)).To(BeTrue())
+			Ω(larger.Match("small", false, 10<<20)).To(BeFalse())
+			Ω(larger.Match("dir/", true, -1)).To(BeFalse())
+
+			smaller, _ := ParsePredicate("", "-1kb", "")
+			Ω(smaller.Match("tiny", false, 1023)).To(BeTrue())
+			Ω(smaller.Match("unknown", false, -1)).To(BeFalse())
+
+			// 1.1K is listed for 1126 bytes as well as 1150 bytes
+			equal, _ := ParsePredicate("", "1.1K", "")
+			Ω(equal.Match("a", false, 1126)).To(BeTrue())
+			Ω(equal.Match("b", false, 1150)).To(BeTrue())
+			Ω(equal.Match("c", false, 2048)).To(BeFalse())
+
+			bytes, _ := ParsePredicate("", "136", "")
+			Ω(bytes.Match("staging_info.yml", false, 136)).To(BeTrue())
+		})
+
+		It("Should select files or directories", func() {
+			files, _ := ParsePredicate("node*", "", "f")
+			Ω(files.Match("node_modules/", true, -1)).To(BeFalse())
+			Ω(files.Match("node.tar", false, 1)).To(BeTrue())
+
+			dirs, _ := ParsePredicate("node*", "", "d")
+			Ω(dirs.Match("node_modules/", true, -1)).To(BeTrue())
+			Ω(dirs.Match("node.tar", false, 1)).To(BeFalse())
+		})
+	})
+
+	Describe("Test CheckToFilter()", func() {
+		It("Should match omitted paths exactly", func() {
+			filterList := GetFilterList("app/node_modules/; logs", false)
+			Ω(CheckToFilter("/app/node_modules", filterList)).To(BeTrue())
+			Ω(CheckToFilter("/logs", filterList)).To(BeTrue())
+			Ω(CheckToFilter("/app", filterList)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+)
+
+/*
+*	A Predicate selects files and directories the way find does. Name is a glob matched against
+*	the base name. Size compares the listed size: above it if SizeOp is 1, below it if -1, and
+*	equal once rounded the way cf files lists it if 0; entries of unknown size, such as
+*	directories, never match a size. Type is "f" or "d" to select only files or directories.
+*	An empty field selects everything.
+ */
+type Predicate struct {
+	Name   string
+	SizeOp int
+	Size   int64
+	Type   string
+	sized  bool
+}
+
+/*
+*	ParsePredicate() reads the values of --name, --size and --type. Sizes are written as find
+*	does, e.g. +10M for more than 10M, -1K for less than 1K or 136B for exactly 136B.
+ */
+func ParsePredicate(name, size, kind string) (Predicate, error) {
+	p := Predicate{Name: name, Type: kind}
+
+	if _, err := path.Match(name, ""); err != nil {
+		return p, errors.New("invalid --name pattern '" + name + "'")
+	}
+
+	if kind != "" && kind != "f" && kind != "d" {
+		return p, errors.New("invalid --type '" + kind + "', expected f or d")
+	}
+
+	if size = strings.ToUpper(strings.TrimSpace(size)); size != "" {
+		switch {
+		case strings.HasPrefix(size, "+"):
+			p.SizeOp = 1
+		case strings.HasPrefix(size, "-"):
+			p.SizeOp = -1
+		}
+
+		value := strings.TrimLeft(size, "+-")
+		if !strings.ContainsAny(value, "BKMG") {
+			value += "B"
+		}
+		// 10M and 10MB alike
+		if p.Size = dir_parser.ParseSize(value); p.Size < 0 {
+			p.Size = dir_parser.ParseSize(strings.TrimSuffix(value, "B"))
+		}
+		if p.Size < 0 {
+			return p, errors.New("invalid --size '" + size + "', expected a size such as +10M or -1K")
+		}
+		p.sized = true
+	}
+
+	return p, nil
+}
+
+/*
+*	Empty() reports whether the predicate selects everything.
+ */
+func (p Predicate) Empty() bool {
+	return p.Name == "" && p.Type == "" && !p.sized
+}
+
+/*
+*	Match() reports whether an entry named 'name' is selected. Directory names may end in a slash.
+ */
+func (p Predicate) Match(name string, dir bool, size int64) bool {
+	if (p.Type == "f" && dir) || (p.Type == "d" && !dir) {
+		return false
+	}
+
+	if p.Name != "" {
+		if match, _ := path.Match(p.Name, strings.TrimSuffix(name, "/")); !match {
+			return false
+		}
+	}
+
+	if p.sized {
+		switch {
+		case size < 0:
+			return false
+		case p.SizeOp > 0:
+			return size > p.Size
+		case p.SizeOp < 0:
+			return size < p.Size
+		default:
+			return dir_parser.FormatSize(size) == dir_parser.FormatSize(p.Size)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+// contains the flag values of cf download-find
+type findFlagVal struct {
+	commonFlagVal
+	Match_flag     filter.Predicate
+	Download_flag  bool
+	OverWrite_flag bool
+}
+
+/*
+*	RunFind() is the entry point of cf download-find. It prints the files and directories below
+*	PATH, or the whole app, that match --name, --size and --type, without fetching them unless
+*	--download is given.
+ */
+func RunFind(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 1, "App Name")
+
+	flagVals, readPath := ParseFindArgs(args)
+	appName = args[1]
+
+	// the matches go to stdout, so the info about .cfignore goes to stderr
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterListTo(os.Stderr, flagVals.Omit_flag, flagVals.Verbose_flag)
+	parser := dir_parser.NewParser(cmdExec, appName, flagVals.Instance_flag, onWindows, false)
+
+	matches, files, failed := FindMatches(parser, readPath, filterList, flagVals.Match_flag)
+	for _, val := range matches {
+		fmt.Println(val)
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(os.Stderr, "\nThe following directories could not be listed and were not searched:")
+		for _, val := range failed {
+			fmt.Fprintln(os.Stderr, val)
+		}
+	}
+
+	if flagVals.Download_flag {
+		downloadMatches(cmdExec, readPath, files, flagVals, onWindows)
+	}
+
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-find and returns the app path to search,
+*	given as the optional argument after APP_NAME.
+ */
+func ParseFindArgs(args []string) (findFlagVal, string) {
+	f1 := newCommandFlags("download-find", true)
+	namep := f1.String("name", "", "--name pattern")
+	sizep := f1.String("size", "", "--size [+|-]size")
+	typep := f1.String("type", "", "--type f|d")
+	downloadp := f1.Bool("download", false, "--download")
+	overWritep := f1.Bool("overwrite", false, "--overwrite")
+
+	readPath, err := f1.ParsePath(args, 2)
+	var match filter.Predicate
+	if err == nil {
+		match, err = filter.ParsePredicate(*namep, *sizep, *typep)
+	}
+	f1.ExitOnError(err)
+
+	flagVals := findFlagVal{
+		commonFlagVal:  f1.Common(),
+		Match_flag:     match,
+		Download_flag:  *downloadp,
+		OverWrite_flag: *overWritep,
+	}
+
+	return flagVals, readPath
+}
+
+/*
+*	This function walks the app below 'readPath' and returns the app paths that 'match' selects,
+*	sorted, with directories ending in a slash. The matching files are also returned relative to
+*	'readPath', along with the directories that could not be listed.
+ */
+func FindMatches(parser dir_parser.Parser, readPath string, filterList []string, match filter.Predicate) ([]string, []string, []string) {
+	var mu sync.Mutex
+	var matches, files, failed []string
+
+	w := walker.NewWalker(parser, walker.DefaultWorkers, walker.DefaultWorkers)
+	w.Walk(readPath, filterList, func(listing walker.Listing) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if listing.Failed {
+			failed = append(failed, listing.ReadPath)
+		}
+		for _, val := range listing.Dirs {
+			if match.Match(val, true, -1) {
+				matches = append(matches, listing.ReadPath+val)
+			}
+		}
+		for _, val := range listing.Files {
+			if match.Match(val.Name, false, val.Size) {
+				matches = append(matches, val.ReadPath)
+				files = append(files, strings.TrimPrefix(val.ReadPath, readPath))
+			}
+		}
+
+		return true
+	})
+
+	sort.Strings(matches)
+	sort.Strings(files)
+	sort.Strings(failed)
+
+	return matches, files, failed
+}
+
+/*
+*	This function fetches the matching files to where cf download would put them, keeping their
+*	paths. An existing destination is only written to with --overwrite, which replaces the
+*	matching files and leaves everything else alone.
+ */
+func downloadMatches(cmdExec cmd_exec.CmdExec, readPath string, files []string, flagVals findFlagVal, onWindows bool) {
+	workingDir, err := os.Getwd()
+	check(err, "Called by: Getwd")
+
+	var paths []string
+	if readPath != "/" {
+		paths = append(paths, readPath)
+	}
+	writeRoot := GetDirectoryContext(workingDir, paths, false)[0].RootWorkingDirectoryLocal
+
+	if Exists(writeRoot) && !flagVals.OverWrite_flag {
+		fmt.Println("\nError: destination path", writeRoot, "already exists.\n\nDelete it or rerun the command with the '--overwrite' flag.")
+		os.Exit(1)
+	}
+
+	var wg sync.WaitGroup
+	dloader := downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, flagVals.Verbose_flag, onWindows)
+	defer dloader.Close()
+	fetchFiles(dloader, &wg, readPath, writeRoot, files, onWindows)
+
+	fmt.Printf("\nDownloaded %d of %d matching files to %s.\n", dloader.GetFilesDownloadedCount(), len(files), writeRoot)
+	if failed := dloader.GetFailedDownloads(); len(failed) > 0 {
+		fmt.Println("The following files could not be downloaded:")
+		PrintSlice(failed)
+	}
+}
@@ -0,0 +1,122 @@
+package git_repo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/metadata"
+)
+
+type Repo interface {
+	Commit(message string) (string, error)
+	Created() bool
+}
+
+// set in the local config of every repository cf download created or was allowed to adopt
+const managedKey = "cf-download.managed"
+
+// used for commits when git has no identity configured
+const (
+	fallbackName  = "cf download"
+	fallbackEmail = "cf-download@localhost"
+)
+
+type repo struct {
+	dir     string
+	created bool
+}
+
+/*
+*	Open() returns the git repository in 'dir', creating it if 'dir' is not one yet. A repository
+*	cf download did not create is refused unless 'adopt' is set, it is then marked as managed so
+*	later runs commit to it as well. Only the local git binary is used.
+ */
+func Open(dir string, adopt bool) (*repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git was not found on the PATH")
+	}
+
+	r := &repo{dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if _, err := r.git("init", "-q"); err != nil {
+			return nil, err
+		}
+		r.created = true
+	} else if managed, _ := r.git("config", "--local", "--get", managedKey); managed != "true" && !adopt {
+		return nil, errors.New(dir + " is a git repository cf download did not create, rerun with --git-adopt to commit to it")
+	}
+
+	if _, err := r.git("config", "--local", managedKey, "true"); err != nil {
+		return nil, err
+	}
+
+	return r, r.exclude(metadata.FileName)
+}
+
+/*
+*	Commit() records the state of the whole directory, including removed files, and returns the
+*	short hash of the new commit. Nothing is committed and "" is returned if nothing changed.
+ */
+func (r *repo) Commit(message string) (string, error) {
+	if _, err := r.git("add", "-A", "."); err != nil {
+		return "", err
+	}
+
+	status, err := r.git("status", "--porcelain")
+	if err != nil || status == "" {
+		return "", err
+	}
+
+	args := []string{"commit", "-q", "-m", message}
+	if email, _ := r.git("config", "user.email"); email == "" {
+		args = append([]string{"-c", "user.name=" + fallbackName, "-c", "user.email=" + fallbackEmail}, args...)
+	}
+	if _, err := r.git(args...); err != nil {
+		return "", err
+	}
+
+	return r.git("rev-parse", "--short", "HEAD")
+}
+
+/*
+*	Created() reports whether Open() created the repository, i.e. this is the first commit.
+ */
+func (r *repo) Created() bool {
+	return r.created
+}
+
+// keeps 'name' at the top of the repository out of every commit
+func (r *repo) exclude(name string) error {
+	path := filepath.Join(r.dir, ".git", "info", "exclude")
+	content, _ := ioutil.ReadFile(path)
+	for _, val := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(val) == "/"+name {
+			return nil
+		}
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(content, []byte("/"+name+"\n")...), 0644)
+}
+
+// runs git in the repository and returns its trimmed output
+func (r *repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.New("git " + args[0] + " failed in " + r.dir + ": " + strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,13 @@
+package git_repo_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGitRepo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Git Repo Suite")
+}
@@ -0,0 +1,121 @@
+package git_repo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/downloader"
+	. "github.com/ibmjstart/cf-download/git_repo"
+	"github.com/ibmjstart/cf-download/metadata"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GitRepo", func() {
+	var dir string
+
+	git := func(args ...string) string {
+		output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "git-repo-test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("creates a repository on the first run and commits only changes", func() {
+		ioutil.WriteFile(filepath.Join(dir, "app.js"), []byte("one\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, metadata.FileName), []byte("{}"), 0644)
+
+		repo, err := Open(dir, false)
+		Expect(err).To(BeNil())
+		Expect(repo.Created()).To(BeTrue())
+
+		first, err := repo.Commit("first download")
+		Expect(err).To(BeNil())
+		Expect(first).NotTo(BeEmpty())
+		Expect(git("ls-files")).To(Equal("app.js"))
+
+		// a second run with nothing changed adds no commit
+		repo, err = Open(dir, false)
+		Expect(err).To(BeNil())
+		Expect(repo.Created()).To(BeFalse())
+		ioutil.WriteFile(filepath.Join(dir, metadata.FileName), []byte(`{"app": "changed"}`), 0644)
+
+		hash, err := repo.Commit("second download")
+		Expect(err).To(BeNil())
+		Expect(hash).To(BeEmpty())
+
+		// removed and added files are both recorded
+		os.Remove(filepath.Join(dir, "app.js"))
+		ioutil.WriteFile(filepath.Join(dir, "log.txt"), []byte("started\n"), 0644)
+
+		hash, err = repo.Commit("third download")
+		Expect(err).To(BeNil())
+		Expect(hash).NotTo(BeEmpty())
+		Expect(git("ls-files")).To(Equal("log.txt"))
+		Expect(git("log", "--format=%s")).To(Equal("third download\nfirst download"))
+	})
+
+	It("records a file removed in the app between two mirrored downloads as a deletion", func() {
+		remote, err := ioutil.TempDir("", "git-repo-remote")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(remote)
+		ioutil.WriteFile(filepath.Join(remote, "app.js"), []byte("a"), 0644)
+		ioutil.WriteFile(filepath.Join(remote, "old.js"), []byte("o"), 0644)
+
+		cmdExec := cmd_exec_fake.NewCmdExec()
+		cmdExec.SetFakeDir(true)
+		download := func(message string) {
+			var wg sync.WaitGroup
+			d := downloader.NewDownloader(cmdExec, &wg, "app", "0", false, false)
+			defer d.Close()
+			d.SetSync(true)
+			d.SetMirror(true)
+
+			wg.Add(1)
+			d.DownloadDir(remote+"/", dir+"/", nil)
+			wg.Wait()
+
+			repo, err := Open(dir, false)
+			Expect(err).To(BeNil())
+			_, err = repo.Commit(message)
+			Expect(err).To(BeNil())
+		}
+
+		download("first download")
+		Expect(git("ls-files")).To(Equal("app.js\nold.js"))
+
+		os.Remove(filepath.Join(remote, "old.js"))
+		download("second download")
+		Expect(git("ls-files")).To(Equal("app.js"))
+		Expect(git("show", "--name-status", "--format=%s")).To(Equal("second download\n\nD\told.js"))
+	})
+
+	It("refuses a repository it did not create unless asked to adopt it", func() {
+		git("init", "-q")
+
+		_, err := Open(dir, false)
+		Expect(err).NotTo(BeNil())
+
+		repo, err := Open(dir, true)
+		Expect(err).To(BeNil())
+		Expect(repo.Created()).To(BeFalse())
+
+		// once adopted it is managed
+		_, err = Open(dir, false)
+		Expect(err).To(BeNil())
+	})
+})
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/remote_grep"
+)
+
+// contains the flag values of cf download-grep
+type grepFlagVal struct {
+	commonFlagVal
+	IgnoreCase_flag bool
+	Context_flag    int
+	Name_flag       string
+	NoSsh_flag      bool
+}
+
+/*
+*	RunGrep() is the entry point of cf download-grep. It prints the lines of the files below PATH,
+*	or the whole app, that match PATTERN the way grep -rn does, and exits with 1 if none match.
+ */
+func RunGrep(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 1, "App Name")
+
+	flagVals, pattern, readPath := ParseGrepArgs(args)
+	appName = args[1]
+
+	// the matches go to stdout, so the info about .cfignore goes to stderr
+	cmdExec := cmd_exec.NewCmdExec()
+	filterList := filter.GetFilterListTo(os.Stderr, flagVals.Omit_flag, flagVals.Verbose_flag)
+
+	match, _ := filter.ParsePredicate(flagVals.Name_flag, "", "f")
+	transport := remote_grep.TransportSsh
+	if flagVals.NoSsh_flag {
+		transport = remote_grep.TransportFiles
+	}
+
+	grepper, err := remote_grep.NewGrepper(cmdExec, appName, flagVals.Instance_flag, pattern, flagVals.IgnoreCase_flag, flagVals.Context_flag, match, transport)
+	if err != nil {
+		fmt.Println(createMessage("\nError: invalid PATTERN: "+err.Error(), "red+b", onWindows))
+		os.Exit(1)
+	}
+	grepper.SetInstanceManager(instance_manager.NewInstanceManager(cmdExec, appName, flagVals.Instance_flag, true))
+
+	lines := grepper.Grep(readPath, filterList)
+	if flagVals.Verbose_flag {
+		if grepper.UsedSsh() {
+			fmt.Fprintln(os.Stderr, "[ Info: searched in the container with cf ssh ]")
+		} else {
+			fmt.Fprintln(os.Stderr, "[ Info: cf ssh is not available, searched the files fetched with cf files ]")
+		}
+	}
+
+	for _, val := range lines {
+		fmt.Println(val)
+	}
+
+	if failed := grepper.GetFailed(); len(failed) > 0 {
+		fmt.Fprintln(os.Stderr, "\nThe following paths could not be listed or fetched and were not searched:")
+		for _, val := range failed {
+			fmt.Fprintln(os.Stderr, val)
+		}
+	}
+
+	if len(lines) == 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-grep and returns PATTERN and the app path to
+*	search, given as the optional argument after PATTERN. A PATTERN starting with a dash is given
+*	after -e, as for grep.
+ */
+func ParseGrepArgs(args []string) (grepFlagVal, string, string) {
+	f1 := newCommandFlags("download-grep", true)
+	ignoreCasep := f1.Bool("ignore-case", false, "--ignore-case")
+	contextp := f1.Int("context", 0, "--context lines")
+	namep := f1.String("name", "", "--name pattern")
+	noSshp := f1.Bool("no-ssh", false, "--no-ssh")
+
+	pattern, index := "", 3
+	if len(args) > 3 && args[2] == "-e" {
+		pattern, index = args[3], 4
+	} else if len(args) > 2 && !strings.HasPrefix(args[2], "-") {
+		pattern = args[2]
+	} else {
+		index = 0
+	}
+
+	readPath, err := f1.ParsePath(args, index)
+	if index == 0 {
+		err = errors.New("Missing PATTERN")
+	}
+	if err == nil && *contextp < 0 {
+		err = errors.New("--context must not be negative")
+	}
+	if err == nil {
+		_, err = filter.ParsePredicate(*namep, "", "f")
+	}
+	f1.ExitOnError(err)
+
+	flagVals := grepFlagVal{
+		commonFlagVal:   f1.Common(),
+		IgnoreCase_flag: *ignoreCasep,
+		Context_flag:    *contextp,
+		Name_flag:       *namep,
+		NoSsh_flag:      *noSshp,
+	}
+
+	return flagVals, pattern, readPath
+}
@@ -0,0 +1,236 @@
+package instance_manager
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+)
+
+type InstanceManager interface {
+	Current() string
+	Failover(failed string) (string, bool)
+	Served(readPath, instance string)
+	GetServed() []ServedDir
+	GetSwitches() []Switch
+}
+
+// state and start time of a single app instance as reported by cf app
+type Instance struct {
+	Index string
+	State string
+	Since string
+}
+
+// a directory listing and the instance that returned it
+type ServedDir struct {
+	ReadPath string
+	Instance string
+}
+
+// a failover from one instance to another
+type Switch struct {
+	From string
+	To   string
+	At   time.Time
+}
+
+type instanceManager struct {
+	cmdExec   cmd_exec.CmdExec
+	appName   string
+	current   string
+	enabled   bool
+	lastProbe time.Time
+	served    map[string]string
+	switches  []Switch
+	mu        sync.Mutex
+}
+
+// minimum time between two health probes of the same instance
+var probeInterval = 5 * time.Second
+
+func NewInstanceManager(cmdExec cmd_exec.CmdExec, appName, instance string, enabled bool) *instanceManager {
+	return &instanceManager{
+		cmdExec: cmdExec,
+		appName: appName,
+		current: instance,
+		enabled: enabled,
+		served:  make(map[string]string),
+	}
+}
+
+/*
+*	Current() returns the instance all new requests should be sent to.
+ */
+func (m *instanceManager) Current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.current
+}
+
+/*
+*	Failover() is called after a request against the 'failed' instance went wrong. If another
+*	request already moved the download to a different instance that instance is returned straight
+*	away, otherwise cf app is used to check whether the failed instance is still running. When it
+*	is not, the first healthy instance is selected. The bool result reports whether the caller
+*	should retry its request against the returned instance.
+ */
+func (m *instanceManager) Failover(failed string) (string, bool) {
+	if !m.enabled {
+		return failed, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != failed {
+		return m.current, true
+	}
+
+	// avoid flooding the api with cf app calls when many files fail at once
+	if time.Since(m.lastProbe) < probeInterval {
+		return m.current, false
+	}
+	m.lastProbe = time.Now()
+
+	output, _ := m.cmdExec.GetAppStatus(m.appName)
+	instances := ParseInstances(output)
+	if len(instances) == 0 {
+		return m.current, false
+	}
+
+	for _, val := range instances {
+		if val.Index == failed && IsHealthy(val) {
+			// the instance is fine, the request failed for some other reason
+			return m.current, false
+		}
+	}
+
+	for _, val := range instances {
+		if val.Index != failed && IsHealthy(val) {
+			m.current = val.Index
+			m.switches = append(m.switches, Switch{From: failed, To: val.Index, At: time.Now()})
+			return m.current, true
+		}
+	}
+
+	return m.current, false
+}
+
+/*
+*	Served() records which instance returned the listing of a directory. The records are used to
+*	re-check directories after a failover and to summarize which instance served which part of the tree.
+ */
+func (m *instanceManager) Served(readPath, instance string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.served[readPath] = instance
+}
+
+/*
+*	GetServed() returns every recorded directory sorted by path.
+ */
+func (m *instanceManager) GetServed() []ServedDir {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var served []ServedDir
+	for path, instance := range m.served {
+		served = append(served, ServedDir{ReadPath: path, Instance: instance})
+	}
+	sort.Slice(served, func(i, j int) bool {
+		return served[i].ReadPath < served[j].ReadPath
+	})
+
+	return served
+}
+
+func (m *instanceManager) GetSwitches() []Switch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Switch(nil), m.switches...)
+}
+
+/*
+*	ParseInstances() reads the instance table printed by cf app. Rows look like
+*	"#0   running   2016-09-13 03:04:05 PM   0.0%   ..." and the 'since' column runs
+*	until the cpu column, which is the first field ending in '%'.
+ */
+func ParseInstances(output []byte) []Instance {
+	var instances []Instance
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		instance := Instance{
+			Index: strings.TrimPrefix(fields[0], "#"),
+			State: fields[1],
+		}
+
+		var since []string
+		for _, val := range fields[2:] {
+			if strings.HasSuffix(val, "%") {
+				break
+			}
+			since = append(since, val)
+		}
+		instance.Since = strings.Join(since, " ")
+
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+func IsHealthy(instance Instance) bool {
+	return strings.EqualFold(instance.State, "running")
+}
+
+/*
+*	SummarizeServed() collapses the served directories into the top most directory of every
+*	subtree that was listed by a single instance.
+ */
+func SummarizeServed(served []ServedDir) []ServedDir {
+	byPath := make(map[string]string)
+	for _, val := range served {
+		byPath[val.ReadPath] = val.Instance
+	}
+
+	var summary []ServedDir
+	for _, val := range served {
+		parent := ParentDir(val.ReadPath)
+		if instance, ok := byPath[parent]; ok && parent != val.ReadPath && instance == val.Instance {
+			continue
+		}
+		summary = append(summary, val)
+	}
+
+	return summary
+}
+
+// returns the parent of a server directory path, keeping the trailing slash
+func ParentDir(readPath string) string {
+	trimmed := strings.TrimSuffix(readPath, "/")
+	index := strings.LastIndex(trimmed, "/")
+	if index < 0 {
+		return "/"
+	}
+
+	return trimmed[:index+1]
+}
+
+/*
+*	ResponseOK() returns true if the output of cf files reports success.
+ */
+func ResponseOK(output []byte) bool {
+	lines := strings.SplitAfterN(string(output), "\n", 3)
+	return len(lines) >= 2 && strings.Contains(lines[1], "OK")
+}
@@ -0,0 +1,13 @@
+package instance_manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInstanceManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "InstanceManager Suite")
+}
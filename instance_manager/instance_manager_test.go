@@ -0,0 +1,152 @@
+package instance_manager_test
+
+import (
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	. "github.com/ibmjstart/cf-download/instance_manager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const appStatus = "Showing health and status for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\n\nrequested state: started\ninstances: 2/3\n\n     state     since                    cpu    memory          disk          details\n#0   crashed   2016-09-13 03:04:05 PM   0.0%   0 of 1G         0 of 1G\n#1   starting  2016-09-13 03:05:10 PM   0.0%   0 of 1G         0 of 1G\n#2   running   2016-09-13 01:00:00 PM   0.3%   120.5M of 1G    210M of 1G\n"
+
+var _ = Describe("InstanceManager", func() {
+	var cmdExec cmd_exec_fake.FakeCmdExec
+
+	BeforeEach(func() {
+		cmdExec = cmd_exec_fake.NewCmdExec()
+		cmdExec.SetAppStatus(appStatus)
+	})
+
+	Describe("Test ParseInstances()", func() {
+		It("Should parse the instance table printed by cf app", func() {
+			instances := ParseInstances([]byte(appStatus))
+			Ω(len(instances)).To(Equal(3))
+			Ω(instances[0]).To(Equal(Instance{Index: "0", State: "crashed", Since: "2016-09-13 03:04:05 PM"}))
+			Ω(instances[2].Index).To(Equal("2"))
+			Ω(IsHealthy(instances[1])).To(BeFalse())
+			Ω(IsHealthy(instances[2])).To(BeTrue())
+		})
+
+		It("Should parse the newer cf cli format", func() {
+			instances := ParseInstances([]byte("     state     since                  cpu    memory\n#0   running   2020-01-01T00:00:00Z   0.3%   10M of 1G\n"))
+			Ω(len(instances)).To(Equal(1))
+			Ω(instances[0].Since).To(Equal("2020-01-01T00:00:00Z"))
+		})
+	})
+
+	Describe("Test Failover()", func() {
+		It("Should switch to the first running instance", func() {
+			m := NewInstanceManager(cmdExec, "payToWin", "0", true)
+			next, switched := m.Failover("0")
+			Ω(switched).To(BeTrue())
+			Ω(next).To(Equal("2"))
+			Ω(m.Current()).To(Equal("2"))
+			Ω(len(m.GetSwitches())).To(Equal(1))
+			Ω(m.GetSwitches()[0].From).To(Equal("0"))
+
+			// later failures of the old instance reuse the new one without probing
+			next, switched = m.Failover("0")
+			Ω(switched).To(BeTrue())
+			Ω(next).To(Equal("2"))
+			Ω(len(m.GetSwitches())).To(Equal(1))
+		})
+
+		It("Should stay on an instance that is still running", func() {
+			m := NewInstanceManager(cmdExec, "payToWin", "2", true)
+			next, switched := m.Failover("2")
+			Ω(switched).To(BeFalse())
+			Ω(next).To(Equal("2"))
+		})
+
+		It("Should never switch when disabled", func() {
+			m := NewInstanceManager(cmdExec, "payToWin", "0", false)
+			_, switched := m.Failover("0")
+			Ω(switched).To(BeFalse())
+			Ω(m.Current()).To(Equal("0"))
+		})
+	})
+
+	Describe("Test SummarizeServed()", func() {
+		It("Should collapse subtrees listed by the same instance", func() {
+			m := NewInstanceManager(cmdExec, "payToWin", "0", true)
+			m.Served("/", "0")
+			m.Served("/app/", "0")
+			m.Served("/app/lib/", "0")
+			m.Served("/app/node_modules/", "2")
+			m.Served("/app/node_modules/express/", "2")
+			m.Served("/logs/", "2")
+
+			summary := SummarizeServed(m.GetServed())
+			Ω(summary).To(Equal([]ServedDir{
+				{ReadPath: "/", Instance: "0"},
+				{ReadPath: "/app/node_modules/", Instance: "2"},
+				{ReadPath: "/logs/", Instance: "2"},
+			}))
+		})
+	})
+})
+
+var _ = Describe("RestartGuard", func() {
+	var (
+		cmdExec   cmd_exec_fake.FakeCmdExec
+		instances InstanceManager
+		g         RestartGuard
+	)
+
+	const running = "     state     since                  cpu\n#0   running   2020-01-01T00:00:00Z   0.0%\n#1   running   2020-01-01T00:00:00Z   0.0%\n"
+	const restarted = "     state     since                  cpu\n#0   running   2020-01-01T01:30:00Z   0.0%\n#1   running   2020-01-01T00:00:00Z   0.0%\n"
+	const crashed = "     state     since                  cpu\n#0   crashed   2020-01-01T01:00:00Z   0.0%\n#1   running   2020-01-01T00:00:00Z   0.0%\n"
+
+	BeforeEach(func() {
+		cmdExec = cmd_exec_fake.NewCmdExec()
+		cmdExec.SetAppStatus(running)
+		instances = NewInstanceManager(cmdExec, "payToWin", "0", false)
+		g = NewRestartGuard(cmdExec, "payToWin", instances)
+		Ω(g.Start()).To(BeTrue())
+	})
+
+	It("Should not report a restart while the start time is unchanged", func() {
+		g.Fetched("/app/a.js")
+		Ω(g.Check()).To(BeFalse())
+		Ω(g.Restarted()).To(BeFalse())
+		Ω(len(g.GetFilesAfterRestart())).To(Equal(0))
+	})
+
+	It("Should list the files fetched since the last consistent check", func() {
+		g.Fetched("/app/a.js")
+		Ω(g.Check()).To(BeFalse())
+
+		g.Fetched("/app/c.js")
+		g.Fetched("/app/b.js")
+		cmdExec.SetAppStatus(restarted)
+		Ω(g.Check()).To(BeTrue())
+
+		g.Fetched("/app/d.js")
+		Ω(g.Check()).To(BeFalse())
+
+		Ω(g.GetRestarts()[0].Before).To(Equal("2020-01-01T00:00:00Z"))
+		Ω(g.GetRestarts()[0].After).To(Equal("2020-01-01T01:30:00Z"))
+		Ω(g.GetFilesAfterRestart()).To(Equal([]string{"/app/b.js", "/app/c.js", "/app/d.js"}))
+	})
+
+	It("Should record a crash and the following restart only once", func() {
+		cmdExec.SetAppStatus(crashed)
+		Ω(g.Check()).To(BeTrue())
+		cmdExec.SetAppStatus(restarted)
+		Ω(g.Check()).To(BeFalse())
+		Ω(len(g.GetRestarts())).To(Equal(1))
+		Ω(g.GetRestarts()[0].After).To(Equal("crashed"))
+	})
+
+	It("Should not treat a failover as a restart", func() {
+		cmdExec.SetAppStatus(crashed)
+		instances = NewInstanceManager(cmdExec, "payToWin", "0", true)
+		g = NewRestartGuard(cmdExec, "payToWin", instances)
+		g.Start()
+
+		instances.Failover("0")
+		Ω(instances.Current()).To(Equal("1"))
+		Ω(g.Check()).To(BeFalse())
+		Ω(g.Restarted()).To(BeFalse())
+	})
+})
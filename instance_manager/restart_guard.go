@@ -0,0 +1,188 @@
+package instance_manager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+)
+
+type RestartGuard interface {
+	Start() bool
+	Check() bool
+	Fetched(readPath string)
+	Restarted() bool
+	GetRestarts() []Restart
+	GetFilesAfterRestart() []string
+}
+
+// a change of the 'since' timestamp of the instance being downloaded from
+type Restart struct {
+	Instance   string
+	Before     string
+	After      string
+	DetectedAt time.Time
+}
+
+type restartGuard struct {
+	cmdExec      cmd_exec.CmdExec
+	appName      string
+	instances    InstanceManager
+	instance     string
+	since        string
+	started      bool
+	restarts     []Restart
+	pending      []string
+	afterRestart []string
+	mu           sync.Mutex
+}
+
+func NewRestartGuard(cmdExec cmd_exec.CmdExec, appName string, instances InstanceManager) *restartGuard {
+	return &restartGuard{
+		cmdExec:   cmdExec,
+		appName:   appName,
+		instances: instances,
+	}
+}
+
+/*
+*	Start() records the start time of the current instance before the crawl begins. It returns
+*	false if the instance could not be found in the output of cf app, in which case later checks
+*	take their baseline from the first status they can read.
+ */
+func (g *restartGuard) Start() bool {
+	instance, ok := g.lookup(g.instances.Current())
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ok {
+		g.instance = instance.Index
+		g.since = instance.Since
+		g.started = true
+	}
+
+	return ok
+}
+
+/*
+*	Check() compares the start time of the current instance with the recorded one and returns true
+*	if it changed, i.e. the instance was restarted since the last check. Files reported through
+*	Fetched() since the last consistent check are then treated as fetched after the restart.
+*	A failover to another instance is not a restart, the new instance simply becomes the baseline.
+ */
+func (g *restartGuard) Check() bool {
+	current := g.instances.Current()
+	instance, ok := g.lookup(current)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.started || g.instance != current {
+		if ok {
+			g.instance = current
+			g.since = instance.Since
+			g.started = true
+			g.pending = nil
+		}
+		return false
+	}
+
+	// a status we cannot read tells us nothing either way
+	if !ok {
+		return false
+	}
+
+	// the restart of an instance that went down was already recorded, wait for it to come back
+	if g.since == "" {
+		if IsHealthy(instance) {
+			g.since = instance.Since
+		}
+		return false
+	}
+
+	if instance.Since == g.since && IsHealthy(instance) {
+		g.pending = nil
+		return false
+	}
+
+	after := instance.Since
+	if !IsHealthy(instance) {
+		after = instance.State
+	}
+	g.restarts = append(g.restarts, Restart{
+		Instance:   current,
+		Before:     g.since,
+		After:      after,
+		DetectedAt: time.Now(),
+	})
+	g.since = ""
+	if IsHealthy(instance) {
+		g.since = instance.Since
+	}
+	g.afterRestart = append(g.afterRestart, g.pending...)
+	g.pending = nil
+
+	return true
+}
+
+/*
+*	Fetched() is called for every file written to disk.
+ */
+func (g *restartGuard) Fetched(readPath string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.restarts) > 0 {
+		g.afterRestart = append(g.afterRestart, readPath)
+	} else {
+		g.pending = append(g.pending, readPath)
+	}
+}
+
+func (g *restartGuard) Restarted() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return len(g.restarts) > 0
+}
+
+func (g *restartGuard) GetRestarts() []Restart {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]Restart(nil), g.restarts...)
+}
+
+/*
+*	GetFilesAfterRestart() returns, sorted, the files that were fetched after the last check that
+*	still saw the original instance. Some of them may predate the restart, none of the files
+*	missing from the list do.
+ */
+func (g *restartGuard) GetFilesAfterRestart() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	files := append([]string(nil), g.afterRestart...)
+	sort.Strings(files)
+
+	return files
+}
+
+// finds the given instance in the output of cf app, a missing instance is reported as not running
+func (g *restartGuard) lookup(index string) (Instance, bool) {
+	output, _ := g.cmdExec.GetAppStatus(g.appName)
+	instances := ParseInstances(output)
+	if len(instances) == 0 {
+		return Instance{}, false
+	}
+
+	for _, val := range instances {
+		if val.Index == index {
+			return val, true
+		}
+	}
+
+	return Instance{Index: index, State: "missing"}, true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/remote_glob"
+	"github.com/ibmjstart/cf-download/remote_tree"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+// contains the flag values of cf download-ls
+type lsFlagVal struct {
+	commonFlagVal
+	Depth_flag    int
+	Json_flag     bool
+	Csv_flag      bool
+	Dotfiles_flag bool
+}
+
+/*
+*	RunLs() is the entry point of cf download-ls. It lists the files below each PATH, or the
+*	whole app, with their sizes without downloading anything.
+ */
+func RunLs(args []string) {
+	onWindows := IsWindows()
+	StartCommand(args, 1, "App Name")
+
+	flagVals, paths := ParseLsArgs(args)
+	appName = args[1]
+
+	cmdExec := cmd_exec.NewCmdExec()
+
+	// keep the info about .cfignore out of --json and --csv output
+	quiet := flagVals.Json_flag || flagVals.Csv_flag
+	info := os.Stdout
+	if quiet {
+		info = os.Stderr
+	}
+	filterList := filter.GetFilterListTo(info, flagVals.Omit_flag, flagVals.Verbose_flag)
+
+	parser := dir_parser.NewParser(cmdExec, appName, flagVals.Instance_flag, onWindows, flagVals.Verbose_flag && !quiet)
+	lister := remote_tree.NewTreeLister(parser, walker.DefaultWorkers)
+
+	readPaths, err := LsPaths(parser, paths, flagVals.Dotfiles_flag)
+	if err != nil {
+		fmt.Println(createMessage("\nError: "+err.Error(), "red+b", onWindows))
+		os.Exit(1)
+	}
+
+	var roots []*remote_tree.Node
+	for _, val := range readPaths {
+		roots = append(roots, lister.List(val, filterList, flagVals.Depth_flag))
+	}
+
+	switch {
+	case flagVals.Json_flag:
+		output, err := json.MarshalIndent(roots, "", "  ")
+		check(err, "Cannot write the listing as JSON")
+		fmt.Println(string(output))
+	case flagVals.Csv_flag:
+		err := remote_tree.WriteCSV(os.Stdout, roots)
+		check(err, "Cannot write the listing as CSV")
+	default:
+		printTrees(roots)
+	}
+
+	for _, val := range roots {
+		if val.Failed {
+			os.Exit(1)
+		}
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-ls and returns the PATH arguments.
+ */
+func ParseLsArgs(args []string) (lsFlagVal, []string) {
+	f1 := newCommandFlags("download-ls", true)
+	depthp := f1.Int("depth", 0, "--depth levels")
+	jsonp := f1.Bool("json", false, "--json")
+	csvp := f1.Bool("csv", false, "--csv")
+	dotfilesp := f1.Bool("dotfiles", false, "--dotfiles")
+
+	var paths []string
+	for _, v := range args[2:] {
+		if strings.HasPrefix(v, "-") {
+			break
+		}
+		paths = append(paths, v)
+	}
+
+	err := f1.Parse(args[2+len(paths):])
+	if err == nil && *depthp < 0 {
+		err = errors.New("--depth must not be negative")
+	}
+	if err == nil && *jsonp && *csvp {
+		err = errors.New("--json and --csv cannot be used together")
+	}
+	f1.ExitOnError(err)
+
+	flagVals := lsFlagVal{
+		commonFlagVal: f1.Common(),
+		Depth_flag:    *depthp,
+		Json_flag:     *jsonp,
+		Csv_flag:      *csvp,
+		Dotfiles_flag: *dotfilesp,
+	}
+
+	return flagVals, paths
+}
+
+/*
+*	This function turns the PATH arguments into app paths. Globs are expanded, a match without a
+*	trailing slash is a file. Every other path is a directory, no path is the whole app. A glob
+*	that matches nothing is an error.
+ */
+func LsPaths(parser dir_parser.Parser, paths []string, dotfiles bool) ([]string, error) {
+	if len(paths) == 0 {
+		return []string{"/"}, nil
+	}
+
+	var readPaths []string
+	for _, v := range paths {
+		matches := []string{strings.TrimSuffix(v, "/") + "/"}
+		if remote_glob.IsPattern(v) {
+			var err error
+			matches, _, err = ExpandGlobs(parser, []string{v}, dotfiles)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, val := range matches {
+			readPath := path.Clean("/" + val)
+			if strings.HasSuffix(val, "/") && readPath != "/" {
+				readPath += "/"
+			}
+			readPaths = append(readPaths, readPath)
+		}
+	}
+
+	return readPaths, nil
+}
+
+/*
+*	This function prints every tree followed by the number of directories and files and their
+*	total listed size.
+ */
+func printTrees(roots []*remote_tree.Node) {
+	dirs, files := 0, 0
+	var size int64
+	for i, val := range roots {
+		if i > 0 {
+			fmt.Println("")
+		}
+		remote_tree.WriteTree(os.Stdout, val)
+
+		d, f := val.Counts()
+		dirs, files = dirs+d, files+f
+		if !val.Dir {
+			files++
+		}
+		if val.Size > 0 {
+			size += val.Size
+		}
+	}
+
+	fmt.Printf("\n%d directories, %d files, %s\n", dirs, files, dir_parser.FormatSize(size))
+}
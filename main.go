@@ -12,6 +12,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/cloudfoundry/cli/plugin"
@@ -19,9 +21,20 @@ import (
 	"github.com/ibmjstart/cf-download/dir_parser"
 	"github.com/ibmjstart/cf-download/downloader"
 	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/git_repo"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/metadata"
+	"github.com/ibmjstart/cf-download/rate_limiter"
+	"github.com/ibmjstart/cf-download/remote_glob"
+	"github.com/ibmjstart/cf-download/scheduler"
+	"github.com/ibmjstart/cf-download/snapshot"
+	"github.com/ibmjstart/cf-download/walker"
+	"github.com/ibmjstart/cf-download/watcher"
 	"github.com/mgutz/ansi"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -38,17 +51,40 @@ type DownloadPlugin struct{}
 
 // contains flag values
 type flagVal struct {
-	Omit_flag      string
-	OverWrite_flag bool
-	Instance_flag  string
-	Verbose_flag   bool
-	File_flag      bool
+	Omit_flag          string
+	OverWrite_flag     bool
+	Instance_flag      string
+	Verbose_flag       bool
+	File_flag          bool
+	NoFailover_flag    bool
+	FailOnRestart_flag bool
+	Schedule_flag      scheduler.Policy
+	LimitRate_flag     int64
+	MaxRps_flag        float64
+	MaxBytes_flag      int64
+	MaxFiles_flag      int
+	MaxFailures_flag   int
+	Sync_flag          bool
+	Mirror_flag        bool
+	DryRun_flag        bool
+	ConfirmAbove_flag  int64
+	Yes_flag           bool
+	Watch_flag         time.Duration
+	Snapshot_flag      string
+	Git_flag           bool
+	GitAdopt_flag      bool
+	MergeInto_flag     string
+	Match_flag         filter.Predicate
+	Stdout_flag        bool
+	Tar_flag           bool
+	Dotfiles_flag      bool
 }
 
 // contains local and server paths
 type pathVal struct {
 	RootWorkingDirectoryLocal string
 	StartingPathServer        string
+	IsFile                    bool
 }
 
 var (
@@ -57,8 +93,14 @@ var (
 	failedDownloads []string
 	parser          dir_parser.Parser
 	dloader         downloader.Downloader
+	instances       instance_manager.InstanceManager
+	guard           instance_manager.RestartGuard
+	limiter         rate_limiter.RateLimiter
 )
 
+// how often the instance is checked for restarts while downloading
+const restartCheckInterval = 30 * time.Second
+
 // global wait group for all download threads
 var wg sync.WaitGroup
 
@@ -78,6 +120,41 @@ var wg sync.WaitGroup
  */
 
 func (c *DownloadPlugin) Run(cliConnection plugin.CliConnection, args []string) {
+	if args[0] == "download-diff" {
+		RunDiff(args)
+		return
+	}
+	if args[0] == "download-compare" {
+		RunCompare(args)
+		return
+	}
+	if args[0] == "download-snapshot" {
+		RunSnapshot(args)
+		return
+	}
+	if args[0] == "download-drift" {
+		RunDrift(args)
+		return
+	}
+	if args[0] == "download-ls" {
+		RunLs(args)
+		return
+	}
+	if args[0] == "download-du" {
+		RunDu(args)
+		return
+	}
+	if args[0] == "download-find" {
+		RunFind(args)
+		return
+	}
+	if args[0] == "download-grep" {
+		RunGrep(args)
+		return
+	}
+	if args[0] == "download-cat" {
+		args = CatArgs(args)
+	}
 	if args[0] != "download" {
 		os.Exit(0)
 	}
@@ -94,22 +171,92 @@ func (c *DownloadPlugin) Run(cliConnection plugin.CliConnection, args []string)
 		os.Exit(1)
 	}
 
+	// get server path to download from and local path to download to
+	workingDir, err := os.Getwd()
+	check(err, "Called by: Getwd")
+
+	// repeat the download recorded in the working directory
+	var refreshMeta *metadata.Metadata
+	if args[1] == "--refresh" || args[1] == "-refresh" {
+		meta, err := metadata.Read(workingDir)
+		check(err, "")
+		refreshMeta = &meta
+		args = RefreshArgs(meta, args)
+	}
+
 	// parse input flags
 	flagVals, paths := ParseArgs(args)
 
 	cmdExec := cmd_exec.NewCmdExec()
-	parser = dir_parser.NewParser(cmdExec, appName, flagVals.Instance_flag, onWindows, flagVals.Verbose_flag)
+
+	// every listing and download goroutine shares one budget, as do other running downloads
+	if flagVals.LimitRate_flag > 0 || flagVals.MaxRps_flag > 0 {
+		limiter = rate_limiter.NewRateLimiter(cmdExec, flagVals.LimitRate_flag, flagVals.MaxRps_flag, rate_limiter.StateFile())
+		cmdExec = limiter
+	}
+	// nothing but the files may go to stdout with --stdout
+	parser = dir_parser.NewParser(cmdExec, appName, flagVals.Instance_flag, onWindows, flagVals.Verbose_flag && !flagVals.Stdout_flag)
+
+	// shared by every parser and downloader so a failover applies to the whole download
+	instances = instance_manager.NewInstanceManager(cmdExec, appName, flagVals.Instance_flag, !flagVals.NoFailover_flag)
+	parser.SetInstanceManager(instances)
 
 	// get list of paths to download
-	paths = ExpandGlobs(cmdExec, paths, flagVals.Instance_flag)
+	var locals map[string]string
+	paths, locals, err = ExpandGlobs(parser, paths, flagVals.Dotfiles_flag)
+	if err != nil {
+		errOut := os.Stdout
+		if flagVals.Stdout_flag {
+			errOut = os.Stderr
+		}
+		fmt.Fprintln(errOut, createMessage("\nError: "+err.Error(), "red+b", onWindows))
+		os.Exit(1)
+	}
+
+	// work out which paths are files, unless --file says they all are
+	if !flagVals.File_flag {
+		paths, err = ResolvePaths(parser, paths)
+		if err == nil {
+			err = CheckFilePaths(paths, flagVals)
+		}
+		if err != nil {
+			errOut := os.Stdout
+			if flagVals.Stdout_flag {
+				errOut = os.Stderr
+			}
+			fmt.Fprintln(errOut, createMessage("\nError: "+err.Error(), "red+b", onWindows))
+			os.Exit(1)
+		}
+	}
 
 	// get list of things to not download
-	filterList := filter.GetFilterList(flagVals.Omit_flag, flagVals.Verbose_flag)
+	info := os.Stdout
+	if flagVals.Stdout_flag {
+		info = os.Stderr
+	}
+	filterList := filter.GetFilterListTo(info, flagVals.Omit_flag, flagVals.Verbose_flag)
 
-	// get server path to download from and local path to download to
-	workingDir, err := os.Getwd()
-	check(err, "Called by: Getwd")
-	pathVals := GetDirectoryContext(workingDir, paths, flagVals.File_flag)
+	pathVals := GetResolvedContext(workingDir, paths)
+	if flagVals.File_flag {
+		pathVals = GetDirectoryContext(workingDir, paths, true)
+	}
+	PlaceGlobMatches(workingDir, pathVals, locals)
+	if !flagVals.Stdout_flag && flagVals.Snapshot_flag == "" {
+		if err := CheckDestinations(pathVals); err != nil {
+			fmt.Println(createMessage("\nError: "+err.Error()+". Download them separately, or start the globs at a common directory to keep them apart.", "red+b", onWindows))
+			os.Exit(1)
+		}
+	}
+
+	// a refresh downloads into the directory it was started in, leaving out what was left out before
+	if refreshMeta != nil {
+		pathVals[0].RootWorkingDirectoryLocal = filepath.FromSlash(workingDir + "/")
+		for _, val := range refreshMeta.Omit {
+			if !filter.CheckToFilter(val, filterList) {
+				filterList = append(filterList, val)
+			}
+		}
+	}
 
 	// ensure cf_trace is disabled, otherwise parsing breaks
 	if os.Getenv("CF_TRACE") == "true" {
@@ -117,22 +264,91 @@ func (c *DownloadPlugin) Run(cliConnection plugin.CliConnection, args []string)
 		return
 	}
 
+	// write the files to stdout instead of the working directory
+	if flagVals.Stdout_flag {
+		catFiles(cmdExec, args[2:], paths, filterList, flagVals, onWindows)
+		return
+	}
+
+	// record the instance start time so restarts during the download can be detected
+	guard = instance_manager.NewRestartGuard(cmdExec, appName, instances)
+	guard.Start()
+	stopGuard := make(chan int)
+	go restartWatcher(stopGuard, flagVals.FailOnRestart_flag, onWindows)
+
+	// one downloader for every path, so the --max-* limits hold for the download as a whole
+	dloader = downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, flagVals.Verbose_flag, onWindows)
+	dloader.SetInstanceManager(instances)
+	dloader.SetRestartGuard(guard)
+	dloader.SetSchedulePolicy(flagVals.Schedule_flag)
+	dloader.SetLimits(downloader.Limits{
+		MaxBytes:    flagVals.MaxBytes_flag,
+		MaxFiles:    flagVals.MaxFiles_flag,
+		MaxFailures: flagVals.MaxFailures_flag,
+	})
+	dloader.SetSync(flagVals.Sync_flag)
+	dloader.SetMirror(flagVals.Mirror_flag)
+	dloader.SetDryRun(flagVals.DryRun_flag)
+	dloader.SetMatch(flagVals.Match_flag)
+
+	// files go to the snapshot store instead of the working directory
+	var recorder snapshot.Recorder
+	store := snapshot.NewStore(flagVals.Snapshot_flag)
+	if flagVals.Snapshot_flag != "" && !flagVals.DryRun_flag {
+		recorder = snapshot.NewRecorder(store, appName, instances.Current())
+		dloader.SetSnapshot(recorder)
+	}
+
+	// identify the app, so the metadata can tell whether it was restaged before the next refresh
+	var appGuid, dropletGuid string
+	if !flagVals.File_flag && !flagVals.DryRun_flag && recorder == nil {
+		appGuid, dropletGuid, err = metadata.LookupApp(cmdExec, appName)
+		if err != nil && flagVals.Verbose_flag {
+			fmt.Println("[ Info: ", err, "]")
+		}
+	}
+	if refreshMeta != nil {
+		if changed := refreshMeta.Changed(appGuid, dropletGuid); changed != "" {
+			fmt.Println(createMessage("Warning: "+changed+" since it was downloaded on "+refreshMeta.Downloaded.Local().Format("2006-01-02 15:04")+", files written at runtime may have been lost.", "yellow", onWindows))
+		}
+	}
+
+	// refuse a repository we may not commit to before anything is written into it
+	var repos []git_repo.Repo
+	if flagVals.Git_flag && !flagVals.DryRun_flag {
+		repos = openRepos(pathVals, flagVals.GitAdopt_flag)
+	}
+
+	// walk the app first and ask before a download larger than --confirm-above
+	if flagVals.ConfirmAbove_flag > 0 && !flagVals.DryRun_flag {
+		confirmPlan(cmdExec, pathVals, filterList, flagVals, onWindows)
+	}
+
 	// download files at each input path
 	for _, v := range pathVals {
+		// leave the remaining paths alone once a limit was reached
+		if dloader.GetAbortReason() != "" {
+			break
+		}
+
+		// mirroring into the working directory itself would remove everything else in it
+		if flagVals.Mirror_flag && filepath.Clean(v.RootWorkingDirectoryLocal) == filepath.Clean(workingDir) && refreshMeta == nil {
+			fmt.Println("\nError: --mirror can not be used with the working directory", workingDir, "as the destination.")
+			os.Exit(1)
+		}
+
 		// prevent overwriting files
-		if Exists(v.RootWorkingDirectoryLocal) && flagVals.OverWrite_flag == false {
-			fmt.Println("\nError: destination path", v.RootWorkingDirectoryLocal, "already exists.\n\nDelete it or rerun the command with the '--overwrite' flag.")
+		if Exists(v.RootWorkingDirectoryLocal) && flagVals.OverWrite_flag == false && flagVals.Sync_flag == false && flagVals.DryRun_flag == false && recorder == nil {
+			fmt.Println("\nError: destination path", v.RootWorkingDirectoryLocal, "already exists.\n\nDelete it or rerun the command with the '--overwrite' or '--sync' flag.")
 			os.Exit(1)
 		}
 
 		// remove files to be overwritten
-		if flagVals.OverWrite_flag {
+		if flagVals.OverWrite_flag && flagVals.DryRun_flag == false && recorder == nil {
 			err := os.RemoveAll(v.RootWorkingDirectoryLocal)
 			check(err, "Cannot remove "+v.RootWorkingDirectoryLocal+" for overwrite.")
 		}
 
-		dloader = downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, flagVals.Verbose_flag, onWindows)
-
 		// stop consoleWriter
 		quit := make(chan int)
 
@@ -141,35 +357,79 @@ func (c *DownloadPlugin) Run(cliConnection plugin.CliConnection, args []string)
 			go consoleWriter(quit)
 		}
 
-		if flagVals.File_flag {
+		if v.IsFile {
 			// create directory for single file
-			err := os.MkdirAll(strings.TrimSuffix(v.RootWorkingDirectoryLocal, filepath.Base(v.RootWorkingDirectoryLocal)), 0755)
-			check(err, "Error D1: failed to create directory.")
+			if recorder == nil {
+				err := os.MkdirAll(strings.TrimSuffix(v.RootWorkingDirectoryLocal, filepath.Base(v.RootWorkingDirectoryLocal)), 0755)
+				check(err, "Error D1: failed to create directory.")
+			}
 
 			// start download of single file
 			wg.Add(1)
 			dloader.DownloadFile(v.StartingPathServer, v.RootWorkingDirectoryLocal)
 		} else {
-			// parse the input directory
-			files, dirs := parser.ExecParseDir(v.StartingPathServer)
-
 			// start download of directory
 			wg.Add(1)
-			dloader.Download(files, dirs, v.StartingPathServer, v.RootWorkingDirectoryLocal, filterList)
+			dloader.DownloadDir(v.StartingPathServer, v.RootWorkingDirectoryLocal, filterList)
 		}
 
 		// wait for download goRoutines
 		wg.Wait()
 
+		// refresh directories that were listed by an instance that has since gone away
+		if len(instances.GetSwitches()) > 0 && !v.IsFile && !flagVals.DryRun_flag && dloader.GetAbortReason() == "" {
+			dloader.Recheck(v.StartingPathServer, v.RootWorkingDirectoryLocal, filterList)
+		}
+
 		// stop console writer
 		if flagVals.Verbose_flag == false {
 			quit <- 0
 		}
 	}
+	failedDownloads = append(failedDownloads, dloader.GetFailedDownloads()...)
+	dloader.Close()
+
+	// check the instance one last time now that every file is written
+	stopGuard <- 0
+	guard.Check()
 
 	// return completion status to user
 	getFailedDownloads()
 	PrintCompletionInfo(start, onWindows)
+
+	if recorder != nil {
+		var serverPaths []string
+		for _, v := range pathVals {
+			serverPaths = append(serverPaths, v.StartingPathServer)
+		}
+		manifest, err := recorder.Finish(serverPaths, dloader.GetAbortReason() == "" && len(failedDownloads) == 0)
+		check(err, "Cannot save the snapshot in "+flagVals.Snapshot_flag)
+		printSnapshotInfo(manifest, recorder, flagVals.Snapshot_flag)
+
+		// bring the remote changes since the last snapshot into a working copy
+		if flagVals.MergeInto_flag != "" {
+			mergeInto(store, manifest, pathVals[0].StartingPathServer, flagVals.MergeInto_flag, onWindows)
+		}
+	}
+
+	if dloader.GetAbortReason() != "" || (guard.Restarted() && flagVals.FailOnRestart_flag) {
+		os.Exit(1)
+	}
+
+	// let the download be repeated with --refresh
+	if !flagVals.File_flag && !flagVals.DryRun_flag && recorder == nil {
+		writeMetadata(pathVals, filterList, flagVals, appGuid, dropletGuid)
+	}
+
+	// record the download as a commit in each destination
+	if repos != nil {
+		commitDownloads(repos, pathVals, appGuid, dropletGuid)
+	}
+
+	// keep the local copy up to date until interrupted
+	if flagVals.Watch_flag > 0 {
+		watch(cmdExec, pathVals, filterList, flagVals, onWindows)
+	}
 }
 
 /*
@@ -179,10 +439,135 @@ func (c *DownloadPlugin) Run(cliConnection plugin.CliConnection, args []string)
  */
 
 /*
-*	This function returns a list of files that failed to download.
+*	This function turns 'cf download --refresh [flags]' into the download recorded in 'meta'. The
+*	recorded download is repeated with --sync, flags given with --refresh are added to it.
+ */
+func RefreshArgs(meta metadata.Metadata, args []string) []string {
+	if len(args) > 2 && !strings.HasPrefix(args[2], "-") {
+		fmt.Println(createMessage("\nError: --refresh takes no app name or paths, they are read from "+metadata.FileName, "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	refreshed := []string{args[0], meta.App}
+	if path := strings.Trim(meta.Path, "/"); path != "" {
+		refreshed = append(refreshed, path)
+	}
+	refreshed = append(refreshed, "-i", meta.Instance, "--sync")
+
+	return append(refreshed, args[2:]...)
+}
+
+/*
+*	This function records every downloaded directory in its own metadata file.
+ */
+func writeMetadata(pathVals []pathVal, filterList []string, flagVals flagVal, appGuid, dropletGuid string) {
+	for _, v := range pathVals {
+		if v.IsFile || !Exists(v.RootWorkingDirectoryLocal) {
+			continue
+		}
+
+		err := metadata.Write(v.RootWorkingDirectoryLocal, metadata.Metadata{
+			App:        appName,
+			Guid:       appGuid,
+			Droplet:    dropletGuid,
+			Instance:   flagVals.Instance_flag,
+			Path:       v.StartingPathServer,
+			Omit:       filterList,
+			Transport:  metadata.Transport,
+			Downloaded: time.Now().UTC(),
+		})
+		if err != nil && flagVals.Verbose_flag {
+			fmt.Println("[ Info: cannot write", metadata.FileName, "to", v.RootWorkingDirectoryLocal, err, "]")
+		}
+	}
+}
+
+/*
+*	This function opens or creates the git repository of every destination directory.
+ */
+func openRepos(pathVals []pathVal, adopt bool) []git_repo.Repo {
+	var repos []git_repo.Repo
+	for _, v := range pathVals {
+		err := os.MkdirAll(v.RootWorkingDirectoryLocal, 0755)
+		check(err, "Error D1: failed to create directory.")
+
+		repo, err := git_repo.Open(v.RootWorkingDirectoryLocal, adopt)
+		check(err, "")
+		repos = append(repos, repo)
+	}
+
+	return repos
+}
+
+/*
+*	This function commits every destination directory, describing the app the files came from.
+ */
+func commitDownloads(repos []git_repo.Repo, pathVals []pathVal, appGuid, dropletGuid string) {
+	if appGuid == "" {
+		appGuid = "unknown"
+	}
+	if dropletGuid == "" {
+		dropletGuid = "unknown"
+	}
+
+	for i, repo := range repos {
+		message := fmt.Sprintf("cf download %s instance %s\n\nApp: %s (%s)\nInstance: %s\nDroplet: %s\nPath: %s\nDownloaded: %s\n",
+			appName, instances.Current(), appName, appGuid, instances.Current(), dropletGuid, pathVals[i].StartingPathServer, time.Now().UTC().Format(time.RFC3339))
+		if len(failedDownloads) > 0 {
+			message += fmt.Sprintf("Failed: %d files could not be downloaded\n", len(failedDownloads))
+		}
+
+		hash, err := repo.Commit(message)
+		check(err, "Cannot commit the download in "+pathVals[i].RootWorkingDirectoryLocal)
+
+		if hash == "" {
+			fmt.Println("No changes in", pathVals[i].RootWorkingDirectoryLocal, "since the last commit.")
+		} else if repo.Created() {
+			fmt.Println("Created a git repository in", pathVals[i].RootWorkingDirectoryLocal, "with commit", hash)
+		} else {
+			fmt.Println("Committed", hash, "to", pathVals[i].RootWorkingDirectoryLocal)
+		}
+	}
+}
+
+/*
+*	This function downloads the files at 'paths', relative to the app path 'readRoot', to the same
+*	relative paths below 'writeRoot'. As many files are fetched at a time as in a download.
+ */
+func fetchFiles(dloader downloader.Downloader, wg *sync.WaitGroup, readRoot, writeRoot string, paths []string, onWindows bool) {
+	queue := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < scheduler.DefaultWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for val := range queue {
+				writePath := filepath.Join(writeRoot, filepath.FromSlash(val))
+				if err := os.MkdirAll(filepath.Dir(writePath), 0755); err != nil {
+					fmt.Println(createMessage(" Error: cannot create the directory of "+writePath, "yellow", onWindows))
+					continue
+				}
+
+				wg.Add(1)
+				dloader.DownloadFile(readRoot+val, writePath)
+			}
+		}()
+	}
+
+	for _, val := range paths {
+		queue <- val
+	}
+	close(queue)
+	workers.Wait()
+}
+
+/*
+*	This function returns a list of files that failed to download. The downloader's failures are
+*	collected once every path has finished, the main parser's are added here.
  */
 func getFailedDownloads() {
-	failedDownloads = append(parser.GetFailedDownloads(), dloader.GetFailedDownloads()...)
+	failedDownloads = append(parser.GetFailedDownloads(), failedDownloads...)
 }
 
 /*
@@ -220,6 +605,7 @@ func GetDirectoryContext(workingDir string, paths []string, isFile bool) []pathV
 			addPathVals := pathVal{
 				RootWorkingDirectoryLocal: localPath + filepath.Base(v),
 				StartingPathServer:        startingPath + v,
+				IsFile:                    isFile,
 			}
 
 			// ensure trailing backslash is added to local root directory
@@ -235,6 +621,147 @@ func GetDirectoryContext(workingDir string, paths []string, isFile bool) []pathV
 	return pathVals
 }
 
+/*
+*	This function returns the pathVals of paths whose kind is known, as ResolvePaths() returns
+*	them: a path ending in a slash is a directory, any other path is a file.
+ */
+func GetResolvedContext(workingDir string, paths []string) []pathVal {
+	if len(paths) == 0 {
+		return GetDirectoryContext(workingDir, paths, false)
+	}
+
+	var pathVals []pathVal
+	for _, v := range paths {
+		pathVals = append(pathVals, GetDirectoryContext(workingDir, []string{v}, !strings.HasSuffix(v, "/"))...)
+	}
+
+	return pathVals
+}
+
+/*
+*	This function moves the destination of every glob match to the path ExpandGlobs() returned
+*	for it, below the working directory, so matches with the same name in different directories
+*	do not land on the same destination.
+ */
+func PlaceGlobMatches(workingDir string, pathVals []pathVal, locals map[string]string) {
+	for i, v := range pathVals {
+		local, ok := locals[strings.Trim(v.StartingPathServer, "/")]
+		if !ok {
+			continue
+		}
+
+		local = workingDir + "/" + local
+		if !v.IsFile {
+			local += "/"
+		}
+		pathVals[i].RootWorkingDirectoryLocal = filepath.FromSlash(local)
+	}
+}
+
+/*
+*	This function returns an error if two paths would be downloaded to the same local path, or
+*	one into the local directory of another at a place that does not match the app. Globs in
+*	several PATH arguments can do that, e.g. 'app/lib/*.js' and 'app/test/*.js' both place an
+*	a.js at ./a.js.
+ */
+func CheckDestinations(pathVals []pathVal) error {
+	byLocal := make(map[string]pathVal)
+	for _, v := range pathVals {
+		local := strings.TrimSuffix(filepath.ToSlash(v.RootWorkingDirectoryLocal), "/")
+		if other, ok := byLocal[local]; ok && other.StartingPathServer != v.StartingPathServer {
+			return errors.New("'" + other.StartingPathServer + "' and '" + v.StartingPathServer + "' would both be downloaded to '" + filepath.FromSlash(local) + "'")
+		}
+		byLocal[local] = v
+	}
+
+	for _, v := range pathVals {
+		local := strings.TrimSuffix(filepath.ToSlash(v.RootWorkingDirectoryLocal), "/")
+		for dir, prev := path.Dir(local), local; dir != prev; dir, prev = path.Dir(dir), dir {
+			other, ok := byLocal[dir]
+			if !ok || other.IsFile {
+				continue
+			}
+
+			want := strings.TrimSuffix(other.StartingPathServer, "/") + strings.TrimPrefix(local, dir)
+			if want != strings.TrimSuffix(v.StartingPathServer, "/") {
+				return errors.New("'" + v.StartingPathServer + "' would be downloaded into '" + filepath.FromSlash(dir+"/") + "', the local copy of '" + other.StartingPathServer + "'")
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+*	This function looks up every PATH argument in the listing of its parent directory to find
+*	out whether it is a file or a directory. Directories are returned with a trailing slash, files
+*	without. A path that already ends in a slash is a directory and is not looked up, a path whose
+*	parent cannot be listed is taken to be a directory, and a path missing from the listing of its
+*	parent is an error.
+ */
+func ResolvePaths(parser dir_parser.Parser, paths []string) ([]string, error) {
+	listings := make(map[string][]dir_parser.Entry)
+	listed := make(map[string]bool)
+
+	var resolved []string
+	for _, v := range paths {
+		readPath := path.Clean("/" + filepath.ToSlash(v))
+		if strings.HasSuffix(v, "/") || readPath == "/" {
+			resolved = append(resolved, v)
+			continue
+		}
+
+		parent := path.Dir(readPath)
+		if parent != "/" {
+			parent += "/"
+		}
+		if _, ok := listings[parent]; !ok {
+			listings[parent], listed[parent] = parser.ListDir(parent)
+		}
+		if !listed[parent] {
+			resolved = append(resolved, v+"/")
+			continue
+		}
+
+		found := false
+		for _, val := range listings[parent] {
+			if strings.TrimSuffix(val.Name, "/") != path.Base(readPath) {
+				continue
+			}
+
+			found = true
+			if val.Kind == dir_parser.Dir {
+				resolved = append(resolved, v+"/")
+			} else {
+				resolved = append(resolved, v)
+			}
+			break
+		}
+		if !found {
+			return nil, errors.New("'" + v + "' was not found in " + parent)
+		}
+	}
+
+	return resolved, nil
+}
+
+/*
+*	This function refuses the flags that need directories when a resolved path is a file.
+ */
+func CheckFilePaths(paths []string, flagVals flagVal) error {
+	if !flagVals.Mirror_flag && !flagVals.Git_flag && flagVals.Watch_flag == 0 && flagVals.MergeInto_flag == "" && flagVals.Match_flag.Empty() {
+		return nil
+	}
+
+	for _, v := range paths {
+		if !strings.HasSuffix(v, "/") {
+			return errors.New("'" + v + "' is a file, --mirror, --git, --watch, --merge-into, --name and --size can only be used with directories.")
+		}
+	}
+
+	return nil
+}
+
 /*
 *	This function sets the flag values and determines download paths based on
 *	input arguments.
@@ -249,6 +776,30 @@ func ParseArgs(args []string) (flagVal, []string) {
 	instancep := f1.Int("i", 0, "-i [instanceNum]")
 	verbosep := f1.Bool("verbose", false, "--verbose")
 	filep := f1.Bool("file", false, "--file")
+	noFailoverp := f1.Bool("no-failover", false, "--no-failover")
+	failOnRestartp := f1.Bool("fail-on-restart", false, "--fail-on-restart")
+	largestFirstp := f1.Bool("largest-first", false, "--largest-first")
+	smallestFirstp := f1.Bool("smallest-first", false, "--smallest-first")
+	limitRatep := f1.String("limit-rate", "", "--limit-rate bytesPerSecond")
+	maxRpsp := f1.Float64("max-rps", 0, "--max-rps requestsPerSecond")
+	maxBytesp := f1.String("max-bytes", "", "--max-bytes size")
+	maxFilesp := f1.Int("max-files", 0, "--max-files count")
+	maxFailuresp := f1.Int("max-failures", 0, "--max-failures count")
+	syncp := f1.Bool("sync", false, "--sync")
+	mirrorp := f1.Bool("mirror", false, "--mirror")
+	dryRunp := f1.Bool("dry-run", false, "--dry-run")
+	confirmAbovep := f1.String("confirm-above", "", "--confirm-above size")
+	yesp := f1.Bool("yes", false, "--yes")
+	watchp := f1.String("watch", "", "--watch interval")
+	snapshotp := f1.String("snapshot", "", "--snapshot dir")
+	gitp := f1.Bool("git", false, "--git")
+	gitAdoptp := f1.Bool("git-adopt", false, "--git-adopt")
+	mergeIntop := f1.String("merge-into", "", "--merge-into dir")
+	namep := f1.String("name", "", "--name pattern")
+	sizep := f1.String("size", "", "--size [+|-]size")
+	stdoutp := f1.Bool("stdout", false, "--stdout")
+	tarp := f1.Bool("tar", false, "--tar")
+	dotfilesp := f1.Bool("dotfiles", false, "--dotfiles")
 
 	// get paths
 	var paths []string
@@ -272,22 +823,199 @@ func ParseArgs(args []string) (flagVal, []string) {
 
 	// check for parsing errors, display usage
 	if err != nil {
-		fmt.Println("\nError: ", err, "\n")
+		fmt.Println("\nError: ", err)
+		fmt.Println("")
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *largestFirstp && *smallestFirstp {
+		fmt.Println(createMessage("\nError: --largest-first and --smallest-first cannot be used together.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	limitRate, err := rate_limiter.ParseRate(*limitRatep)
+	if err != nil || *maxRpsp < 0 {
+		if err == nil {
+			err = errors.New("--max-rps must not be negative")
+		}
+		fmt.Println("\nError: ", err)
+		fmt.Println("")
+		printHelp()
+		os.Exit(1)
+	}
+
+	// --git mirrors so the commits record files removed in the app. --git-adopt commits to a
+	// repository that may hold local files the app never had, so removing them must be asked for.
+	gitEnabled := *gitp || *gitAdoptp
+
+	if *gitAdoptp && !*mirrorp {
+		fmt.Println(createMessage("\nError: --git-adopt needs --mirror, which removes local files that are not in the app.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *overWritep && (*syncp || *mirrorp || gitEnabled) {
+		fmt.Println(createMessage("\nError: --overwrite cannot be used with --sync, --mirror or --git.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *filep && *mirrorp {
+		fmt.Println(createMessage("\nError: --mirror cannot be used with --file.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *snapshotp != "" && (*syncp || *mirrorp || *watchp != "") {
+		fmt.Println(createMessage("\nError: --snapshot cannot be used with --sync, --mirror or --watch.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if gitEnabled && (*filep || *snapshotp != "" || *watchp != "") {
+		fmt.Println(createMessage("\nError: --git cannot be used with --file, --snapshot or --watch.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *mergeIntop != "" && (*snapshotp == "" || *filep || *dryRunp || len(paths) > 1) {
+		fmt.Println(createMessage("\nError: --merge-into needs --snapshot and a single PATH, and cannot be used with --file or --dry-run.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *watchp != "" && (*filep || *dryRunp) {
+		fmt.Println(createMessage("\nError: --watch cannot be used with --file or --dry-run.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *stdoutp && (len(paths) == 0 || *overWritep || *syncp || *mirrorp || *dryRunp || *confirmAbovep != "" || *watchp != "" || *snapshotp != "" || gitEnabled || *mergeIntop != "") {
+		fmt.Println(createMessage("\nError: --stdout needs a PATH and cannot be used with --overwrite, --sync, --mirror, --dry-run, --confirm-above, --watch, --snapshot, --git or --merge-into.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	if *tarp && !*stdoutp {
+		fmt.Println(createMessage("\nError: --tar can only be used with --stdout.", "red+b", IsWindows()))
+		printHelp()
+		os.Exit(1)
+	}
+
+	match, err := filter.ParsePredicate(*namep, *sizep, "")
+	if err == nil && !match.Empty() && (*filep || *watchp != "") {
+		err = errors.New("--name and --size cannot be used with --file or --watch")
+	}
+	if err != nil {
+		fmt.Println("\nError: ", err)
+		fmt.Println("")
+		printHelp()
+		os.Exit(1)
+	}
+
+	maxBytes, err := ParseSizeFlag(*maxBytesp)
+	var confirmAbove int64
+	if err == nil {
+		confirmAbove, err = ParseSizeFlag(*confirmAbovep)
+	}
+	var watchInterval time.Duration
+	if err == nil {
+		watchInterval, err = ParseInterval(*watchp)
+	}
+	if err == nil && (*maxFilesp < 0 || *maxFailuresp < 0) {
+		err = errors.New("--max-files and --max-failures must not be negative")
+	}
+	if err != nil {
+		fmt.Println("\nError: ", err)
+		fmt.Println("")
 		printHelp()
 		os.Exit(1)
 	}
 
+	schedule := scheduler.Balanced
+	if *largestFirstp {
+		schedule = scheduler.LargestFirst
+	} else if *smallestFirstp {
+		schedule = scheduler.SmallestFirst
+	}
+
 	flagVals := flagVal{
-		Omit_flag:      string(*omitp),
-		OverWrite_flag: bool(*overWritep),
-		Instance_flag:  strconv.Itoa(*instancep),
-		Verbose_flag:   *verbosep,
-		File_flag:      *filep,
+		Omit_flag:          string(*omitp),
+		OverWrite_flag:     bool(*overWritep),
+		Instance_flag:      strconv.Itoa(*instancep),
+		Verbose_flag:       *verbosep,
+		File_flag:          *filep,
+		NoFailover_flag:    *noFailoverp,
+		FailOnRestart_flag: *failOnRestartp,
+		Schedule_flag:      schedule,
+		LimitRate_flag:     limitRate,
+		MaxRps_flag:        *maxRpsp,
+		MaxBytes_flag:      maxBytes,
+		MaxFiles_flag:      *maxFilesp,
+		MaxFailures_flag:   *maxFailuresp,
+		Sync_flag:          *syncp || *mirrorp || watchInterval > 0 || gitEnabled,
+		Mirror_flag:        *mirrorp || *gitp,
+		DryRun_flag:        *dryRunp,
+		ConfirmAbove_flag:  confirmAbove,
+		Yes_flag:           *yesp,
+		Watch_flag:         watchInterval,
+		Snapshot_flag:      *snapshotp,
+		Git_flag:           gitEnabled,
+		GitAdopt_flag:      *gitAdoptp,
+		MergeInto_flag:     *mergeIntop,
+		Match_flag:         match,
+		Stdout_flag:        *stdoutp,
+		Tar_flag:           *tarp,
+		Dotfiles_flag:      *dotfilesp,
 	}
 
 	return flagVals, paths
 }
 
+/*
+*	This function reads a size such as 500M or 2G, given the way cf files lists sizes. A plain
+*	number is a count of bytes and an empty value is no limit.
+ */
+func ParseSizeFlag(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if str == "" {
+		return 0, nil
+	}
+
+	if bytes, err := strconv.ParseInt(str, 10, 64); err == nil && bytes >= 0 {
+		return bytes, nil
+	}
+
+	if bytes := dir_parser.ParseSize(strings.TrimSuffix(str, "B")); bytes >= 0 && !strings.HasSuffix(str, "BB") {
+		return bytes, nil
+	}
+
+	return 0, errors.New("invalid size '" + str + "', expected a size such as 500M or 2G")
+}
+
+/*
+*	This function reads a --watch interval such as 30s or 5m. A plain number is a count of seconds
+*	and an empty value is no watching.
+ */
+func ParseInterval(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(str)
+	if seconds, convErr := strconv.Atoi(str); convErr == nil {
+		interval, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || interval < time.Second {
+		return 0, errors.New("invalid interval '" + str + "', expected at least 1s such as 30s or 5m")
+	}
+
+	return interval, nil
+}
+
 /*
 *	This function prints the current number of files downloaded. It is polled every 350 milleseconds
 * 	and disabled if the verbose flag is set to true.
@@ -301,21 +1029,69 @@ func consoleWriter(quit chan int) {
 			fmt.Println("\rFiles downloaded:", filesDownloaded, "  ")
 			return
 		default:
+			rates := rateInfo()
 			switch count = (count + 1) % 4; count {
 			case 0:
-				fmt.Printf("\rFiles downloaded: %d \\ ", filesDownloaded)
+				fmt.Printf("\rFiles downloaded: %d%s \\ ", filesDownloaded, rates)
 			case 1:
-				fmt.Printf("\rFiles downloaded: %d | ", filesDownloaded)
+				fmt.Printf("\rFiles downloaded: %d%s | ", filesDownloaded, rates)
 			case 2:
-				fmt.Printf("\rFiles downloaded: %d / ", filesDownloaded)
+				fmt.Printf("\rFiles downloaded: %d%s / ", filesDownloaded, rates)
 			case 3:
-				fmt.Printf("\rFiles downloaded: %d --", filesDownloaded)
+				fmt.Printf("\rFiles downloaded: %d%s --", filesDownloaded, rates)
 			}
 			time.Sleep(350 * time.Millisecond)
 		}
 	}
 }
 
+/*
+*	This function checks the instance for restarts every restartCheckInterval until told to quit.
+*	If failOnRestart is set the download is stopped as soon as a restart is seen.
+ */
+func restartWatcher(quit chan int, failOnRestart bool, onWindows bool) {
+	ticker := time.NewTicker(restartCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			if guard.Check() && failOnRestart {
+				fmt.Println("")
+				printRestartInfo(onWindows)
+				fmt.Println(createMessage("\nError: download stopped because the instance was restarted.", "red+b", onWindows))
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+/*
+*	This function returns the effective transfer and request rates for the progress display when
+*	a rate limit is set, e.g. " [120.5K/s of 200.0K/s, 4.2 req/s of 5]".
+ */
+func rateInfo() string {
+	if limiter == nil {
+		return ""
+	}
+
+	bytesPerSec, requestsPerSec := limiter.GetRates()
+	limitRate, maxRps := limiter.GetLimits()
+
+	info := " [" + rate_limiter.FormatRate(bytesPerSec)
+	if limitRate > 0 {
+		info += " of " + rate_limiter.FormatRate(float64(limitRate))
+	}
+	info += ", " + strconv.FormatFloat(requestsPerSec, 'f', 1, 64) + " req/s"
+	if maxRps > 0 {
+		info += " of " + strconv.FormatFloat(maxRps, 'f', -1, 64)
+	}
+
+	return info + "]"
+}
+
 /*
 *	This function prints all the info you see at program finish.
  */
@@ -330,14 +1106,34 @@ func PrintCompletionInfo(start time.Time, onWindows bool) {
 	PrintSlice(failedDownloads)
 
 	if len(failedDownloads) > 100 {
-		fmt.Println("\nYou had over 100 failed downloads, we highly recommend you omit the failed file's open parent directories using the omit flag.\n")
+		fmt.Println("\nYou had over 100 failed downloads, we highly recommend you omit the failed file's open parent directories using the omit flag.")
+		fmt.Println("")
 	}
 
+	printInstanceInfo()
+	printRestartInfo(onWindows)
+
 	// display runtime
 	elapsed := time.Since(start)
 	elapsedString := strings.Split(elapsed.String(), ".")[0]
 	elapsedString = strings.TrimSuffix(elapsedString, ".") + "s"
 	fmt.Println("\nDownload time: " + elapsedString)
+	printSyncInfo()
+	printMirrorInfo()
+
+	// a limit stopped the download, so it is incomplete
+	if dloader != nil && dloader.GetAbortReason() != "" {
+		fmt.Println(createMessage("\nDownload stopped: "+dloader.GetAbortReason()+".", "red+b", onWindows))
+		fmt.Printf("%d files (%d bytes) were downloaded, %d queued files were skipped.\n",
+			dloader.GetFilesDownloadedCount(), dloader.GetBytesDownloaded(), dloader.GetSkippedCount())
+		return
+	}
+
+	if dloader != nil && dloader.IsDryRun() {
+		printPlan(dloader.GetPlan())
+		fmt.Println("Dry run, nothing was downloaded or removed.")
+		return
+	}
 
 	msg := ansi.Color(appName+" Successfully Downloaded!", "green+b")
 	if onWindows == true {
@@ -346,6 +1142,231 @@ func PrintCompletionInfo(start time.Time, onWindows bool) {
 	fmt.Println(msg)
 }
 
+/*
+*	This function prints how many files a --sync download fetched and how many it left alone.
+ */
+func printSyncInfo() {
+	if dloader == nil {
+		return
+	}
+
+	added, updated, unchanged := dloader.GetSyncCounts()
+	if dloader.IsDryRun() {
+		fmt.Printf("Would download: %d new, %d updated, %d unchanged files skipped.\n", added, updated, unchanged)
+	} else if added+updated+unchanged > 0 {
+		fmt.Printf("Synced: %d new, %d updated, %d unchanged files skipped.\n", added, updated, unchanged)
+	}
+}
+
+/*
+*	This function lists the local files and directories --mirror removed, or would remove.
+ */
+func printMirrorInfo() {
+	if dloader == nil {
+		return
+	}
+
+	removed := dloader.GetRemoved()
+	if len(removed) == 0 {
+		return
+	}
+
+	if dloader.IsDryRun() {
+		fmt.Println(len(removed), "local files or directories would be removed:")
+	} else {
+		fmt.Println(len(removed), "local files or directories were removed:")
+	}
+	PrintSlice(removed)
+}
+
+/*
+*	This function re-lists every path each --watch interval, fetches the files that appeared or
+*	changed in size and prints them as a change feed. It returns when interrupted with Ctrl-C.
+ */
+func watch(cmdExec cmd_exec.CmdExec, pathVals []pathVal, filterList []string, flagVals flagVal, onWindows bool) {
+	var watchers []watcher.Watcher
+	for _, v := range pathVals {
+		watchers = append(watchers, watcher.NewWatcher(cmdExec, dloader, instances, appName, v.StartingPathServer, v.RootWorkingDirectoryLocal, filterList, flagVals.Mirror_flag, onWindows, flagVals.Verbose_flag))
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(flagVals.Watch_flag)
+	defer ticker.Stop()
+
+	fmt.Printf("\nWatching for changes every %s, press Ctrl-C to stop.\n", flagVals.Watch_flag)
+	counts := make(map[watcher.Kind]int)
+	for {
+		select {
+		case <-interrupt:
+			fmt.Printf("\nStopped watching: %d appeared, %d grew, %d shrank, %d vanished.\n",
+				counts[watcher.Appeared], counts[watcher.Grew], counts[watcher.Shrank], counts[watcher.Vanished])
+			return
+		case <-ticker.C:
+			for i, w := range watchers {
+				for _, val := range w.Check() {
+					counts[val.Kind]++
+					printEvent(pathVals[i].StartingPathServer, val, onWindows)
+				}
+			}
+		}
+	}
+}
+
+// prints one line of the change feed, e.g. "15:04:05 grew     /app/logs/app.log (1.1K -> 1.3K)"
+func printEvent(readRoot string, event watcher.Event, onWindows bool) {
+	line := fmt.Sprintf("%s %-8s %s", event.At.Format("15:04:05"), event.Kind, readRoot+event.Path)
+
+	switch event.Kind {
+	case watcher.Appeared:
+		fmt.Println(createMessage(line+" ("+formatDiffSize(event.Size)+")", "green", onWindows))
+	case watcher.Vanished:
+		fmt.Println(createMessage(line, "red", onWindows))
+	default:
+		fmt.Println(createMessage(line+" ("+formatDiffSize(event.OldSize)+" -> "+formatDiffSize(event.Size)+")", "yellow", onWindows))
+	}
+}
+
+/*
+*	This function walks every path without downloading, like --dry-run, and asks the user to
+*	confirm if the files to download add up to more than --confirm-above. --yes answers for them.
+ */
+func confirmPlan(cmdExec cmd_exec.CmdExec, pathVals []pathVal, filterList []string, flagVals flagVal, onWindows bool) {
+	planner := downloader.NewDownloader(cmdExec, &wg, appName, flagVals.Instance_flag, flagVals.Verbose_flag, onWindows)
+	planner.SetInstanceManager(instances)
+	planner.SetSync(flagVals.Sync_flag)
+	planner.SetDryRun(true)
+	planner.SetMatch(flagVals.Match_flag)
+
+	for _, v := range pathVals {
+		wg.Add(1)
+		if v.IsFile {
+			planner.DownloadFile(v.StartingPathServer, v.RootWorkingDirectoryLocal)
+		} else {
+			planner.DownloadDir(v.StartingPathServer, v.RootWorkingDirectoryLocal, filterList)
+		}
+	}
+	wg.Wait()
+	planner.Close()
+
+	plans := planner.GetPlan()
+	var bytes int64
+	for _, val := range plans {
+		bytes += val.Bytes
+	}
+	if bytes <= flagVals.ConfirmAbove_flag {
+		return
+	}
+
+	printPlan(plans)
+	if flagVals.Yes_flag {
+		return
+	}
+
+	fmt.Printf("\nThe download is larger than %s. Continue? [y/N] ", dir_parser.FormatSize(flagVals.ConfirmAbove_flag))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		fmt.Println(createMessage("\nDownload cancelled.", "red+b", onWindows))
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function prints what a dry run found: the files and directories below each path, their
+*	listed size, what the omit list excluded and an estimate of how long the download takes.
+ */
+func printPlan(plans []downloader.Plan) {
+	if len(plans) == 0 {
+		return
+	}
+
+	var total downloader.Plan
+	var omitted []string
+	fmt.Println("\nDownload plan:")
+	for _, val := range plans {
+		fmt.Printf("  %s: %d files in %d directories, %s\n", val.ReadPath, val.Files, val.Dirs, dir_parser.FormatSize(val.Bytes))
+		total.Files += val.Files
+		total.Dirs += val.Dirs
+		total.Bytes += val.Bytes
+		total.Unknown += val.Unknown
+		total.Listings += val.Listings
+		total.Latency += val.Latency
+		omitted = append(omitted, val.Omitted...)
+	}
+
+	fmt.Printf("Total: %d files in %d directories, %s", total.Files, total.Dirs, dir_parser.FormatSize(total.Bytes))
+	if total.Unknown > 0 {
+		fmt.Printf(" (%d files of unknown size)", total.Unknown)
+	}
+	fmt.Println("")
+
+	if len(omitted) > 0 {
+		fmt.Println("Omitted by the omit list:")
+		PrintSlice(omitted)
+	}
+
+	limitRate, maxRps := int64(0), float64(0)
+	if limiter != nil {
+		limitRate, maxRps = limiter.GetLimits()
+	}
+	if estimate := downloader.EstimateTime(plans, scheduler.DefaultWorkers, limitRate, maxRps); estimate > 0 {
+		average := total.Latency / time.Duration(total.Listings)
+		fmt.Printf("Estimated time: %s (%d listings took %s each on average)\n", estimate.Round(time.Second), total.Listings, average.Round(time.Millisecond))
+	}
+}
+
+/*
+*	This function reports any instance failover and which instance served which part of the tree.
+ */
+func printInstanceInfo() {
+	if instances == nil {
+		return
+	}
+
+	switches := instances.GetSwitches()
+	if len(switches) == 0 {
+		return
+	}
+
+	fmt.Println("")
+	for _, val := range switches {
+		fmt.Printf("Instance %s stopped responding at %s, switched to instance %s.\n", val.From, val.At.Format("15:04:05"), val.To)
+	}
+
+	fmt.Println("\nDirectories were listed by the following instances:")
+	for _, val := range instance_manager.SummarizeServed(instances.GetServed()) {
+		fmt.Printf("  instance %s: %s\n", val.Instance, val.ReadPath)
+	}
+}
+
+/*
+*	This function warns about instance restarts seen during the download and lists the files that
+*	were fetched after the restart, since they may not match the rest of the download.
+ */
+func printRestartInfo(onWindows bool) {
+	if guard == nil || !guard.Restarted() {
+		return
+	}
+
+	fmt.Println("")
+	for _, val := range guard.GetRestarts() {
+		msg := "WARNING: instance " + val.Instance + " was restarted during the download (since " + val.Before + ", now " + val.After + ")."
+		fmt.Println(createMessage(msg, "red+b", onWindows))
+	}
+
+	files := guard.GetFilesAfterRestart()
+	if len(files) == 0 {
+		fmt.Println("No files were fetched after the restart.")
+		return
+	}
+
+	fmt.Println(len(files), "files were fetched after the restart and may come from a different container:")
+	PrintSlice(files)
+}
+
 /*
 *	This function checks for errors and prints error messages.
  */
@@ -392,37 +1413,36 @@ func Exists(path string) bool {
 }
 
 /*
-*	This function expands given input globs into matching paths on the server.
+*	This function expands the globs among the PATH arguments into the matching app paths, walking
+*	the app concurrently. Any segment may hold wildcards, '**' matches any number of directories
+*	and {a,b} either alternative. Dot files only match with 'dotfiles' or a pattern starting with
+*	a dot. Matching directories end in a slash. A pattern that matches nothing is an error. The
+*	map holds where each match is downloaded to: its path below the directories at the start of
+*	the glob that hold no wildcards, e.g. lib/util.js for app/{lib,test}/util.js.
  */
-func ExpandGlobs(cmdExec cmd_exec.CmdExec, paths []string, instance string) []string {
+func ExpandGlobs(parser dir_parser.Parser, paths []string, dotfiles bool) ([]string, map[string]string, error) {
+	expander := remote_glob.NewExpander(parser, walker.DefaultWorkers, dotfiles)
+
 	var newPaths []string
-	// iterate over each input path
+	locals := make(map[string]string)
 	for _, v := range paths {
-		// check if path is a glob
-		if strings.ContainsAny(v, "*?[]") {
-			dir := filepath.Dir(v)
-			out, _ := cmdExec.GetFile(appName, dir, instance)
-			// split the body line by line
-			body := strings.Split(string(out), "\n")
-			// the first 3 lines contain execution info from cf files and the last 2 are blank
-			body = body[3 : len(body)-2]
-
-			//iterate over glob's directory
-			for _, w := range body {
-				cur := strings.SplitN(w, " ", 2)[0]
-				match, err := filepath.Match(filepath.Base(v), strings.TrimSuffix(cur, "/"))
-				check(err, "")
-				// append path to return list if glob pattern matches
-				if match && !strings.HasPrefix(cur, ".") {
-					newPaths = append(newPaths, filepath.Dir(v)+"/"+cur)
-				}
-			}
-		} else {
-			//not a glob, append original path to return list
+		if !remote_glob.IsPattern(v) {
 			newPaths = append(newPaths, v)
+			continue
+		}
+
+		matches, err := expander.Expand(filepath.ToSlash(v))
+		if err != nil {
+			return nil, nil, err
+		}
+		prefix := remote_glob.Prefix(filepath.ToSlash(v))
+		for _, val := range matches {
+			locals[strings.TrimSuffix(val, "/")] = strings.TrimSuffix(strings.TrimPrefix(val, prefix), "/")
 		}
+		newPaths = append(newPaths, matches...)
 	}
-	return newPaths
+
+	return newPaths, locals, nil
 }
 
 /*
@@ -441,7 +1461,14 @@ func createMessage(message, color string, onWindows bool) string {
 *	This function prints the help information for the cf download command.
  */
 func printHelp() {
-	cmd := exec.Command("cf", "help", "download")
+	printCommandHelp("download")
+}
+
+/*
+*	This function prints the help information of one of the plugin's commands.
+ */
+func printCommandHelp(command string) {
+	cmd := exec.Command("cf", "help", command)
 	output, _ := cmd.CombinedOutput()
 	fmt.Printf("%s", output)
 }
@@ -475,13 +1502,177 @@ func (c *DownloadPlugin) GetMetadata() plugin.PluginMetadata {
 
 				// UsageDetails is optional, it is used to show help of usage of each command
 				UsageDetails: plugin.Usage{
-					Usage: "cf download APP_NAME [PATH...] [--overwrite] [--file] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Usage: "cf download APP_NAME [PATH...] [--overwrite] [--file] [--verbose] [--omit ommited_paths] [-i instance_num] [--no-failover] [--fail-on-restart] [--largest-first | --smallest-first] [--limit-rate bytes_per_sec] [--max-rps requests_per_sec] [--max-bytes size] [--max-files count] [--max-failures count] [--sync] [--mirror] [--dry-run] [--confirm-above size] [--yes] [--watch interval] [--snapshot dir] [--git | --git-adopt] [--merge-into dir] [--name pattern] [--size [+|-]size] [--stdout [--tar]] [--dotfiles]\n   cf download --refresh [flags]",
 					Options: map[string]string{
 						"-overwrite":             "Overwrite existing files",
-						"-file":                  "Specify a file",
+						"-file":                  "Treat every PATH as a file instead of looking up whether it is one",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+						"-no-failover":           "Do not switch to another instance when the selected one stops",
+						"-fail-on-restart":       "Fail instead of warn if the instance restarts during the download",
+						"-largest-first":         "Download the largest files first",
+						"-smallest-first":        "Download the smallest files first",
+						"-limit-rate":            "Limit the transfer rate, e.g. 500K or 2M bytes per second",
+						"-max-rps":               "Limit the number of cf requests per second",
+						"-max-bytes":             "Stop once the download would exceed this size, e.g. 500M or 2G",
+						"-max-files":             "Stop once this many files were downloaded",
+						"-max-failures":          "Stop once more than this many files or directories failed",
+						"-sync":                  "Only download files that are new or changed since the last download; sizes are compared as cf files rounds them, so an edit that keeps the rounded size is missed",
+						"-mirror":                "Sync, then remove local files that no longer exist in the app",
+						"-dry-run":               "Show the download plan, with sizes and an estimated time, without changing anything",
+						"-confirm-above":         "Ask before downloading more than this size, e.g. 1G",
+						"-yes":                   "Do not ask, download even if larger than --confirm-above",
+						"-watch":                 "Keep fetching changed files every interval, e.g. 30s, until Ctrl-C; changes are detected by rounded size like --sync",
+						"-refresh":               "Repeat the download recorded in the current directory, fetching only what changed as --sync does",
+						"-git":                   "Commit the download to a git repository in the destination, created on the first run; implies --mirror and shares the rounded-size limit of --sync",
+						"-git-adopt":             "Like --git, but also commit to a git repository cf download did not create; needs --mirror",
+						"-merge-into":            "Merge the changes since the last --snapshot into a local working copy",
+						"-snapshot":              "Save the download as a snapshot in a deduplicating store instead of the working directory",
+						"-name":                  "Only download files whose name matches this pattern, e.g. '*.log'",
+						"-size":                  "Only download files above (+) or below (-) this size, e.g. +10M",
+						"-stdout":                "Write the file to stdout instead of to disk, several files as a tar stream",
+						"-tar":                   "Write a tar stream to stdout even for a single file",
+						"-dotfiles":              "Let wildcards in PATH match names starting with a dot",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-diff",
+				HelpText: "Compare a running app's files with a local directory",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-diff APP_NAME LOCAL_DIR [PATH] [--patch] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-patch":                 "Show the changes to modified text files as unified diffs",
 						"-verbose":               "Verbose output",
 						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
-						"i": "Instance",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-compare",
+				HelpText: "Compare the files of two app instances, or of two apps",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-compare APP_NAME[:INSTANCE] APP_NAME[:INSTANCE] [PATH] [--patch] [--json] [--verbose] [--omit ommited_paths]",
+					Options: map[string]string{
+						"-patch":                 "Fetch the modified text files from both sides and show unified diffs",
+						"-json":                  "Write the result as JSON",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-drift",
+				HelpText: "List the files an app instance created, deleted or changed at runtime, compared with its droplet",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-drift APP_NAME [PATH] [--droplet droplet.tgz] [--download dir] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-droplet":               "Compare with this droplet tarball instead of downloading the current droplet",
+						"-download":              "Download the created and changed files to this directory",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-ls",
+				HelpText: "List the files of a running app with their sizes, without downloading them",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-ls APP_NAME [PATH...] [--depth levels] [--json | --csv] [--dotfiles] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-depth":                 "Only list this many levels below each path",
+						"-json":                  "Write the listing as JSON",
+						"-csv":                   "Write the listing as CSV, one row per file or directory",
+						"-dotfiles":              "Let wildcards in PATH match names starting with a dot",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-du",
+				HelpText: "Show the heaviest directories of a running app and recommend what to omit",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-du APP_NAME [PATH] [--top count] [--threshold size] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-top":                   "Show this many of the heaviest directories, 10 by default",
+						"-threshold":             "Recommend omitting dependency directories from this size, 10M by default",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-find",
+				HelpText: "Find files in a running app by name, size and type",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-find APP_NAME [PATH] [--name pattern] [--size [+|-]size] [--type f|d] [--download] [--overwrite] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-name":                  "Match the file or directory name against this pattern, e.g. '*.log'",
+						"-size":                  "Match files above (+), below (-) or at this listed size, e.g. +10M",
+						"-type":                  "Match only files (f) or directories (d)",
+						"-download":              "Download the matching files to where cf download would put them",
+						"-overwrite":             "Download into an existing destination, replacing the matching files",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-grep",
+				HelpText: "Search the files of a running app for a POSIX extended regular expression, like grep -rn",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-grep APP_NAME [-e] PATTERN [PATH] [--ignore-case] [--context lines] [--name pattern] [--no-ssh] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"e":                      "Read the next argument as PATTERN, for a PATTERN starting with a dash",
+						"-ignore-case":           "Match PATTERN regardless of case",
+						"-context":               "Print this many lines before and after every match",
+						"-name":                  "Only search files whose name matches this pattern, e.g. '*.yml'",
+						"-no-ssh":                "Fetch the files with cf files even if cf ssh is available",
+						"-verbose":               "Verbose output",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-cat",
+				HelpText: "Write a file of a running app to stdout, several files as a tar stream",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-cat APP_NAME PATH... [--tar] [--dotfiles] [--verbose] [--omit ommited_paths] [-i instance_num]",
+					Options: map[string]string{
+						"-tar":                   "Write a tar stream even for a single file",
+						"-dotfiles":              "Let wildcards in PATH match names starting with a dot",
+						"-verbose":               "List the files written to the tar stream on stderr",
+						"-omit \"path/to/file\"": "Omit directories or files (delimited by semicolons)",
+						"i":                      "Instance",
+					},
+				},
+			},
+			plugin.Command{
+				Name:     "download-snapshot",
+				HelpText: "List, restore or prune the snapshots saved with cf download --snapshot",
+
+				UsageDetails: plugin.Usage{
+					Usage: "cf download-snapshot list STORE_DIR\n   cf download-snapshot restore STORE_DIR SNAPSHOT_ID TARGET_DIR\n   cf download-snapshot prune STORE_DIR [--keep-last count] [--keep-within duration] [--dry-run]",
+					Options: map[string]string{
+						"-keep-last":   "Keep this many of the newest snapshots of every app instance",
+						"-keep-within": "Keep the snapshots younger than this, e.g. 30d or 2w",
+						"-dry-run":     "Show what would be removed without removing it",
 					},
 				},
 			},
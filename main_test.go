@@ -1,12 +1,22 @@
 package main_test
 
 import (
+	"archive/tar"
+	"bytes"
 	. "github.com/ibmjstart/cf-download"
 	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/metadata"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -105,6 +115,257 @@ var _ = Describe("CfDownload", func() {
 		})
 	})
 
+	Describe("Test DirPath functionality", func() {
+		It("Should turn a PATH argument into the app path of a directory", func() {
+			Expect(DirPath("app/src")).To(Equal("/app/src/"))
+			Expect(DirPath("/app//src/")).To(Equal("/app/src/"))
+			Expect(DirPath("/")).To(Equal("/"))
+		})
+	})
+
+	Describe("Test ParseDiffArgs functionality", func() {
+		It("Should compare the whole app without a PATH", func() {
+			args := [...]string{"download-diff", "app", "local", "--patch", "-i", "2"}
+
+			flagVals, readPath := ParseDiffArgs(args[:])
+			Expect(readPath).To(Equal("/"))
+			Expect(flagVals.Patch_flag).To(BeTrue())
+			Expect(flagVals.Instance_flag).To(Equal("2"))
+		})
+
+		It("Should compare below the given PATH", func() {
+			args := [...]string{"download-diff", "app", "local", "app/src", "--omit", "app/src/tmp"}
+
+			flagVals, readPath := ParseDiffArgs(args[:])
+			Expect(readPath).To(Equal("/app/src/"))
+			Expect(flagVals.Patch_flag).To(BeFalse())
+			Expect(flagVals.Omit_flag).To(Equal("app/src/tmp"))
+		})
+	})
+
+	Describe("Test ParseCompareArgs functionality", func() {
+		It("Should read the apps, instances and PATH", func() {
+			args := [...]string{"download-compare", "app:1", "app-prod", "app/src", "--json"}
+
+			flagVals, readPath := ParseCompareArgs(args[:])
+			Expect(readPath).To(Equal("/app/src/"))
+			Expect(flagVals.Json_flag).To(BeTrue())
+			Expect(flagVals.Patch_flag).To(BeFalse())
+
+			Expect(ParseTarget(args[1]).App).To(Equal("app"))
+			Expect(ParseTarget(args[1]).Instance).To(Equal("1"))
+			Expect(ParseTarget(args[2]).App).To(Equal("app-prod"))
+			Expect(ParseTarget(args[2]).Instance).To(Equal("0"))
+			Expect(ParseTarget("app:blue").App).To(Equal("app:blue"))
+		})
+	})
+
+	Describe("Test ParseLsArgs functionality", func() {
+		It("Should read the PATHs and output flags", func() {
+			args := [...]string{"download-ls", "app", "app/src", "logs", "--depth", "2", "--csv"}
+
+			flagVals, paths := ParseLsArgs(args[:])
+			Expect(paths).To(Equal([]string{"app/src", "logs"}))
+			Expect(flagVals.Depth_flag).To(Equal(2))
+			Expect(flagVals.Csv_flag).To(BeTrue())
+			Expect(flagVals.Json_flag).To(BeFalse())
+		})
+
+		It("Should list directories, and files matched by a glob", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetOutput("Getting files for app Test in org test / space dev as user...\nOK\n\nxyz.txt                                   220B\nlib/                                      -\n\n")
+
+			parser := dir_parser.NewParser(cmdExec, "Test", "0", false, false)
+
+			readPaths, err := LsPaths(parser, nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readPaths).To(Equal([]string{"/"}))
+
+			readPaths, err = LsPaths(parser, []string{"app/src", "/logs/", "*.txt"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readPaths).To(Equal([]string{"/app/src/", "/logs/", "/xyz.txt"}))
+		})
+	})
+
+	Describe("Test ParseDuArgs functionality", func() {
+		It("Should default to the top 10 and a 10M threshold", func() {
+			args := [...]string{"download-du", "app"}
+
+			flagVals, readPath := ParseDuArgs(args[:])
+			Expect(readPath).To(Equal("/"))
+			Expect(flagVals.Top_flag).To(Equal(10))
+			Expect(flagVals.Threshold_flag).To(Equal(int64(10 << 20)))
+		})
+
+		It("Should read the PATH, --top and --threshold", func() {
+			args := [...]string{"download-du", "app", "app/", "--top", "25", "--threshold", "500K"}
+
+			flagVals, readPath := ParseDuArgs(args[:])
+			Expect(readPath).To(Equal("/app/"))
+			Expect(flagVals.Top_flag).To(Equal(25))
+			Expect(flagVals.Threshold_flag).To(Equal(int64(500 << 10)))
+		})
+	})
+
+	Describe("Test ParseFindArgs functionality", func() {
+		It("Should read the PATH and predicates", func() {
+			args := [...]string{"download-find", "app", "app/logs", "--name", "*.log", "--size", "+10M", "--type", "f", "--download"}
+
+			flagVals, readPath := ParseFindArgs(args[:])
+			Expect(readPath).To(Equal("/app/logs/"))
+			Expect(flagVals.Download_flag).To(BeTrue())
+			Expect(flagVals.Match_flag.Match("app.log", false, 11<<20)).To(BeTrue())
+			Expect(flagVals.Match_flag.Match("app.log", false, 1<<20)).To(BeFalse())
+		})
+
+		It("Should find matching files and directories", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetFakeDir(true)
+			parser := dir_parser.NewParser(cmdExec, "app", "0", false, false)
+			match, _ := filter.ParsePredicate("*ignore*", "", "")
+
+			matches, files, failed := FindMatches(parser, "downloader/testFiles/", nil, match)
+			Expect(matches).To(Equal([]string{"downloader/testFiles/ignore.go", "downloader/testFiles/ignoreDir/", "downloader/testFiles/notignored.go"}))
+			Expect(files).To(Equal([]string{"ignore.go", "notignored.go"}))
+			Expect(failed).To(BeEmpty())
+		})
+	})
+
+	Describe("Test ParseGrepArgs functionality", func() {
+		It("Should read the PATH after the PATTERN", func() {
+			args := [...]string{"download-grep", "app", "db_host", "app/config", "--ignore-case", "--context", "2", "--no-ssh"}
+
+			flagVals, pattern, readPath := ParseGrepArgs(args[:])
+			Expect(pattern).To(Equal("db_host"))
+			Expect(readPath).To(Equal("/app/config/"))
+			Expect(flagVals.IgnoreCase_flag).To(BeTrue())
+			Expect(flagVals.Context_flag).To(Equal(2))
+			Expect(flagVals.NoSsh_flag).To(BeTrue())
+		})
+
+		It("Should search the whole app without a PATH", func() {
+			args := [...]string{"download-grep", "app", "ERROR", "--name", "*.log"}
+
+			flagVals, _, readPath := ParseGrepArgs(args[:])
+			Expect(readPath).To(Equal("/"))
+			Expect(flagVals.Name_flag).To(Equal("*.log"))
+		})
+
+		It("Should read a PATTERN starting with a dash after -e", func() {
+			args := [...]string{"download-grep", "app", "-e", "--debug", "app/logs", "--context", "1"}
+
+			flagVals, pattern, readPath := ParseGrepArgs(args[:])
+			Expect(pattern).To(Equal("--debug"))
+			Expect(readPath).To(Equal("/app/logs/"))
+			Expect(flagVals.Context_flag).To(Equal(1))
+		})
+	})
+
+	Describe("Test CatArgs and CatAsTar functionality", func() {
+		It("Should turn download-cat into a download to stdout", func() {
+			args := [...]string{"download-cat", "app", "app/config.json", "-i", "1"}
+			Expect(CatArgs(args[:])).To(Equal([]string{"download", "app", "app/config.json", "-i", "1", "--stdout"}))
+		})
+
+		It("Should write a single file as it is and everything else as tar", func() {
+			Expect(CatAsTar([]string{"app/a.json", "-i", "1"}, []string{"app/a.json"}, false)).To(BeFalse())
+			Expect(CatAsTar([]string{"app/a.json"}, []string{"app/a.json"}, true)).To(BeTrue())
+			Expect(CatAsTar([]string{"app/a.json", "app/b.json"}, []string{"app/a.json", "app/b.json"}, false)).To(BeTrue())
+			Expect(CatAsTar([]string{"app/*.json"}, []string{"app/a.json"}, false)).To(BeTrue())
+			Expect(CatAsTar([]string{"app/lib/"}, []string{"app/lib/"}, false)).To(BeTrue())
+		})
+
+		It("Should write the files of several directories to one readable tar stream", func() {
+			dir, _ := ioutil.TempDir("", "cat-tar")
+			defer os.RemoveAll(dir)
+			files := map[string]string{"top.txt": "t", "lib/a.js": "a", "lib/deep/b.js": "b", "test/c.js": "c", "test/d.js": "d"}
+			for name, content := range files {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+				ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+			}
+
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetFakeDir(true)
+			var wg sync.WaitGroup
+			fetcher := downloader.NewDownloader(cmdExec, &wg, "app", "0", false, false)
+			defer fetcher.Close()
+			parser := dir_parser.NewParser(cmdExec, "app", "0", false, false)
+
+			root := filepath.ToSlash(dir)
+			var out bytes.Buffer
+			failed := WriteTar(&out, fetcher, parser, []string{root + "/top.txt", root + "/lib/", root + "/test/"}, nil, false, false)
+			Expect(failed).To(Equal(0))
+
+			read := make(map[string]string)
+			archive := tar.NewReader(&out)
+			for {
+				header, err := archive.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+				content, _ := ioutil.ReadAll(archive)
+				read[strings.TrimPrefix(header.Name, strings.TrimPrefix(root, "/")+"/")] = string(content)
+			}
+			Expect(read).To(Equal(files))
+		})
+	})
+
+	Describe("Test ParseInterval functionality", func() {
+		It("Should read durations and plain seconds", func() {
+			interval, err := ParseInterval("5m")
+			Expect(err).To(BeNil())
+			Expect(interval).To(Equal(5 * time.Minute))
+
+			interval, err = ParseInterval("30")
+			Expect(err).To(BeNil())
+			Expect(interval).To(Equal(30 * time.Second))
+
+			interval, err = ParseInterval("")
+			Expect(err).To(BeNil())
+			Expect(interval).To(BeZero())
+		})
+
+		It("Should reject intervals below a second", func() {
+			_, err := ParseInterval("100ms")
+			Expect(err).NotTo(BeNil())
+			_, err = ParseInterval("soon")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Test --git functionality", func() {
+		It("Should sync and mirror, so removed files are committed as deletions", func() {
+			flagVals, _ := ParseArgs([]string{"download", "my-app", "app", "--git"})
+			Expect(flagVals.Git_flag).To(BeTrue())
+			Expect(flagVals.Sync_flag).To(BeTrue())
+			Expect(flagVals.Mirror_flag).To(BeTrue())
+
+			flagVals, _ = ParseArgs([]string{"download", "my-app", "app", "--git-adopt", "--mirror"})
+			Expect(flagVals.Git_flag).To(BeTrue())
+			Expect(flagVals.GitAdopt_flag).To(BeTrue())
+			Expect(flagVals.Mirror_flag).To(BeTrue())
+		})
+	})
+
+	Describe("Test RefreshArgs functionality", func() {
+		It("Should repeat the recorded download with --sync and the extra flags", func() {
+			meta := metadata.Metadata{App: "my-app", Instance: "2", Path: "/app/logs/"}
+			args := RefreshArgs(meta, []string{"download", "--refresh", "--mirror"})
+			Expect(args).To(Equal([]string{"download", "my-app", "app/logs", "-i", "2", "--sync", "--mirror"}))
+
+			flagVals, paths := ParseArgs(args)
+			Expect(paths).To(Equal([]string{"app/logs"}))
+			Expect(flagVals.Instance_flag).To(Equal("2"))
+			Expect(flagVals.Mirror_flag).To(BeTrue())
+		})
+
+		It("Should leave out the path of a whole app download", func() {
+			args := RefreshArgs(metadata.Metadata{App: "my-app", Instance: "0", Path: "/"}, []string{"download", "--refresh"})
+			Expect(args).To(Equal([]string{"download", "my-app", "-i", "0", "--sync"}))
+		})
+	})
+
 	Describe("test directoryContext parsing", func() {
 
 		It("Should return correct strings", func() {
@@ -190,24 +451,131 @@ var _ = Describe("CfDownload", func() {
 
 	})
 
+	Describe("test resolving file and directory paths", func() {
+		It("Should look up each path in the listing of its parent", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetOutput("Getting files for app Test in org test / space dev as user...\nOK\n\npackage.json                              1.2K\nconfig/                                   -\n\n")
+			parser := dir_parser.NewParser(cmdExec, "Test", "0", false, false)
+
+			paths, err := ResolvePaths(parser, []string{"app/package.json", "app/config", "logs/"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"app/package.json", "app/config/", "logs/"}))
+
+			_, err = ResolvePaths(parser, []string{"app/missing.json"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("app/missing.json"))
+		})
+
+		It("Should download files and directories side by side", func() {
+			currentDirectory, _ := os.Getwd()
+			currentDirectory = filepath.ToSlash(currentDirectory)
+			pathVals := GetResolvedContext(currentDirectory, []string{"app/package.json", "app/config/"})
+
+			Expect(pathVals[0].IsFile).To(BeTrue())
+			Expect(pathVals[0].StartingPathServer).To(Equal("/app/package.json"))
+			Expect(strings.HasSuffix(pathVals[0].RootWorkingDirectoryLocal, filepath.FromSlash("/cf-download/package.json"))).To(BeTrue())
+			Expect(pathVals[1].IsFile).To(BeFalse())
+			Expect(pathVals[1].StartingPathServer).To(Equal("/app/config/"))
+			Expect(strings.HasSuffix(pathVals[1].RootWorkingDirectoryLocal, filepath.FromSlash("/cf-download/config/"))).To(BeTrue())
+		})
+
+		It("Should refuse the flags that need directories for a file", func() {
+			syncFlags, _ := ParseArgs([]string{"download", "app", "app/package.json", "--sync"})
+			Expect(CheckFilePaths([]string{"app/package.json"}, syncFlags)).To(Succeed())
+
+			mirrorFlags, _ := ParseArgs([]string{"download", "app", "app/config", "--mirror"})
+			Expect(CheckFilePaths([]string{"app/config/"}, mirrorFlags)).To(Succeed())
+			Expect(CheckFilePaths([]string{"app/config/", "app/package.json"}, mirrorFlags)).NotTo(Succeed())
+		})
+	})
+
 	Describe("test expandGlobs parsing", func() {
 		It("should return x.txt, y.txt, a.go and ab.go", func() {
 			cmdExec := cmd_exec_fake.NewCmdExec()
 			cmdExec.SetOutput("Getting files for app Test in org test / space dev as user...\nOK\n\nxyz.txt                                   220B\na.go                                      675B\nab.go                                     333B\nyz.go                                     123B\n\n")
 			cmdExec.SetFakeDir(false)
+			parser := dir_parser.NewParser(cmdExec, "Test", "0", false, false)
+
+			paths, _, err := ExpandGlobs(parser, []string{"*.txt"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"xyz.txt"}))
+
+			paths, _, err = ExpandGlobs(parser, []string{"?.go"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"a.go"}))
+
+			paths, _, err = ExpandGlobs(parser, []string{"[a-z]b.go"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"ab.go"}))
+		})
 
-			paths := make([]string, 1)
-			paths[0] = "*.txt"
-			paths = ExpandGlobs(cmdExec, paths, "0")
-			Expect(paths[0]).To(Equal("./xyz.txt"))
+		It("should keep plain paths and fail on a pattern that matches nothing", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetOutput("Getting files for app Test in org test / space dev as user...\nOK\n\nxyz.txt                                   220B\n\n")
+			parser := dir_parser.NewParser(cmdExec, "Test", "0", false, false)
+
+			paths, _, err := ExpandGlobs(parser, []string{"app/src", "{a,xyz}.txt"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"app/src", "xyz.txt"}))
+
+			_, _, err = ExpandGlobs(parser, []string{"*.go"}, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("*.go"))
+		})
 
-			paths[0] = "?.go"
-			paths = ExpandGlobs(cmdExec, paths, "0")
-			Expect(paths[0]).To(Equal("./a.go"))
+		It("should keep matches with the same name apart below the glob's fixed directories", func() {
+			parser := dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(map[string]string{
+				"/":       "app/                                      -\nlogs/                                     -\n",
+				"/app/":   "a/                                        -\n",
+				"/app/a/": "x.log                                     1.0K\n",
+				"/logs/":  "x.log                                     2.0K\n",
+			}), "Test", "0", false, false)
+
+			paths, locals, err := ExpandGlobs(parser, []string{"**/*.log", "app/*/"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"app/a/x.log", "logs/x.log", "app/a/"}))
+			Expect(locals).To(Equal(map[string]string{"app/a/x.log": "app/a/x.log", "logs/x.log": "logs/x.log", "app/a": "a"}))
+
+			currentDirectory, _ := os.Getwd()
+			currentDirectory = filepath.ToSlash(currentDirectory)
+			pathVals := GetResolvedContext(currentDirectory, paths)
+			PlaceGlobMatches(currentDirectory, pathVals, locals)
+
+			Expect(pathVals[0].RootWorkingDirectoryLocal).To(Equal(filepath.FromSlash(currentDirectory + "/app/a/x.log")))
+			Expect(pathVals[1].RootWorkingDirectoryLocal).To(Equal(filepath.FromSlash(currentDirectory + "/logs/x.log")))
+			Expect(pathVals[2].RootWorkingDirectoryLocal).To(Equal(filepath.FromSlash(currentDirectory + "/a/")))
+			Expect(CheckDestinations(pathVals)).To(Succeed())
+		})
+
+		It("should refuse matches of different PATH arguments that meet locally", func() {
+			parser := dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(map[string]string{
+				"/app/":       "lib/                                      -\ntest/                                     -\n",
+				"/app/lib/":   "a.js                                      1.0K\nutil/                                     -\n",
+				"/app/test/":  "a.js                                      2.0K\nb.js                                      2.0K\n",
+				"/logs/":      "lib/                                      -\nutil/                                     -\n",
+				"/logs/lib/":  "x.js                                      1.0K\n",
+				"/logs/util/": "y.txt                                     1.0K\n",
+			}), "Test", "0", false, false)
+			currentDirectory, _ := os.Getwd()
+			currentDirectory = filepath.ToSlash(currentDirectory)
 
-			paths[0] = "[a-z]b.go"
-			paths = ExpandGlobs(cmdExec, paths, "0")
-			Expect(paths[0]).To(Equal("./ab.go"))
+			place := func(args ...string) error {
+				paths, locals, err := ExpandGlobs(parser, args, false)
+				Expect(err).NotTo(HaveOccurred())
+				pathVals := GetResolvedContext(currentDirectory, paths)
+				PlaceGlobMatches(currentDirectory, pathVals, locals)
+				return CheckDestinations(pathVals)
+			}
+
+			err := place("app/lib/*.js", "app/test/*.js")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("/app/test/a.js"))
+
+			Expect(place("app/lib/*/", "logs/*/")).NotTo(Succeed())
+			Expect(place("app/*/", "logs/**/*.js")).NotTo(Succeed())
+			Expect(place("app/*/", "app/lib/*.js")).To(Succeed())
+			Expect(place("app/lib/*.js", "app/lib/a.js")).To(Succeed())
+			Expect(place("app/*/*.js")).To(Succeed())
 		})
 	})
 
@@ -0,0 +1,221 @@
+package merge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/snapshot"
+	"github.com/ibmjstart/cf-download/text_diff"
+)
+
+// what happened to a local file
+type Status int
+
+const (
+	// a file that was only changed remotely was replaced
+	Updated Status = iota
+	// a file that is new remotely was added
+	Added
+	// a file that was deleted remotely, and not changed locally, was removed
+	Removed
+	// remote and local changes were combined without conflicts
+	Merged
+	// remote and local changes overlap, the file holds conflict markers
+	Conflicted
+	// changed on both sides in a way that cannot be merged, the local file was left alone
+	Skipped
+)
+
+func (k Status) String() string {
+	switch k {
+	case Updated:
+		return "updated"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Merged:
+		return "merged"
+	case Conflicted:
+		return "conflicted"
+	}
+	return "skipped"
+}
+
+// the outcome for one file, Reason says why a file was skipped or could not be written
+type Result struct {
+	Path   string
+	Status Status
+	Reason string
+}
+
+// labels of the conflict markers
+const (
+	labelLocal  = "local"
+	labelRemote = "remote"
+)
+
+/*
+*	FindBase() returns the newest complete snapshot before 'current' of the same app and paths, the
+*	state both sides last agreed on, or nil if there is none.
+ */
+func FindBase(store snapshot.Store, current snapshot.Manifest) (*snapshot.Manifest, error) {
+	manifests, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// the store lists the oldest first
+	for i := len(manifests) - 1; i >= 0; i-- {
+		val := manifests[i]
+		if val.ID != current.ID && val.Complete && val.App == current.App &&
+			strings.Join(val.Paths, "\n") == strings.Join(current.Paths, "\n") && !val.Created.After(current.Created) {
+			return &val, nil
+		}
+	}
+
+	return nil, nil
+}
+
+/*
+*	Tree() brings the changes between 'base' and 'remote' below the app path 'root' into
+*	'localDir'. Files only changed remotely are replaced, files changed on both sides are merged
+*	line by line if they are text. Local files the remote side did not change are never touched.
+*	Without a base, only files missing locally are added. Results are returned for every file that
+*	was written, removed or skipped.
+ */
+func Tree(store snapshot.Store, base *snapshot.Manifest, remote snapshot.Manifest, root, localDir string) []Result {
+	baseHashes := hashes(base, root)
+	remoteHashes := hashes(&remote, root)
+
+	var paths []string
+	for path := range remoteHashes {
+		paths = append(paths, path)
+	}
+	for path := range baseHashes {
+		if _, ok := remoteHashes[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	var results []Result
+	for _, path := range paths {
+		target := filepath.Join(localDir, filepath.FromSlash(path))
+		if rel, err := filepath.Rel(localDir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			results = append(results, Result{Path: path, Status: Skipped, Reason: "outside of " + localDir})
+			continue
+		}
+
+		if result, changed := mergeFile(store, baseHashes[path], remoteHashes[path], target); changed {
+			result.Path = path
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+// merges one file, reports false if nothing needed to be done
+func mergeFile(store snapshot.Store, baseHash, remoteHash, target string) (Result, bool) {
+	// the remote side did not change it
+	if remoteHash == baseHash {
+		return Result{}, false
+	}
+
+	local, err := ioutil.ReadFile(target)
+	localExists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return Result{Status: Skipped, Reason: err.Error()}, true
+	}
+	localHash := ""
+	if localExists {
+		localHash = snapshot.Hash(local)
+	}
+
+	switch {
+	case localHash == remoteHash:
+		// both sides made the same change
+		return Result{}, false
+
+	case baseHash == "" && localExists:
+		return Result{Status: Skipped, Reason: "changed on both sides without an earlier snapshot to merge from"}, true
+
+	case baseHash == "":
+		return write(store, remoteHash, target, Added, nil)
+
+	case remoteHash == "" && localHash == baseHash:
+		if err := os.Remove(target); err != nil {
+			return Result{Status: Skipped, Reason: err.Error()}, true
+		}
+		return Result{Status: Removed}, true
+
+	case remoteHash == "":
+		return Result{Status: Skipped, Reason: "deleted remotely but changed locally"}, true
+
+	case !localExists:
+		return Result{Status: Skipped, Reason: "changed remotely but deleted locally"}, true
+
+	case localHash == baseHash:
+		return write(store, remoteHash, target, Updated, nil)
+	}
+
+	baseContent, err := store.Read(baseHash)
+	if err != nil {
+		return Result{Status: Skipped, Reason: err.Error()}, true
+	}
+	remoteContent, err := store.Read(remoteHash)
+	if err != nil {
+		return Result{Status: Skipped, Reason: err.Error()}, true
+	}
+	if !text_diff.IsText(baseContent) || !text_diff.IsText(local) || !text_diff.IsText(remoteContent) {
+		return Result{Status: Skipped, Reason: "binary file changed on both sides"}, true
+	}
+
+	merged, conflicts := text_diff.Merge(string(baseContent), string(local), string(remoteContent), labelLocal, labelRemote)
+	status := Merged
+	if conflicts > 0 {
+		status = Conflicted
+	}
+
+	return write(store, "", target, status, []byte(merged))
+}
+
+// writes 'content', or the object stored under 'hash', to 'target'
+func write(store snapshot.Store, hash, target string, status Status, content []byte) (Result, bool) {
+	var err error
+	if content == nil {
+		content, err = store.Read(hash)
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(target, content, 0644)
+	}
+	if err != nil {
+		return Result{Status: Skipped, Reason: "cannot write: " + err.Error()}, true
+	}
+
+	return Result{Status: status}, true
+}
+
+// maps the paths below 'root' to the hash of their content
+func hashes(manifest *snapshot.Manifest, root string) map[string]string {
+	byPath := make(map[string]string)
+	if manifest == nil {
+		return byPath
+	}
+
+	for _, val := range manifest.Files {
+		if strings.HasPrefix(val.Path, root) {
+			byPath[strings.TrimPrefix(val.Path, root)] = val.Hash
+		}
+	}
+
+	return byPath
+}
@@ -0,0 +1,137 @@
+package merge_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/ibmjstart/cf-download/merge"
+	"github.com/ibmjstart/cf-download/snapshot"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Merge", func() {
+	var dir, local string
+	var store snapshot.Store
+
+	// saves a snapshot of the files below /app/
+	save := func(id string, age time.Duration, files map[string]string) snapshot.Manifest {
+		manifest := snapshot.Manifest{ID: id, App: "app", Instance: "0", Paths: []string{"/app/"}, Created: time.Now().Add(-age), Complete: true}
+		for path, content := range files {
+			hash, _, err := store.Put([]byte(content))
+			Expect(err).To(BeNil())
+			manifest.Files = append(manifest.Files, snapshot.Entry{Path: "/app/" + path, Hash: hash, Size: int64(len(content))})
+		}
+		Expect(store.Save(manifest)).To(BeNil())
+		return manifest
+	}
+
+	writeLocal := func(files map[string]string) {
+		for path, content := range files {
+			os.MkdirAll(filepath.Dir(filepath.Join(local, path)), 0755)
+			ioutil.WriteFile(filepath.Join(local, path), []byte(content), 0644)
+		}
+	}
+
+	readLocal := func(path string) string {
+		content, _ := ioutil.ReadFile(filepath.Join(local, path))
+		return string(content)
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "merge-test")
+		local = filepath.Join(dir, "local")
+		store = snapshot.NewStore(filepath.Join(dir, "store"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Test FindBase()", func() {
+		It("Should find the newest earlier complete snapshot of the same app and paths", func() {
+			save("old", 3*time.Hour, nil)
+			base := save("base", 2*time.Hour, nil)
+			incomplete := snapshot.Manifest{ID: "incomplete", App: "app", Paths: []string{"/app/"}, Created: time.Now().Add(-time.Hour)}
+			store.Save(incomplete)
+			other := snapshot.Manifest{ID: "other", App: "app", Paths: []string{"/logs/"}, Created: time.Now().Add(-time.Hour), Complete: true}
+			store.Save(other)
+			current := save("current", 0, nil)
+
+			found, err := FindBase(store, current)
+			Expect(err).To(BeNil())
+			Expect(found.ID).To(Equal(base.ID))
+		})
+
+		It("Should return nil without an earlier snapshot", func() {
+			found, err := FindBase(store, save("current", 0, nil))
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("Test Tree()", func() {
+		It("Should bring remote changes into the local tree without losing local work", func() {
+			base := save("base", time.Hour, map[string]string{
+				"remote-only.txt": "old\n",
+				"both.txt":        "1\n2\n3\n4\n5\n",
+				"conflict.txt":    "a\nb\nc\n",
+				"gone.txt":        "x\n",
+				"kept.txt":        "x\n",
+				"image.png":       "\x00old",
+				"same.txt":        "x\n",
+			})
+			writeLocal(map[string]string{
+				"remote-only.txt": "old\n",
+				"both.txt":        "one\n2\n3\n4\n5\n",
+				"conflict.txt":    "a\nlocal\nc\n",
+				"gone.txt":        "x\n",
+				"kept.txt":        "changed locally\n",
+				"image.png":       "\x00local",
+				"same.txt":        "x\n",
+				"local-only.txt":  "mine\n",
+			})
+			remote := save("remote", 0, map[string]string{
+				"remote-only.txt": "new\n",
+				"both.txt":        "1\n2\n3\n4\nfive\n",
+				"conflict.txt":    "a\nremote\nc\n",
+				"image.png":       "\x00remote",
+				"same.txt":        "x\n",
+				"added/new.txt":   "hello\n",
+			})
+
+			results := Tree(store, &base, remote, "/app/", local)
+			Expect(results).To(Equal([]Result{
+				{Path: "added/new.txt", Status: Added},
+				{Path: "both.txt", Status: Merged},
+				{Path: "conflict.txt", Status: Conflicted},
+				{Path: "gone.txt", Status: Removed},
+				{Path: "image.png", Status: Skipped, Reason: "binary file changed on both sides"},
+				{Path: "kept.txt", Status: Skipped, Reason: "deleted remotely but changed locally"},
+				{Path: "remote-only.txt", Status: Updated},
+			}))
+
+			Expect(readLocal("added/new.txt")).To(Equal("hello\n"))
+			Expect(readLocal("both.txt")).To(Equal("one\n2\n3\n4\nfive\n"))
+			Expect(readLocal("conflict.txt")).To(Equal("a\n<<<<<<< local\nlocal\n=======\nremote\n>>>>>>> remote\nc\n"))
+			Expect(filepath.Join(local, "gone.txt")).NotTo(BeAnExistingFile())
+			Expect(readLocal("image.png")).To(Equal("\x00local"))
+			Expect(readLocal("kept.txt")).To(Equal("changed locally\n"))
+			Expect(readLocal("remote-only.txt")).To(Equal("new\n"))
+			Expect(readLocal("local-only.txt")).To(Equal("mine\n"))
+		})
+
+		It("Should only add missing files without a base", func() {
+			writeLocal(map[string]string{"app.js": "local\n"})
+			remote := save("remote", 0, map[string]string{"app.js": "remote\n", "new.js": "new\n"})
+
+			results := Tree(store, nil, remote, "/app/", local)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Status).To(Equal(Skipped))
+			Expect(results[1]).To(Equal(Result{Path: "new.js", Status: Added}))
+			Expect(readLocal("app.js")).To(Equal("local\n"))
+		})
+	})
+})
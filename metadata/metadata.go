@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+)
+
+// the file a download leaves in its destination directory
+const FileName = ".cf-download.json"
+
+// files are fetched one at a time with cf files
+const Transport = "cf files"
+
+/*
+*	Metadata records how a directory was downloaded, so the download can be repeated from inside
+*	it. Path is the path in the app the directory was downloaded from and Omit the filter list in
+*	effect, .cfignore entries included. Droplet identifies the staged app; it changes on every
+*	restage and is empty if the API did not report it.
+ */
+type Metadata struct {
+	App        string    `json:"app"`
+	Guid       string    `json:"guid"`
+	Droplet    string    `json:"droplet"`
+	Instance   string    `json:"instance"`
+	Path       string    `json:"path"`
+	Omit       []string  `json:"omit"`
+	Transport  string    `json:"transport"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
+/*
+*	Read() loads the metadata of the download in 'dir'.
+ */
+func Read(dir string) (Metadata, error) {
+	var meta Metadata
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return meta, errors.New("no " + FileName + " found in " + dir + ", it is written by a completed cf download")
+	}
+
+	if err := json.Unmarshal(content, &meta); err != nil || meta.App == "" {
+		return meta, errors.New(filepath.Join(dir, FileName) + " is not valid download metadata")
+	}
+
+	return meta, nil
+}
+
+/*
+*	Write() saves 'meta' in 'dir', replacing the metadata of an earlier download.
+ */
+func Write(dir string, meta Metadata) error {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, FileName), append(content, '\n'), 0644)
+}
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F-]+$`)
+
+/*
+*	LookupApp() returns the GUID of the app and of its current droplet. The droplet is empty if
+*	the cloud controller does not report one, e.g. on an API without v3 support.
+ */
+func LookupApp(cmdExec cmd_exec.CmdExec, appName string) (string, string, error) {
+	output, err := cmdExec.GetAppGuid(appName)
+	guid := strings.TrimSpace(string(output))
+	if err != nil || !guidPattern.MatchString(guid) {
+		return "", "", errors.New("cannot look up the GUID of app " + appName)
+	}
+
+	var droplet struct {
+		Guid string `json:"guid"`
+	}
+	output, err = cmdExec.Curl("/v3/apps/" + guid + "/droplets/current")
+	if err != nil || json.Unmarshal(output, &droplet) != nil || !guidPattern.MatchString(droplet.Guid) {
+		return guid, "", nil
+	}
+
+	return guid, droplet.Guid, nil
+}
+
+/*
+*	Changed() describes how the app differs from the one that was downloaded, given its current
+*	GUID and droplet, or returns "" if it is the same app as far as can be told.
+ */
+func (m Metadata) Changed(guid, droplet string) string {
+	if m.Guid != "" && guid != "" && guid != m.Guid {
+		return "app " + m.App + " was deleted and pushed again"
+	}
+	if m.Droplet != "" && droplet != "" && droplet != m.Droplet {
+		return "app " + m.App + " has been restaged"
+	}
+
+	return ""
+}
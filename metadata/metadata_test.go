@@ -0,0 +1,103 @@
+package metadata_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	. "github.com/ibmjstart/cf-download/metadata"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "metadata-test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Write and Read", func() {
+		It("round trips the recorded download", func() {
+			meta := Metadata{
+				App:        "my-app",
+				Guid:       "6f1a9c2e-0000-4000-8000-000000000001",
+				Droplet:    "9b2c1d3e-0000-4000-8000-000000000002",
+				Instance:   "1",
+				Path:       "/app/",
+				Omit:       []string{"/app/node_modules"},
+				Transport:  Transport,
+				Downloaded: time.Date(2016, 9, 13, 12, 0, 0, 0, time.UTC),
+			}
+			Expect(Write(dir, meta)).To(BeNil())
+
+			read, err := Read(dir)
+			Expect(err).To(BeNil())
+			Expect(read).To(Equal(meta))
+		})
+
+		It("reports a missing or invalid file", func() {
+			_, err := Read(dir)
+			Expect(err).NotTo(BeNil())
+
+			ioutil.WriteFile(filepath.Join(dir, FileName), []byte("not json"), 0644)
+			_, err = Read(dir)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("LookupApp", func() {
+		It("returns the app and droplet GUIDs", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetAppGuid("6f1a9c2e-0000-4000-8000-000000000001\n")
+			cmdExec.SetCurlOutput("/v3/apps/6f1a9c2e-0000-4000-8000-000000000001/droplets/current", `{"guid": "9b2c1d3e-0000-4000-8000-000000000002", "state": "STAGED"}`)
+
+			guid, droplet, err := LookupApp(cmdExec, "my-app")
+			Expect(err).To(BeNil())
+			Expect(guid).To(Equal("6f1a9c2e-0000-4000-8000-000000000001"))
+			Expect(droplet).To(Equal("9b2c1d3e-0000-4000-8000-000000000002"))
+		})
+
+		It("leaves the droplet out if the API does not report it", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetAppGuid("6f1a9c2e-0000-4000-8000-000000000001\n")
+			cmdExec.SetCurlOutput("/v3/apps/6f1a9c2e-0000-4000-8000-000000000001/droplets/current", `{"errors": [{"title": "CF-ResourceNotFound"}]}`)
+
+			guid, droplet, err := LookupApp(cmdExec, "my-app")
+			Expect(err).To(BeNil())
+			Expect(guid).NotTo(BeEmpty())
+			Expect(droplet).To(BeEmpty())
+		})
+
+		It("fails for an unknown app", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetAppGuid("FAILED\nApp my-app not found\n")
+
+			_, _, err := LookupApp(cmdExec, "my-app")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Changed", func() {
+		meta := Metadata{App: "my-app", Guid: "a1", Droplet: "d1"}
+
+		It("detects a restage and a new push", func() {
+			Expect(meta.Changed("a1", "d1")).To(BeEmpty())
+			Expect(meta.Changed("a1", "d2")).To(ContainSubstring("restaged"))
+			Expect(meta.Changed("a2", "d2")).To(ContainSubstring("pushed again"))
+		})
+
+		It("does not warn about what is not known", func() {
+			Expect(meta.Changed("a1", "")).To(BeEmpty())
+			Expect(Metadata{App: "my-app"}.Changed("a1", "d1")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package rate_limiter
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+)
+
+/*
+*	RateLimiter wraps a CmdExec so every cf call made through it, by any goroutine, counts against
+*	one budget of requests and bytes per second.
+ */
+type RateLimiter interface {
+	cmd_exec.CmdExec
+	GetRates() (float64, float64)
+	GetLimits() (int64, float64)
+}
+
+type rateLimiter struct {
+	cmdExec   cmd_exec.CmdExec
+	limitRate int64
+	maxRps    float64
+	budget    budget
+	meter     *meter
+}
+
+/*
+*	NewRateLimiter() limits the wrapped CmdExec to 'limitRate' bytes and 'maxRps' requests per
+*	second, a limit of 0 is no limit. If 'stateFile' is not empty the budget is kept in that file,
+*	so that every invocation of the plugin using the same file shares it.
+ */
+func NewRateLimiter(cmdExec cmd_exec.CmdExec, limitRate int64, maxRps float64, stateFile string) *rateLimiter {
+	var b budget = &localBudget{}
+	if stateFile != "" {
+		b = &fileBudget{path: stateFile}
+	}
+
+	return &rateLimiter{
+		cmdExec:   cmdExec,
+		limitRate: limitRate,
+		maxRps:    maxRps,
+		budget:    b,
+		meter:     newMeter(),
+	}
+}
+
+func (r *rateLimiter) GetFile(appName, readPath, instance string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.GetFile(appName, readPath, instance)
+	r.done(len(output))
+
+	return output, err
+}
+
+func (r *rateLimiter) GetAppStatus(appName string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.GetAppStatus(appName)
+	r.done(len(output))
+
+	return output, err
+}
+
+func (r *rateLimiter) GetAppGuid(appName string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.GetAppGuid(appName)
+	r.done(len(output))
+
+	return output, err
+}
+
+func (r *rateLimiter) Curl(path string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.Curl(path)
+	r.done(len(output))
+
+	return output, err
+}
+
+// the response went to a file, it is charged by the size of that file
+func (r *rateLimiter) CurlToFile(path, file string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.CurlToFile(path, file)
+
+	size := len(output)
+	if info, statErr := os.Stat(file); statErr == nil {
+		size += int(info.Size())
+	}
+	r.done(size)
+
+	return output, err
+}
+
+func (r *rateLimiter) Ssh(appName, instance, command string) ([]byte, error) {
+	r.wait()
+	output, err := r.cmdExec.Ssh(appName, instance, command)
+	r.done(len(output))
+
+	return output, err
+}
+
+/*
+*	GetRates() returns the bytes and requests per second measured over the last few seconds.
+ */
+func (r *rateLimiter) GetRates() (float64, float64) {
+	return r.meter.rates()
+}
+
+func (r *rateLimiter) GetLimits() (int64, float64) {
+	return r.limitRate, r.maxRps
+}
+
+/*
+*	wait() reserves the next free request slot and sleeps until it comes up. A request also has
+*	to wait until the bytes of earlier responses have been paid off at the byte rate. cf files
+*	transfers a whole file in one go, so the bandwidth limit holds on average rather than for
+*	every single transfer.
+ */
+func (r *rateLimiter) wait() {
+	if r.limitRate <= 0 && r.maxRps <= 0 {
+		return
+	}
+
+	var at time.Time
+	r.budget.update(func(s *state) {
+		now := time.Now()
+		at = now
+		if r.limitRate > 0 && s.nextByte.After(at) {
+			at = s.nextByte
+		}
+		if r.maxRps > 0 {
+			if s.nextRequest.After(at) {
+				at = s.nextRequest
+			}
+			s.nextRequest = at.Add(time.Duration(float64(time.Second) / r.maxRps))
+		}
+	})
+
+	time.Sleep(time.Until(at))
+}
+
+// charges the size of a response to the byte budget
+func (r *rateLimiter) done(size int) {
+	r.meter.add(size)
+
+	if r.limitRate <= 0 {
+		return
+	}
+
+	r.budget.update(func(s *state) {
+		start := time.Now()
+		if s.nextByte.After(start) {
+			start = s.nextByte
+		}
+		s.nextByte = start.Add(time.Duration(float64(size) / float64(r.limitRate) * float64(time.Second)))
+	})
+}
+
+/*
+*	ParseRate() reads a --limit-rate value such as 500K or 2M. A plain number is a count of bytes.
+ */
+func ParseRate(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if str == "" {
+		return 0, nil
+	}
+
+	if bytes, err := strconv.ParseInt(str, 10, 64); err == nil && bytes >= 0 {
+		return bytes, nil
+	}
+
+	// accept 500KB as well as 500K
+	if len(str) > 2 && strings.HasSuffix(str, "B") && strings.ContainsAny(str[len(str)-2:len(str)-1], "KMG") {
+		str = strings.TrimSuffix(str, "B")
+	}
+
+	if bytes := dir_parser.ParseSize(str); bytes >= 0 {
+		return bytes, nil
+	}
+
+	return 0, errors.New("invalid rate '" + str + "', expected bytes per second such as 500K or 2M")
+}
+
+// the times at which the next request may start and the byte budget is paid off
+type state struct {
+	nextRequest time.Time
+	nextByte    time.Time
+}
+
+type budget interface {
+	update(change func(s *state))
+}
+
+type localBudget struct {
+	state state
+	mu    sync.Mutex
+}
+
+func (b *localBudget) update(change func(s *state)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	change(&b.state)
+}
+
+// measures throughput in one second buckets over the last meterWindow seconds
+const meterWindow = 5
+
+type meter struct {
+	buckets [meterWindow + 1]bucket
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	second   int64
+	bytes    int64
+	requests int64
+}
+
+func newMeter() *meter {
+	return &meter{}
+}
+
+func (m *meter) add(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	b := &m.buckets[now%int64(len(m.buckets))]
+	if b.second != now {
+		*b = bucket{second: now}
+	}
+	b.bytes += int64(size)
+	b.requests++
+}
+
+// the current second is still filling up and is left out
+func (m *meter) rates() (float64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	var bytes, requests int64
+	for _, val := range m.buckets {
+		if val.second < now && val.second >= now-meterWindow {
+			bytes += val.bytes
+			requests += val.requests
+		}
+	}
+
+	return float64(bytes) / meterWindow, float64(requests) / meterWindow
+}
+
+/*
+*	FormatRate() formats bytes per second the way cf files lists sizes, e.g. 120.5K/s.
+ */
+func FormatRate(bytesPerSec float64) string {
+	units := []string{"B", "K", "M", "G"}
+
+	unit := 0
+	for bytesPerSec >= 1024 && unit < len(units)-1 {
+		bytesPerSec /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return strconv.FormatFloat(bytesPerSec, 'f', 0, 64) + "B/s"
+	}
+	return strconv.FormatFloat(bytesPerSec, 'f', 1, 64) + units[unit] + "/s"
+}
@@ -0,0 +1,96 @@
+package rate_limiter_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	. "github.com/ibmjstart/cf-download/rate_limiter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var cmdExec cmd_exec_fake.FakeCmdExec
+
+	BeforeEach(func() {
+		cmdExec = cmd_exec_fake.NewCmdExec()
+		cmdExec.SetOutput("Getting files for app payToWin in org jstart / space koldus as email@us.ibm.com...\nOK\n0123456789")
+	})
+
+	Describe("Test ParseRate()", func() {
+		It("Should read plain and suffixed rates", func() {
+			Ω(ParseRate("")).To(BeEquivalentTo(0))
+			Ω(ParseRate("2048")).To(BeEquivalentTo(2048))
+			Ω(ParseRate("500K")).To(BeEquivalentTo(500 << 10))
+			Ω(ParseRate("2m")).To(BeEquivalentTo(2 << 20))
+			Ω(ParseRate("1.5KB")).To(BeEquivalentTo(1536))
+			_, err := ParseRate("fast")
+			Ω(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("Test FormatRate()", func() {
+		It("Should format rates like cf files sizes", func() {
+			Ω(FormatRate(512)).To(Equal("512B/s"))
+			Ω(FormatRate(123392)).To(Equal("120.5K/s"))
+			Ω(FormatRate(2 << 20)).To(Equal("2.0M/s"))
+		})
+	})
+
+	Describe("Test request rate", func() {
+		It("Should space requests from all goroutines", func() {
+			limiter := NewRateLimiter(cmdExec, 0, 20, "")
+
+			var wg sync.WaitGroup
+			start := time.Now()
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					limiter.GetFile("payToWin", "/app/file", "0")
+				}()
+			}
+			wg.Wait()
+
+			// the first request goes out at once, the other nine 50ms apart
+			Ω(time.Since(start)).To(BeNumerically(">=", 440*time.Millisecond))
+		})
+	})
+
+	Describe("Test byte rate", func() {
+		It("Should hold back requests until earlier bytes are paid off", func() {
+			limiter := NewRateLimiter(cmdExec, 1000, 0, "")
+			output, _ := limiter.GetFile("payToWin", "/app/file", "0")
+
+			start := time.Now()
+			limiter.GetFile("payToWin", "/app/file", "0")
+			Ω(time.Since(start)).To(BeNumerically(">=", time.Duration(len(output))*time.Millisecond-10*time.Millisecond))
+		})
+	})
+
+	Describe("Test shared budget", func() {
+		It("Should share the budget between limiters using the same state file", func() {
+			dir, _ := ioutil.TempDir("", "rate_limiter")
+			defer os.RemoveAll(dir)
+			stateFile := filepath.Join(dir, "budget")
+
+			first := NewRateLimiter(cmdExec, 0, 10, stateFile)
+			second := NewRateLimiter(cmdExec, 0, 10, stateFile)
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				first.GetFile("payToWin", "/app/file", "0")
+				second.GetFile("payToWin", "/app/file", "0")
+			}
+
+			// six requests at 10 per second, the first one is free
+			Ω(time.Since(start)).To(BeNumerically(">=", 490*time.Millisecond))
+			_, err := os.Stat(stateFile + ".lock")
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
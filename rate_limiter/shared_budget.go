@@ -0,0 +1,120 @@
+package rate_limiter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a lock older than this was left behind by a plugin that died while holding it
+const staleLockAge = 5 * time.Second
+
+/*
+*	fileBudget keeps the budget state in a file so parallel invocations of the plugin share it.
+*	Updates are serialized with a lock file next to the state file, created exclusively. If the
+*	file cannot be used the budget falls back to this process only.
+ */
+type fileBudget struct {
+	path     string
+	fallback localBudget
+	failed   bool
+	mu       sync.Mutex
+}
+
+func (b *fileBudget) update(change func(s *state)) {
+	// goroutines of this process take turns before competing for the lock file
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failed || !b.lock() {
+		b.failed = true
+		b.fallback.update(change)
+		return
+	}
+	defer b.unlock()
+
+	s := b.read()
+	change(&s)
+	b.write(s)
+}
+
+func (b *fileBudget) lock() bool {
+	lockPath := b.path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return false
+	}
+
+	for i := 0; i < 1000; i++ {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return true
+		}
+		if !os.IsExist(err) {
+			return false
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return false
+}
+
+func (b *fileBudget) unlock() {
+	os.Remove(b.path + ".lock")
+}
+
+// the file holds the next request time and the next byte time in unix nanoseconds
+func (b *fileBudget) read() state {
+	var s state
+
+	content, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return s
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		return s
+	}
+	if nanos, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+		s.nextRequest = time.Unix(0, nanos)
+	}
+	if nanos, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+		s.nextByte = time.Unix(0, nanos)
+	}
+
+	return s
+}
+
+func (b *fileBudget) write(s state) {
+	content := strconv.FormatInt(s.nextRequest.UnixNano(), 10) + " " + strconv.FormatInt(s.nextByte.UnixNano(), 10) + "\n"
+	ioutil.WriteFile(b.path, []byte(content), 0644)
+}
+
+/*
+*	StateFile() returns the budget file shared by all invocations of the plugin, kept under the
+*	cf home directory ($CF_HOME, or the user's home directory) next to the installed plugins.
+ */
+func StateFile() string {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	if home == "" {
+		return ""
+	}
+
+	return filepath.Join(home, ".cf", "plugins", "cf-download-rate-limit")
+}
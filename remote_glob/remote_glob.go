@@ -0,0 +1,288 @@
+package remote_glob
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+type Expander interface {
+	Expand(pattern string) ([]string, error)
+}
+
+type expander struct {
+	parser   dir_parser.Parser
+	workers  int
+	dotfiles bool
+}
+
+/*
+*	NewExpander() returns an expander listing up to 'workers' directories at the same time.
+*	Names starting with a dot are only matched by a pattern segment that starts with a dot too,
+*	as in a shell, unless 'dotfiles' is set.
+ */
+func NewExpander(parser dir_parser.Parser, workers int, dotfiles bool) *expander {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &expander{
+		parser:   parser,
+		workers:  workers,
+		dotfiles: dotfiles,
+	}
+}
+
+/*
+*	IsPattern() reports whether a path argument holds wildcards or brace alternatives.
+ */
+func IsPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[{")
+}
+
+/*
+*	Prefix() returns the directories at the start of a pattern that hold no wildcards or braces,
+*	e.g. app/lib/ for app/lib/*.js, or an empty string if the first segment is a pattern.
+ */
+func Prefix(pattern string) string {
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+pattern), "/"), "/")
+
+	prefix := ""
+	for _, val := range segments[:len(segments)-1] {
+		if IsPattern(val) {
+			break
+		}
+		prefix += val + "/"
+	}
+
+	return prefix
+}
+
+/*
+*	Expand() returns the app paths matching 'pattern', sorted and without a leading slash.
+*	Directories end in a slash. Every segment may hold the wildcards of path.Match, '**' matches
+*	any number of directories and {a,b} matches either alternative. A trailing '**' matches every
+*	file and directory below the directory before it. A matched directory is downloaded whole, so
+*	nothing inside it is returned as a match of its own. An error is returned if the pattern is
+*	malformed or matches nothing.
+ */
+func (e *expander) Expand(pattern string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, alternative := range ExpandBraces(pattern) {
+		segments := strings.Split(strings.Trim(path.Clean("/"+alternative), "/"), "/")
+		for _, val := range segments {
+			if _, err := path.Match(val, ""); err != nil {
+				return nil, errors.New("invalid pattern '" + pattern + "': " + err.Error())
+			}
+		}
+
+		// segments without wildcards need no listing
+		dir := "/"
+		for len(segments) > 1 && !IsPattern(segments[0]) {
+			dir += segments[0] + "/"
+			segments = segments[1:]
+		}
+
+		// a trailing slash only matches directories
+		dirsOnly := strings.HasSuffix(alternative, "/")
+
+		if err := e.match(dir, segments, dirsOnly, found); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, errors.New("'" + pattern + "' did not match any file or directory")
+	}
+
+	return outermost(found), nil
+}
+
+/*
+*	Walks 'dir' and adds the entries below it that match 'segments' to 'found'. Every listing is
+*	matched as it comes in and then dropped, the walker only descends where the rest of the
+*	pattern can still match and never below a matched directory.
+ */
+func (e *expander) match(dir string, segments []string, dirsOnly bool, found map[string]bool) error {
+	var mu sync.Mutex
+	var firstErr error
+	last := len(segments)
+
+	w := walker.NewWalker(e.parser, e.workers, e.workers)
+	w.Walk(dir, nil, func(listing walker.Listing) bool {
+		// the segments the names below the directory can match
+		states := e.start(segments)
+		if rel := strings.TrimPrefix(listing.ReadPath, dir); rel != "" {
+			for _, name := range strings.Split(strings.TrimSuffix(rel, "/"), "/") {
+				states = e.advance(segments, states, name)
+				if states[last] {
+					return false
+				}
+			}
+		}
+		if !pending(states) {
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if listing.Failed {
+			if firstErr == nil {
+				firstErr = errors.New("cannot list '" + listing.ReadPath + "'")
+			}
+			w.Stop()
+			return false
+		}
+
+		for _, val := range listing.Files {
+			if !dirsOnly && e.advance(segments, states, val.Name)[last] {
+				found[val.ReadPath] = true
+			}
+		}
+
+		descend := false
+		for _, val := range listing.Dirs {
+			next := e.advance(segments, states, strings.TrimSuffix(val, "/"))
+			if next[last] {
+				found[listing.ReadPath+val] = true
+			} else if pending(next) {
+				descend = true
+			}
+		}
+
+		return descend
+	})
+
+	return firstErr
+}
+
+// the segments a name directly in the walked directory can match
+func (e *expander) start(segments []string) []bool {
+	states := make([]bool, len(segments)+1)
+	states[0] = true
+
+	return closure(segments, states)
+}
+
+// the segments the names below 'name' can match, given those 'name' itself could match
+func (e *expander) advance(segments []string, states []bool, name string) []bool {
+	next := make([]bool, len(segments)+1)
+	for i, val := range segments {
+		if !states[i] || !e.visible(name, val) {
+			continue
+		}
+
+		// '**' matches one more directory and then '**' again
+		if val == "**" {
+			next[i] = true
+		} else if ok, _ := path.Match(val, name); ok {
+			next[i+1] = true
+		}
+	}
+
+	return closure(segments, next)
+}
+
+// '**' also matches no directory at all, so the segment after it can match as well
+func closure(segments []string, states []bool) []bool {
+	for i, val := range segments {
+		if states[i] && val == "**" {
+			states[i+1] = true
+		}
+	}
+
+	return states
+}
+
+// reports whether a segment is left to match, rather than only the end of the pattern
+func pending(states []bool) bool {
+	for _, val := range states[:len(states)-1] {
+		if val {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a dot file is only matched by a segment starting with a dot, unless dot files are included
+func (e *expander) visible(name, segment string) bool {
+	return e.dotfiles || !strings.HasPrefix(name, ".") || strings.HasPrefix(segment, ".")
+}
+
+// returns the matches sorted and without a leading slash, leaving out those inside a matched directory
+func outermost(found map[string]bool) []string {
+	var matches []string
+	for val := range found {
+		inside := false
+		for dir := path.Dir(strings.TrimSuffix(val, "/")); dir != "/"; dir = path.Dir(dir) {
+			if found[dir+"/"] {
+				inside = true
+				break
+			}
+		}
+
+		if !inside {
+			matches = append(matches, strings.TrimPrefix(val, "/"))
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+/*
+*	ExpandBraces() returns the alternatives of a pattern with braces, e.g. app/{lib,test}/*.js
+*	is app/lib/*.js and app/test/*.js. Braces nest, braces without a comma are kept as they are.
+ */
+func ExpandBraces(pattern string) []string {
+	depth, open := 0, -1
+	var commas []int
+	for i, c := range pattern {
+		switch c {
+		case '{':
+			if depth == 0 {
+				open, commas = i, nil
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				// not a list of alternatives, keep the braces and look further
+				var expanded []string
+				for _, val := range ExpandBraces(pattern[i+1:]) {
+					expanded = append(expanded, pattern[:i+1]+val)
+				}
+				return expanded
+			}
+
+			var expanded []string
+			start := open + 1
+			for _, end := range append(commas, i) {
+				for _, val := range ExpandBraces(pattern[:open] + pattern[start:end] + pattern[i+1:]) {
+					expanded = append(expanded, val)
+				}
+				start = end + 1
+			}
+			return expanded
+		}
+	}
+
+	return []string{pattern}
+}
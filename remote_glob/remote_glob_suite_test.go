@@ -0,0 +1,13 @@
+package remote_glob_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRemoteGlob(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RemoteGlob Suite")
+}
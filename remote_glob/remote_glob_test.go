@@ -0,0 +1,153 @@
+package remote_glob_test
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	. "github.com/ibmjstart/cf-download/remote_glob"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the listings of the fake app, directories missing from the map cannot be listed
+var app = map[string]string{
+	"/": "app/                                      -\n" +
+		"logs/                                     -\n" +
+		".profile.d/                               -\n",
+	"/app/": "server.js                                 2.0K\n" +
+		".env                                      64B\n" +
+		"lib/                                      -\n" +
+		"test/                                     -\n",
+	"/app/lib/": "util.js                                   1.0K\n" +
+		"util.css                                  512B\n" +
+		"deep/                                     -\n",
+	"/app/lib/deep/": "inner.js                                  12B\n",
+	"/app/test/":     "util_test.js                              300B\n",
+	"/logs/":         "app.log                                   1.0M\n",
+	"/.profile.d/":   "setup.sh                                  48B\n",
+}
+
+var _ = Describe("RemoteGlob", func() {
+	var expander Expander
+
+	BeforeEach(func() {
+		expander = NewExpander(dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(app), "glob", "0", false, false), 2, false)
+	})
+
+	Describe("Test Expand()", func() {
+		It("Should match wildcards in any segment", func() {
+			matches, err := expander.Expand("app/*/util*.js")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/util.js", "app/test/util_test.js"}))
+
+			matches, err = expander.Expand("app/*/")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/", "app/test/"}))
+		})
+
+		It("Should match any number of directories with **", func() {
+			matches, err := expander.Expand("**/*.js")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/deep/inner.js", "app/lib/util.js", "app/server.js", "app/test/util_test.js"}))
+
+			matches, err = expander.Expand("app/lib/**")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/deep/", "app/lib/util.css", "app/lib/util.js"}))
+		})
+
+		It("Should leave out matches inside a matched directory", func() {
+			matches, err := expander.Expand("app/**")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/", "app/server.js", "app/test/"}))
+
+			matches, err = expander.Expand("{app/lib/,app/lib/deep/inner.js,logs/*}")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/", "logs/app.log"}))
+		})
+
+		It("Should only list the directories the pattern can match in", func() {
+			var mu sync.Mutex
+			var listed []string
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetListingFunc(func(readPath string) (string, bool) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				listed = append(listed, readPath)
+				listing, ok := app[readPath]
+				return listing, ok
+			})
+			counting := NewExpander(dir_parser.NewParser(cmdExec, "glob", "0", false, false), 2, false)
+
+			_, err := counting.Expand("app/lib/*.js")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(listed).To(Equal([]string{"/app/lib/"}))
+
+			listed = nil
+			_, err = counting.Expand("app/**")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(listed).To(Equal([]string{"/app/"}))
+
+			listed = nil
+			_, err = counting.Expand("app/*/*.js")
+			Ω(err).NotTo(HaveOccurred())
+			sort.Strings(listed)
+			Ω(listed).To(Equal([]string{"/app/", "/app/lib/", "/app/test/"}))
+		})
+
+		It("Should match brace alternatives", func() {
+			matches, err := expander.Expand("app/{lib,test}/*.{js,css}")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/lib/util.css", "app/lib/util.js", "app/test/util_test.js"}))
+		})
+
+		It("Should only match dot files when asked to", func() {
+			matches, err := expander.Expand("app/*")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).NotTo(ContainElement("app/.env"))
+
+			matches, err = expander.Expand("app/.e*")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{"app/.env"}))
+
+			dotfiles := NewExpander(dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(app), "glob", "0", false, false), 2, true)
+			matches, err = dotfiles.Expand("**/*.sh")
+			Ω(err).NotTo(HaveOccurred())
+			Ω(matches).To(Equal([]string{".profile.d/setup.sh"}))
+		})
+
+		It("Should fail when a pattern matches nothing or is malformed", func() {
+			_, err := expander.Expand("app/*.py")
+			Ω(err).To(HaveOccurred())
+			Ω(err.Error()).To(ContainSubstring("app/*.py"))
+
+			_, err = expander.Expand("app/[a-")
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("Should fail when a directory cannot be listed", func() {
+			_, err := expander.Expand("tmp/*")
+			Ω(err).To(HaveOccurred())
+			Ω(err.Error()).To(ContainSubstring("/tmp/"))
+		})
+	})
+
+	Describe("Test Prefix()", func() {
+		It("Should return the directories before the first pattern", func() {
+			Ω(Prefix("app/lib/*.js")).To(Equal("app/lib/"))
+			Ω(Prefix("/app/*/util.js")).To(Equal("app/"))
+			Ω(Prefix("**/*.log")).To(Equal(""))
+			Ω(Prefix("{app,logs}/x")).To(Equal(""))
+		})
+	})
+
+	Describe("Test ExpandBraces()", func() {
+		It("Should expand nested alternatives and keep braces without a comma", func() {
+			Ω(ExpandBraces("a/{b,c{d,e}}/f")).To(Equal([]string{"a/b/f", "a/cd/f", "a/ce/f"}))
+			Ω(ExpandBraces("{x}/{y,z}")).To(Equal([]string{"{x}/y", "{x}/z"}))
+			Ω(ExpandBraces("plain")).To(Equal([]string{"plain"}))
+		})
+	})
+})
@@ -0,0 +1,439 @@
+package remote_grep
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/text_diff"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+type Grepper interface {
+	Grep(root string, filterList []string) []string
+	UsedSsh() bool
+	GetFailed() []string
+	SetInstanceManager(instances instance_manager.InstanceManager)
+}
+
+// the transports a search can use
+const (
+	// grep runs in the container through cf ssh
+	TransportSsh = "ssh"
+	// every candidate file is fetched with cf files and searched locally
+	TransportFiles = "files"
+)
+
+// the home directory of the app on the instance, the root of cf files paths
+const homeDir = "/home/vcap"
+
+// printed after the remote search, its absence means cf ssh did not run the search
+const doneMarker = "cf-download-grep-done"
+
+// the characters a backslash may escape in a pattern
+const escapable = ".[]()*+?{}|^$\\"
+
+// the character classes both grep -E and Go support inside brackets
+var classes = map[string]bool{
+	"alnum": true, "alpha": true, "blank": true, "cntrl": true, "digit": true, "graph": true,
+	"lower": true, "print": true, "punct": true, "space": true, "upper": true, "xdigit": true,
+}
+
+// files with these extensions are binary and not fetched to be searched
+var binaryExtensions = map[string]bool{
+	".jar": true, ".war": true, ".ear": true, ".class": true, ".zip": true, ".gz": true,
+	".tgz": true, ".tar": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+	".ico": true, ".pdf": true, ".so": true, ".dll": true, ".exe": true, ".woff": true,
+	".woff2": true, ".ttf": true, ".eot": true, ".pyc": true, ".o": true, ".a": true,
+}
+
+type grepper struct {
+	cmdExec   cmd_exec.CmdExec
+	parser    dir_parser.Parser
+	appName   string
+	instances instance_manager.InstanceManager
+	pattern   string
+	regexp    *regexp.Regexp
+	ignore    bool
+	context   int
+	match     filter.Predicate
+	transport string
+	usedSsh   bool
+	failed    []string
+	mu        sync.Mutex
+}
+
+/*
+*	NewGrepper() returns a grepper searching the files of an app instance for the extended
+*	regular expression 'pattern', printing 'context' lines around every match. Only the files
+*	'match' selects are searched. 'transport' is TransportSsh to try cf ssh first, or
+*	TransportFiles to always search with cf files. Patterns outside what CheckPattern() allows
+*	are rejected.
+ */
+func NewGrepper(cmdExec cmd_exec.CmdExec, appName, instance, pattern string, ignoreCase bool, context int, match filter.Predicate, transport string) (*grepper, error) {
+	if err := CheckPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	expr := pattern
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grepper{
+		cmdExec:   cmdExec,
+		parser:    dir_parser.NewParser(cmdExec, appName, instance, false, false),
+		appName:   appName,
+		instances: instance_manager.NewInstanceManager(cmdExec, appName, instance, false),
+		pattern:   pattern,
+		regexp:    re,
+		ignore:    ignoreCase,
+		context:   context,
+		match:     match,
+		transport: transport,
+	}, nil
+}
+
+/*
+*	SetInstanceManager() lets the search, through cf ssh or cf files, follow the instance selected
+*	by the given manager instead of the fixed instance passed to NewGrepper.
+ */
+func (g *grepper) SetInstanceManager(instances instance_manager.InstanceManager) {
+	g.instances = instances
+	g.parser.SetInstanceManager(instances)
+}
+
+/*
+*	CheckPattern() returns an error if 'pattern' uses syntax that grep -E in the container and
+*	the Go regular expressions of the cf files fallback read differently, so both find the same
+*	lines. Allowed is the POSIX extended syntax: . [ ] ( ) * + ? {n,m} | ^ $, a backslash before
+*	one of these characters and the classes such as [:digit:] inside brackets. Escapes such as
+*	\d or \w, a backslash inside brackets, (?...) groups, lazy quantifiers and {,n} are not.
+ */
+func CheckPattern(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		next := byte(0)
+		if i+1 < len(pattern) {
+			next = pattern[i+1]
+		}
+
+		switch pattern[i] {
+		case '\\':
+			if next != 0 && strings.IndexByte(escapable, next) < 0 {
+				return unsupported(pattern[i : i+2])
+			}
+			i++
+		case '[':
+			end, err := checkBracket(pattern, i)
+			if err != nil {
+				return err
+			}
+			i = end
+		case '(':
+			if next == '?' {
+				return unsupported("(?")
+			}
+		case '{':
+			if next == ',' {
+				return unsupported("{,")
+			}
+		case '*', '+', '?', '}':
+			if next == '?' {
+				return unsupported(pattern[i : i+2])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checks the bracket expression starting at 'start' and returns the index of its closing bracket
+func checkBracket(pattern string, start int) (int, error) {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	// a bracket right after the opening one is part of the list
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+
+	for ; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			return 0, unsupported("\\ inside [ ]")
+		case ']':
+			return i, nil
+		case '[':
+			if i+1 >= len(pattern) || strings.IndexByte(":.=", pattern[i+1]) < 0 {
+				continue
+			}
+			delim := pattern[i+1]
+			end := strings.Index(pattern[i+2:], string(delim)+"]")
+			if end < 0 {
+				continue
+			}
+			if delim != ':' || !classes[pattern[i+2:i+2+end]] {
+				return 0, unsupported(pattern[i : i+end+4])
+			}
+			i += end + 3
+		}
+	}
+
+	// not closed, compiling the pattern reports it
+	return len(pattern), nil
+}
+
+func unsupported(construct string) error {
+	return errors.New("'" + construct + "' is not supported, PATTERN is a POSIX extended regular expression")
+}
+
+/*
+*	Grep() searches the files below 'root', leaving out the paths on the filter list, and returns
+*	the output grep -rn would print: path:line:text for every matching line, path-line-text for
+*	context lines and -- between groups. Binary files are skipped. The search runs in the
+*	container if cf ssh is available for the app, otherwise the candidate files are fetched.
+ */
+func (g *grepper) Grep(root string, filterList []string) []string {
+	if g.transport == TransportSsh {
+		if lines, ok := g.pushDown(root, filterList); ok {
+			g.usedSsh = true
+			return lines
+		}
+	}
+
+	return g.fetch(root, filterList)
+}
+
+/*
+*	UsedSsh() reports whether the last search ran in the container.
+ */
+func (g *grepper) UsedSsh() bool {
+	return g.usedSsh
+}
+
+/*
+*	GetFailed() returns the directories and files that could not be listed or fetched.
+ */
+func (g *grepper) GetFailed() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sort.Strings(g.failed)
+	return append([]string(nil), g.failed...)
+}
+
+// runs grep in the container, reports false if cf ssh could not run it or the search failed
+func (g *grepper) pushDown(root string, filterList []string) ([]string, bool) {
+	output, _ := g.cmdExec.Ssh(g.appName, g.instances.Current(), SshCommand(root, filterList, g.pattern, g.ignore, g.context, g.match.Name))
+
+	lines := strings.Split(strings.TrimRight(string(output), "\r\n"), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[len(lines)-1]) != doneMarker {
+		return nil, false
+	}
+
+	var found []string
+	for _, val := range lines[:len(lines)-1] {
+		val = strings.TrimRight(val, "\r")
+		if strings.HasPrefix(val, "./") {
+			val = val[1:]
+		}
+		found = append(found, val)
+	}
+
+	return found, true
+}
+
+/*
+*	SshCommand() returns the shell command that searches the files below 'root' in the container
+*	with find and grep. Omitted paths are pruned, binary files are skipped by grep itself. The
+*	files are sorted by path, so the output comes in the order of the cf files fallback. The done
+*	marker is only printed if 'root' exists and grep reported no error, so neither is mistaken
+*	for a search without matches.
+ */
+func SshCommand(root string, filterList []string, pattern string, ignoreCase bool, context int, name string) string {
+	command := "cd " + homeDir + " || exit 1; [ -d " + quote("."+root) + " ] || exit 1; find " + quote("."+root)
+
+	var prunes []string
+	for _, val := range filterList {
+		prunes = append(prunes, "-path "+quote("."+val))
+	}
+	if len(prunes) > 0 {
+		command += " \\( " + strings.Join(prunes, " -o ") + " \\) -prune -o"
+	}
+
+	command += " -type f"
+	if name != "" {
+		command += " -name " + quote(name)
+	}
+
+	options := "-nIHE"
+	if ignoreCase {
+		options += "i"
+	}
+	if context > 0 {
+		options += " -C " + strconv.Itoa(context)
+	}
+
+	// grep exits with 1 if nothing matched and 2 on errors, xargs fails if any grep failed
+	search := "grep " + options + " -e " + quote(pattern) + " \"$@\" 2>/dev/null; [ $? -lt 2 ]"
+
+	return command + " -print0 2>/dev/null | LC_ALL=C sort -z | xargs -0 -r sh -c " + quote(search) + " sh || exit 1; echo " + doneMarker
+}
+
+// quotes 's' for a POSIX shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// walks the tree with cf files and searches every candidate file locally
+func (g *grepper) fetch(root string, filterList []string) []string {
+	var mu sync.Mutex
+	found := make(map[string][]string)
+
+	files := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < walker.DefaultWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for val := range files {
+				if lines := g.search(val); len(lines) > 0 {
+					mu.Lock()
+					found[val] = lines
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	w := walker.NewWalker(g.parser, walker.DefaultWorkers, walker.DefaultWorkers)
+	w.Walk(root, filterList, func(listing walker.Listing) bool {
+		if listing.Failed {
+			g.addFailed(listing.ReadPath)
+		}
+		for _, val := range listing.Files {
+			if IsCandidate(val.Name) && g.match.Match(val.Name, false, val.Size) {
+				files <- val.ReadPath
+			}
+		}
+		return true
+	})
+	close(files)
+	workers.Wait()
+
+	paths := make([]string, 0, len(found))
+	for val := range found {
+		paths = append(paths, val)
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, val := range paths {
+		if g.context > 0 && len(lines) > 0 {
+			lines = append(lines, "--")
+		}
+		lines = append(lines, found[val]...)
+	}
+
+	return lines
+}
+
+// fetches one file and searches it
+func (g *grepper) search(readPath string) []string {
+	instance := g.instances.Current()
+	output, _ := g.cmdExec.GetFile(g.appName, readPath, instance)
+
+	// retry on another instance if the current one has gone away
+	if !instance_manager.ResponseOK(output) {
+		if next, switched := g.instances.Failover(instance); switched {
+			output, _ = g.cmdExec.GetFile(g.appName, readPath, next)
+		}
+	}
+	if !instance_manager.ResponseOK(output) {
+		g.addFailed(readPath)
+		return nil
+	}
+
+	// cf files reports an empty file as having no files
+	file := strings.SplitAfterN(string(output), "\n", 3)
+	if len(file) < 3 || strings.Contains(file[2], "No files found") {
+		return nil
+	}
+
+	content := []byte(file[2])
+	if !text_diff.IsText(content) {
+		return nil
+	}
+
+	return MatchLines(readPath, content, g.regexp, g.context)
+}
+
+func (g *grepper) addFailed(readPath string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failed = append(g.failed, readPath)
+}
+
+/*
+*	IsCandidate() reports whether a file may hold text, judged by its extension.
+ */
+func IsCandidate(name string) bool {
+	return !binaryExtensions[strings.ToLower(path.Ext(name))]
+}
+
+/*
+*	MatchLines() returns the lines of 'content' matching 're' as grep -n prints them for the file
+*	'readPath', with 'context' lines before and after each match.
+ */
+func MatchLines(readPath string, content []byte, re *regexp.Regexp, context int) []string {
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+
+	var found []string
+	last := -1
+	for i, val := range lines {
+		if !re.Match(val) {
+			continue
+		}
+
+		// groups that do not touch are separated the way grep does
+		first := i - context
+		if first < 0 {
+			first = 0
+		}
+		if context > 0 && last >= 0 && first > last+1 {
+			found = append(found, "--")
+		}
+		if first <= last {
+			first = last + 1
+		}
+
+		for j := first; j < i; j++ {
+			found = append(found, readPath+"-"+strconv.Itoa(j+1)+"-"+string(lines[j]))
+		}
+		found = append(found, readPath+":"+strconv.Itoa(i+1)+":"+string(val))
+		last = i
+
+		// context after the match, unless it matches itself
+		for j := i + 1; j <= i+context && j < len(lines) && !re.Match(lines[j]); j++ {
+			found = append(found, readPath+"-"+strconv.Itoa(j+1)+"-"+string(lines[j]))
+			last = j
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,13 @@
+package remote_grep_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRemoteGrep(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RemoteGrep Suite")
+}
@@ -0,0 +1,238 @@
+package remote_grep_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	. "github.com/ibmjstart/cf-download/remote_grep"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RemoteGrep", func() {
+	Describe("Test MatchLines()", func() {
+		content := []byte("one\ntwo key=1\nthree\nfour\nfive\nsix key=2\nseven\n")
+
+		It("Should print matching lines with their numbers", func() {
+			Ω(MatchLines("/app/a.env", content, regexp.MustCompile("key="), 0)).To(Equal([]string{
+				"/app/a.env:2:two key=1",
+				"/app/a.env:6:six key=2",
+			}))
+		})
+
+		It("Should print context and separate groups that do not touch", func() {
+			Ω(MatchLines("/app/a.env", content, regexp.MustCompile("key="), 1)).To(Equal([]string{
+				"/app/a.env-1-one",
+				"/app/a.env:2:two key=1",
+				"/app/a.env-3-three",
+				"--",
+				"/app/a.env-5-five",
+				"/app/a.env:6:six key=2",
+				"/app/a.env-7-seven",
+			}))
+		})
+
+		It("Should join groups whose context touches", func() {
+			Ω(MatchLines("/a", content, regexp.MustCompile("key="), 2)).To(Equal([]string{
+				"/a-1-one", "/a:2:two key=1", "/a-3-three", "/a-4-four", "/a-5-five", "/a:6:six key=2", "/a-7-seven",
+			}))
+		})
+	})
+
+	Describe("Test IsCandidate()", func() {
+		It("Should skip binary files by extension", func() {
+			Ω(IsCandidate("server.js")).To(BeTrue())
+			Ω(IsCandidate("Dockerfile")).To(BeTrue())
+			Ω(IsCandidate("app.JAR")).To(BeFalse())
+			Ω(IsCandidate("logo.png")).To(BeFalse())
+		})
+	})
+
+	Describe("Test Grep()", func() {
+		var cmdExec cmd_exec_fake.FakeCmdExec
+
+		BeforeEach(func() {
+			cmdExec = cmd_exec_fake.NewCmdExec()
+		})
+
+		It("Should push the search down with cf ssh", func() {
+			cmdExec.SetSshOutput("./app/config.yml:3:db_host: localhost\n./app/lib/db.js:10:  host: 'localhost'\ncf-download-grep-done\n")
+
+			g, err := NewGrepper(cmdExec, "app", "0", "localhost", false, 0, filter.Predicate{}, TransportSsh)
+			Ω(err).To(BeNil())
+
+			Ω(g.Grep("/app/", []string{"/app/node_modules"})).To(Equal([]string{
+				"/app/config.yml:3:db_host: localhost",
+				"/app/lib/db.js:10:  host: 'localhost'",
+			}))
+			Ω(g.UsedSsh()).To(BeTrue())
+			Ω(cmdExec.GetSshCommand()).To(ContainSubstring("-path './app/node_modules'"))
+		})
+
+		It("Should fall back to cf files when cf ssh is not available", func() {
+			cmdExec.SetFakeDir(true)
+
+			g, err := NewGrepper(cmdExec, "app", "0", "ignored|HELLO|world", true, 0, filter.Predicate{}, TransportSsh)
+			Ω(err).To(BeNil())
+
+			lines := g.Grep("../downloader/testFiles/", []string{"../downloader/testFiles/ignore.go"})
+			Ω(g.UsedSsh()).To(BeFalse())
+			Ω(lines).To(Equal([]string{
+				"../downloader/testFiles/app_content/app.go:1:hello user",
+				"../downloader/testFiles/ignoreDir/hello.txt:1:World",
+				"../downloader/testFiles/notignored.go:1:not ignored",
+			}))
+			Ω(g.GetFailed()).To(BeEmpty())
+		})
+
+		It("Should move to a running instance when the current one has gone away", func() {
+			cmdExec.SetFakeDir(true)
+			cmdExec.SetAppStatus("OK\n\n     state     since                    cpu    memory          disk          details\n#0   crashed   2016-09-13 03:04:05 PM   0.0%   0 of 1G         0 of 1G\n#1   running   2016-09-13 01:00:00 PM   0.3%   120.5M of 1G    210M of 1G\n")
+			cmdExec.SetFailingInstance("0")
+
+			g, err := NewGrepper(cmdExec, "app", "0", "hello", true, 0, filter.Predicate{}, TransportFiles)
+			Ω(err).To(BeNil())
+			instances := instance_manager.NewInstanceManager(cmdExec, "app", "0", true)
+			g.SetInstanceManager(instances)
+
+			Ω(g.Grep("../downloader/testFiles/app_content/", nil)).To(Equal([]string{
+				"../downloader/testFiles/app_content/app.go:1:hello user",
+			}))
+			Ω(g.GetFailed()).To(BeEmpty())
+			Ω(instances.Current()).To(Equal("1"))
+		})
+
+		It("Should reject an invalid pattern", func() {
+			_, err := NewGrepper(cmdExec, "app", "0", "(", false, 0, filter.Predicate{}, TransportFiles)
+			Ω(err).To(HaveOccurred())
+
+			_, err = NewGrepper(cmdExec, "app", "0", "\\d+", false, 0, filter.Predicate{}, TransportSsh)
+			Ω(err).To(HaveOccurred())
+		})
+
+		/*
+		*	Runs the command cf ssh would run against a local directory and compares what the ssh
+		*	search finds with what the cf files fallback finds for the same pattern.
+		 */
+		It("Should find the same lines in the same order with and without cf ssh", func() {
+			dir, err := ioutil.TempDir("", "remote_grep")
+			Ω(err).To(BeNil())
+			defer os.RemoveAll(dir)
+
+			files := map[string]string{
+				"config.yml":        "db_host: localhost\nport: 5432\nuser: admin\n",
+				"lib/db.js":         "const a = 1\nHOST = 'x'\nport = 80\n// host\n",
+				"lib/b c.txt":       "nothing here\nport=8\n",
+				"Zeta.txt":          "no match\n",
+				"node_modules/x.js": "host\n",
+			}
+			for name, content := range files {
+				Ω(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+				Ω(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+			}
+
+			root := filepath.ToSlash(dir) + "/"
+			filterList := []string{root + "node_modules"}
+			pattern := "^(db_)?host|port[:= ]+[0-9]{2,}"
+
+			cmdExec.SetFakeDir(true)
+			fetched, err := NewGrepper(cmdExec, "app", "0", pattern, true, 1, filter.Predicate{}, TransportFiles)
+			Ω(err).To(BeNil())
+			want := fetched.Grep(root, filterList)
+
+			command := SshCommand(root, filterList, pattern, true, 1, "")
+			output, err := exec.Command("sh", "-c", strings.Replace(command, "cd /home/vcap", "cd /", 1)).Output()
+			Ω(err).To(BeNil())
+
+			sshExec := cmd_exec_fake.NewCmdExec()
+			sshExec.SetSshOutput(string(output))
+			pushed, err := NewGrepper(sshExec, "app", "0", pattern, true, 1, filter.Predicate{}, TransportSsh)
+			Ω(err).To(BeNil())
+
+			Ω(pushed.Grep(root, filterList)).To(Equal(want))
+			Ω(pushed.UsedSsh()).To(BeTrue())
+			Ω(want).To(Equal([]string{
+				root + "config.yml:1:db_host: localhost",
+				root + "config.yml:2:port: 5432",
+				root + "config.yml-3-user: admin",
+				"--",
+				root + "lib/db.js-1-const a = 1",
+				root + "lib/db.js:2:HOST = 'x'",
+				root + "lib/db.js:3:port = 80",
+				root + "lib/db.js-4-// host",
+			}))
+		})
+	})
+
+	Describe("Test CheckPattern()", func() {
+		It("Should accept POSIX extended regular expressions", func() {
+			for _, val := range []string{"db_host|DB_URL", "^a.*b$", "x{2,3}", "[]a-z[:digit:]]+", "[^/]", "\\.\\(\\)\\[\\\\", "a+b?c*"} {
+				Ω(CheckPattern(val)).To(Succeed(), val)
+			}
+		})
+
+		It("Should reject syntax grep -E and Go read differently", func() {
+			for _, val := range []string{"\\d+", "\\bword", "(a)\\1", "[\\d]", "(?i)a", "a*?", "a+?", "a{,3}", "[[:word:]]", "[[.a.]]"} {
+				Ω(CheckPattern(val)).To(HaveOccurred(), val)
+			}
+		})
+	})
+
+	Describe("Test SshCommand()", func() {
+		var dir string
+
+		// runs the command in a local directory standing in for the home directory of the app
+		run := func(command string) (string, error) {
+			output, err := exec.Command("sh", "-c", strings.Replace(command, "cd /home/vcap", "cd "+dir, 1)).Output()
+			return string(output), err
+		}
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "remote_grep")
+			os.MkdirAll(filepath.Join(dir, "app", "node_modules"), 0755)
+			ioutil.WriteFile(filepath.Join(dir, "app", "a.js"), []byte("it's here\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "app", "node_modules", "b.js"), []byte("it's omitted\n"), 0644)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("Should quote the pattern and find the same files as the fallback", func() {
+			command := SshCommand("/", []string{"/app/node_modules"}, "it's", true, 2, "*.js")
+
+			Ω(command).To(HavePrefix("cd /home/vcap || exit 1; [ -d './' ] || exit 1; find './' \\( -path './app/node_modules' \\) -prune -o -type f -name '*.js' -print0 2>/dev/null | LC_ALL=C sort -z | xargs"))
+			Ω(strings.HasSuffix(command, "; echo cf-download-grep-done")).To(BeTrue())
+
+			// the quoting survives a real shell
+			output, err := run(command)
+			Ω(err).To(BeNil())
+			Ω(output).To(Equal("./app/a.js:1:it's here\ncf-download-grep-done\n"))
+		})
+
+		It("Should print the done marker when nothing matched", func() {
+			output, err := run(SshCommand("/app/", nil, "missing", false, 0, ""))
+			Ω(err).To(BeNil())
+			Ω(output).To(Equal("cf-download-grep-done\n"))
+		})
+
+		It("Should fail without the done marker if grep reports an error", func() {
+			output, err := run(SshCommand("/app/", nil, "(", false, 0, ""))
+			Ω(err).To(HaveOccurred())
+			Ω(output).To(BeEmpty())
+		})
+
+		It("Should fail without the done marker if the root does not exist", func() {
+			output, err := run(SshCommand("/nowhere/", nil, "it", false, 0, ""))
+			Ω(err).To(HaveOccurred())
+			Ω(output).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package remote_tree
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+)
+
+/*
+*	WriteTree() prints 'root' the way tree does, with the listed size after every entry and the
+*	total below every directory.
+ */
+func WriteTree(out io.Writer, root *Node) {
+	fmt.Fprintln(out, root.Path+describe(root))
+	writeChildren(out, root, "")
+}
+
+func writeChildren(out io.Writer, node *Node, indent string) {
+	for i, val := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		fmt.Fprintln(out, indent+branch+val.Name+describe(val))
+		writeChildren(out, val, indent+next)
+	}
+}
+
+// the size column of a node, or why it is missing
+func describe(node *Node) string {
+	switch {
+	case node.Failed && node.Dir:
+		return "  [not listed]"
+	case node.Failed:
+		return "  [not found]"
+	case node.Truncated:
+		return "  [...]"
+	case node.Size < 0:
+		return "  -"
+	}
+
+	return "  " + dir_parser.FormatSize(node.Size)
+}
+
+/*
+*	WriteCSV() writes one row per node of every tree, parents before their children, below a
+*	header of path, type, size in bytes and status. An unknown size is left empty.
+ */
+func WriteCSV(out io.Writer, roots []*Node) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"path", "type", "size", "status"})
+
+	for _, root := range roots {
+		root.Walk(func(node *Node) {
+			kind, size, status := "file", "", ""
+			if node.Dir {
+				kind = "dir"
+			}
+			if node.Size >= 0 {
+				size = strconv.FormatInt(node.Size, 10)
+			}
+			if node.Failed {
+				status = "failed"
+			} else if node.Truncated {
+				status = "truncated"
+			}
+
+			w.Write([]string{node.Path, kind, size, status})
+		})
+	}
+
+	w.Flush()
+	return w.Error()
+}
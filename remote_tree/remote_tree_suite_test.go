@@ -0,0 +1,13 @@
+package remote_tree_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRemoteTree(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RemoteTree Suite")
+}
@@ -0,0 +1,116 @@
+package remote_tree_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	. "github.com/ibmjstart/cf-download/remote_tree"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the listings of the fake app, directories missing from the map cannot be listed
+var app = map[string]string{
+	"/": "app/                                      -\n" +
+		"logs/                                     -\n" +
+		"staging_info.yml                          136B\n",
+	"/app/": "server.js                                 2.0K\n" +
+		"lib/                                      -\n",
+	"/app/lib/": "util.js                                   1.0K\n" +
+		"my  notes.txt                             24B\n",
+}
+
+var _ = Describe("RemoteTree", func() {
+	var lister TreeLister
+
+	BeforeEach(func() {
+		lister = NewTreeLister(dir_parser.NewParser(cmd_exec_fake.NewListingCmdExec(app), "tree", "0", false, false), 2)
+	})
+
+	Describe("Test List()", func() {
+		It("Should list the whole tree with the totals of every directory", func() {
+			root := lister.List("/", nil, 0)
+
+			Ω(root.Size).To(Equal(int64(136 + 2048 + 1024 + 24)))
+			Ω(root.Children).To(HaveLen(3))
+			Ω(root.Children[0].Path).To(Equal("/app/"))
+			Ω(root.Children[0].Size).To(Equal(int64(2048 + 1024 + 24)))
+			Ω(root.Children[1].Failed).To(BeTrue())
+			Ω(root.Children[1].Size).To(Equal(int64(-1)))
+
+			dirs, files := root.Counts()
+			Ω(dirs).To(Equal(3))
+			Ω(files).To(Equal(4))
+		})
+
+		It("Should stop at the depth limit", func() {
+			root := lister.List("/app/", nil, 1)
+
+			Ω(root.Children).To(HaveLen(2))
+			Ω(root.Children[0].Name).To(Equal("lib/"))
+			Ω(root.Children[0].Truncated).To(BeTrue())
+			Ω(root.Children[0].Children).To(BeEmpty())
+			Ω(root.Size).To(Equal(int64(2048)))
+		})
+
+		It("Should leave out omitted paths", func() {
+			root := lister.List("/", []string{"/app/lib", "/logs"}, 0)
+
+			var paths []string
+			root.Walk(func(node *Node) {
+				paths = append(paths, node.Path)
+			})
+			Ω(paths).To(Equal([]string{"/", "/app/", "/app/server.js", "/staging_info.yml"}))
+		})
+
+		It("Should look up a file in its directory", func() {
+			file := lister.List("/app/lib/my  notes.txt", nil, 0)
+			Ω(file.Dir).To(BeFalse())
+			Ω(file.Size).To(Equal(int64(24)))
+
+			missing := lister.List("/app/none.js", nil, 0)
+			Ω(missing.Failed).To(BeTrue())
+		})
+	})
+
+	Describe("Test output formats", func() {
+		It("Should print a tree with sizes", func() {
+			var out bytes.Buffer
+			WriteTree(&out, lister.List("/app/", nil, 0))
+
+			Ω(out.String()).To(Equal("/app/  3.0K\n" +
+				"├── lib/  1.0K\n" +
+				"│   ├── my  notes.txt  24B\n" +
+				"│   └── util.js  1.0K\n" +
+				"└── server.js  2.0K\n"))
+		})
+
+		It("Should write one CSV row per entry", func() {
+			var out bytes.Buffer
+			err := WriteCSV(&out, []*Node{lister.List("/", nil, 1)})
+			Ω(err).ToNot(HaveOccurred())
+
+			Ω(strings.Split(out.String(), "\n")).To(Equal([]string{
+				"path,type,size,status",
+				"/,dir,136,",
+				"/app/,dir,,truncated",
+				"/logs/,dir,,truncated",
+				"/staging_info.yml,file,136,",
+				"",
+			}))
+		})
+
+		It("Should round trip through JSON", func() {
+			root := lister.List("/app/", nil, 0)
+			output, err := json.Marshal(root)
+			Ω(err).ToNot(HaveOccurred())
+
+			var read Node
+			Ω(json.Unmarshal(output, &read)).To(Succeed())
+			Ω(&read).To(Equal(root))
+		})
+	})
+})
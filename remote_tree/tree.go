@@ -0,0 +1,163 @@
+package remote_tree
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/walker"
+)
+
+type TreeLister interface {
+	List(root string, filterList []string, depth int) *Node
+}
+
+/*
+*	A file or directory of the remote tree. Path is the app path, directories end in a slash.
+*	Size is the listed size of a file, or the total of the files listed below a directory; -1 if
+*	unknown. Failed is set if a directory could not be listed or a file was not found, Truncated
+*	if a directory was not listed because it lies below the depth limit.
+ */
+type Node struct {
+	Name      string  `json:"name"`
+	Path      string  `json:"path"`
+	Dir       bool    `json:"dir"`
+	Size      int64   `json:"size"`
+	Failed    bool    `json:"failed,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"`
+	Children  []*Node `json:"children,omitempty"`
+}
+
+type treeLister struct {
+	parser  dir_parser.Parser
+	workers int
+}
+
+/*
+*	NewTreeLister() returns a lister that builds trees with 'parser', listing 'workers'
+*	directories at the same time.
+ */
+func NewTreeLister(parser dir_parser.Parser, workers int) *treeLister {
+	return &treeLister{
+		parser:  parser,
+		workers: workers,
+	}
+}
+
+/*
+*	List() returns the tree below 'root', leaving out the paths on the filter list. A 'depth' of
+*	1 lists only the entries of 'root', 0 lists everything. A root without a trailing slash is a
+*	file, it is looked up in the listing of its directory.
+ */
+func (t *treeLister) List(root string, filterList []string, depth int) *Node {
+	if !strings.HasSuffix(root, "/") {
+		return t.file(root)
+	}
+
+	var mu sync.Mutex
+	listings := make(map[string]walker.Listing)
+
+	w := walker.NewWalker(t.parser, t.workers, t.workers)
+	w.Walk(root, filterList, func(listing walker.Listing) bool {
+		mu.Lock()
+		listings[listing.ReadPath] = listing
+		mu.Unlock()
+
+		return depth <= 0 || level(root, listing.ReadPath) < depth
+	})
+
+	return build(root, listings)
+}
+
+// looks up a single file in its directory's listing
+func (t *treeLister) file(readPath string) *Node {
+	dir := path.Dir(readPath)
+	if dir != "/" {
+		dir += "/"
+	}
+
+	node := &Node{Name: path.Base(readPath), Path: readPath, Size: -1, Failed: true}
+	entries, _ := t.parser.ListDir(dir)
+	for _, val := range entries {
+		if val.Name == node.Name && val.Kind == dir_parser.File {
+			node.Size, node.Failed = val.Size, false
+		}
+	}
+
+	return node
+}
+
+// assembles the node of 'readPath' from the walked listings, totalling the sizes below it
+func build(readPath string, listings map[string]walker.Listing) *Node {
+	name := path.Base(readPath) + "/"
+	if readPath == "/" {
+		name = "/"
+	}
+	node := &Node{Name: name, Path: readPath, Dir: true}
+
+	listing, listed := listings[readPath]
+	if !listed {
+		node.Size, node.Truncated = -1, true
+		return node
+	}
+	if listing.Failed {
+		node.Size, node.Failed = -1, true
+		return node
+	}
+
+	for _, val := range listing.Dirs {
+		child := build(readPath+val, listings)
+		if child.Size > 0 {
+			node.Size += child.Size
+		}
+		node.Children = append(node.Children, child)
+	}
+	for _, val := range listing.Files {
+		if val.Size > 0 {
+			node.Size += val.Size
+		}
+		node.Children = append(node.Children, &Node{Name: val.Name, Path: val.ReadPath, Size: val.Size})
+	}
+
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+
+	return node
+}
+
+// the number of directories 'readPath' lies below 'root', the entries of 'root' are on level 1
+func level(root, readPath string) int {
+	return strings.Count(strings.TrimPrefix(readPath, root), "/") + 1
+}
+
+/*
+*	Walk() calls 'visit' for 'node' and every node below it, parents before their children.
+ */
+func (n *Node) Walk(visit func(node *Node)) {
+	visit(n)
+	for _, val := range n.Children {
+		val.Walk(visit)
+	}
+}
+
+/*
+*	Counts() returns the number of directories and files below the node.
+ */
+func (n *Node) Counts() (int, int) {
+	dirs, files := 0, 0
+	n.Walk(func(node *Node) {
+		if node == n {
+			return
+		}
+		if node.Dir {
+			dirs++
+		} else {
+			files++
+		}
+	})
+
+	return dirs, files
+}
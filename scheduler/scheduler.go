@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sync"
+)
+
+type Scheduler interface {
+	Submit(files []File)
+	SetPolicy(policy Policy)
+	Pending() int
+	Close()
+}
+
+// the order in which queued files are handed to the workers
+type Policy int
+
+const (
+	// large files first, tiny files grouped into batches
+	Balanced Policy = iota
+	// strictly by listed size, largest first
+	LargestFirst
+	// strictly by listed size, smallest first, for quick partial results
+	SmallestFirst
+)
+
+// a file to download, Size is the listed size in bytes or -1 if it is unknown
+type File struct {
+	ReadPath  string
+	WritePath string
+	Size      int64
+}
+
+// number of files downloaded at the same time
+const DefaultWorkers = 20
+
+// number of files that may wait for a worker before Submit() blocks
+const DefaultQueueSize = 1000
+
+// with the Balanced policy files up to smallFileSize are batched, a batch is closed once it
+// holds batchFiles files or batchBytes bytes
+const (
+	smallFileSize = 64 << 10
+	batchFiles    = 16
+	batchBytes    = 256 << 10
+)
+
+type scheduler struct {
+	run       func(File)
+	workers   int
+	queueSize int
+	running   int
+	closed    bool
+	policy    Policy
+	queue     jobQueue
+	pending   int
+	seq       int
+	mu        sync.Mutex
+	cond      *sync.Cond
+	space     *sync.Cond
+}
+
+/*
+*	NewScheduler() returns a scheduler calling 'run' for every submitted file on 'workers'
+*	goroutines. At most 'queueSize' files wait for a worker, the size based ordering applies
+*	to the files waiting at any one time.
+ */
+func NewScheduler(workers, queueSize int, run func(File)) *scheduler {
+	s := &scheduler{
+		run:       run,
+		workers:   workers,
+		queueSize: queueSize,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.space = sync.NewCond(&s.mu)
+	s.queue.less = s.less
+
+	return s
+}
+
+/*
+*	Submit() queues the files of one directory listing. Under the Balanced policy small files are
+*	grouped into batches that a single worker downloads one after another, so they do not hold up
+*	large files that were queued at the same time. Workers are started on the first call, and again
+*	after Close(). Submit blocks while the queue is full, which slows the caller down to the pace of
+*	the downloads.
+ */
+func (s *scheduler) Submit(files []File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = false
+	for s.running < s.workers {
+		s.running++
+		go s.work()
+	}
+
+	var batch *job
+	for _, val := range files {
+		if s.policy != Balanced || val.Size > smallFileSize {
+			s.push(&job{files: []File{val}, size: val.Size})
+			continue
+		}
+
+		if batch == nil {
+			batch = &job{}
+		}
+		batch.files = append(batch.files, val)
+		if val.Size > 0 {
+			batch.size += val.Size
+		}
+
+		if len(batch.files) >= batchFiles || batch.size >= batchBytes {
+			s.push(batch)
+			batch = nil
+		}
+	}
+	if batch != nil {
+		s.push(batch)
+	}
+}
+
+func (s *scheduler) SetPolicy(policy Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policy = policy
+	heap.Init(&s.queue)
+}
+
+/*
+*	Pending() returns the number of files waiting for a worker.
+ */
+func (s *scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending
+}
+
+/*
+*	Close() lets the workers exit once the queued files are downloaded.
+ */
+func (s *scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// must be called with the lock held, waits for room in the queue first
+func (s *scheduler) push(j *job) {
+	for s.queueSize > 0 && s.pending > 0 && s.pending+len(j.files) > s.queueSize {
+		s.space.Wait()
+	}
+
+	j.seq = s.seq
+	s.seq++
+	s.pending += len(j.files)
+	heap.Push(&s.queue, j)
+	s.cond.Signal()
+}
+
+// each worker takes the job with the highest priority and downloads its files in order, until
+// the queue is empty after Close()
+func (s *scheduler) work() {
+	for {
+		s.mu.Lock()
+		for s.queue.Len() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.queue.Len() == 0 {
+			s.running--
+			s.mu.Unlock()
+			return
+		}
+		j := heap.Pop(&s.queue).(*job)
+		s.pending -= len(j.files)
+		s.space.Broadcast()
+		s.mu.Unlock()
+
+		for _, val := range j.files {
+			s.run(val)
+		}
+	}
+}
+
+func (s *scheduler) less(a, b *job) bool {
+	if a.size != b.size {
+		if s.policy == SmallestFirst {
+			return a.size < b.size
+		}
+		return a.size > b.size
+	}
+
+	// equal sizes keep the order they were queued in
+	return a.seq < b.seq
+}
+
+type job struct {
+	files []File
+	size  int64
+	seq   int
+}
+
+// implements heap.Interface
+type jobQueue struct {
+	jobs []*job
+	less func(a, b *job) bool
+}
+
+func (q jobQueue) Len() int           { return len(q.jobs) }
+func (q jobQueue) Less(i, j int) bool { return q.less(q.jobs[i], q.jobs[j]) }
+func (q jobQueue) Swap(i, j int)      { q.jobs[i], q.jobs[j] = q.jobs[j], q.jobs[i] }
+
+func (q *jobQueue) Push(x interface{}) {
+	q.jobs = append(q.jobs, x.(*job))
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := q.jobs
+	n := len(old)
+	item := old[n-1]
+	q.jobs = old[:n-1]
+
+	return item
+}
@@ -0,0 +1,125 @@
+package scheduler_test
+
+import (
+	"runtime"
+	"sync"
+
+	. "github.com/ibmjstart/cf-download/scheduler"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []string
+		s     Scheduler
+	)
+
+	files := []File{
+		{ReadPath: "/app/a.txt", Size: 100},
+		{ReadPath: "/app/big.log", Size: 500 << 20},
+		{ReadPath: "/app/b.txt", Size: 200},
+		{ReadPath: "/app/medium.jar", Size: 3 << 20},
+		{ReadPath: "/app/c.txt", Size: 50},
+	}
+
+	BeforeEach(func() {
+		order = nil
+		// a single worker makes the download order deterministic
+		s = NewScheduler(1, 100, func(file File) {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, file.ReadPath)
+			mu.Unlock()
+		})
+	})
+
+	Describe("Test Balanced policy", func() {
+		It("should start large files first and keep small files together", func() {
+			wg.Add(len(files))
+			s.Submit(files)
+			wg.Wait()
+
+			Ω(order).To(Equal([]string{"/app/big.log", "/app/medium.jar", "/app/a.txt", "/app/b.txt", "/app/c.txt"}))
+		})
+	})
+
+	Describe("Test LargestFirst policy", func() {
+		It("should download strictly by size, largest first", func() {
+			s.SetPolicy(LargestFirst)
+			wg.Add(len(files))
+			s.Submit(files)
+			wg.Wait()
+
+			Ω(order).To(Equal([]string{"/app/big.log", "/app/medium.jar", "/app/b.txt", "/app/a.txt", "/app/c.txt"}))
+		})
+	})
+
+	Describe("Test SmallestFirst policy", func() {
+		It("should download strictly by size, smallest first", func() {
+			s.SetPolicy(SmallestFirst)
+			wg.Add(len(files))
+			s.Submit(files)
+			wg.Wait()
+
+			Ω(order).To(Equal([]string{"/app/c.txt", "/app/a.txt", "/app/b.txt", "/app/medium.jar", "/app/big.log"}))
+			Ω(s.Pending()).To(Equal(0))
+		})
+	})
+
+	Describe("Test queue size", func() {
+		It("should block Submit while the queue is full", func() {
+			release := make(chan bool)
+			maxPending := 0
+			s = NewScheduler(2, 10, func(file File) {
+				defer wg.Done()
+				<-release
+			})
+			s.SetPolicy(LargestFirst)
+
+			var many []File
+			for i := 0; i < 100; i++ {
+				many = append(many, File{ReadPath: "/app/file", Size: int64(i)})
+			}
+
+			go func() {
+				for i := 0; i < 100; i++ {
+					if pending := s.Pending(); pending > maxPending {
+						maxPending = pending
+					}
+					release <- true
+				}
+			}()
+
+			wg.Add(len(many))
+			s.Submit(many)
+			wg.Wait()
+
+			Ω(maxPending).To(BeNumerically("<=", 10))
+			Ω(s.Pending()).To(Equal(0))
+		})
+	})
+
+	Describe("Test Close()", func() {
+		It("should stop the workers and start them again on the next Submit", func() {
+			before := runtime.NumGoroutine()
+			s = NewScheduler(4, 100, func(file File) {
+				defer wg.Done()
+			})
+
+			wg.Add(len(files))
+			s.Submit(files)
+			wg.Wait()
+			s.Close()
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+
+			wg.Add(len(files))
+			s.Submit(files)
+			wg.Wait()
+			s.Close()
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+		})
+	})
+})
@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+*	Recorder collects the files of one download into a new snapshot. Add() may be called from
+*	several goroutines at once.
+ */
+type Recorder interface {
+	Add(readPath string, content []byte) error
+	Finish(paths []string, complete bool) (Manifest, error)
+	GetNewObjects() (int, int64)
+}
+
+type recorder struct {
+	store      Store
+	manifest   Manifest
+	newObjects int
+	newBytes   int64
+	mu         sync.Mutex
+}
+
+/*
+*	NewRecorder() starts a snapshot of the given app instance. Its id is the start time followed by
+*	the app and instance, e.g. 20261019T150405Z-my-app-0.
+ */
+func NewRecorder(store Store, appName, instance string) *recorder {
+	created := time.Now().UTC()
+
+	return &recorder{
+		store: store,
+		manifest: Manifest{
+			ID:       created.Format("20060102T150405Z") + "-" + appName + "-" + instance,
+			App:      appName,
+			Instance: instance,
+			Created:  created,
+		},
+	}
+}
+
+/*
+*	Add() stores the content of the file at 'readPath' in the app.
+ */
+func (r *recorder) Add(readPath string, content []byte) error {
+	hash, added, err := r.store.Put(content)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.manifest.Files = append(r.manifest.Files, Entry{Path: readPath, Hash: hash, Size: int64(len(content))})
+	if added {
+		r.newObjects++
+		r.newBytes += int64(len(content))
+	}
+
+	return nil
+}
+
+/*
+*	Finish() saves the manifest of the snapshot. 'complete' records whether the download finished
+*	without failures.
+ */
+func (r *recorder) Finish(paths []string, complete bool) (Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.manifest.Paths = paths
+	r.manifest.Complete = complete
+	err := r.store.Save(r.manifest)
+
+	return r.manifest, err
+}
+
+/*
+*	GetNewObjects() returns the number and total size of the objects this snapshot added to the
+*	store, everything else was already there.
+ */
+func (r *recorder) GetNewObjects() (int, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.newObjects, r.newBytes
+}
@@ -0,0 +1,327 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+*	Store keeps downloaded files in a content addressed object store, one object per distinct
+*	content whatever app, instance or run it came from, and a manifest per snapshot mapping the
+*	snapshot's paths to objects.
+ */
+type Store interface {
+	Put(content []byte) (string, bool, error)
+	Read(hash string) ([]byte, error)
+	Save(manifest Manifest) error
+	Load(id string) (Manifest, error)
+	List() ([]Manifest, error)
+	Restore(id, dir string) error
+	Prune(retention Retention, dryRun bool) ([]string, int, error)
+}
+
+// one snapshot, Files are sorted by path
+type Manifest struct {
+	ID       string    `json:"id"`
+	App      string    `json:"app"`
+	Instance string    `json:"instance"`
+	Paths    []string  `json:"paths"`
+	Created  time.Time `json:"created"`
+	Complete bool      `json:"complete"`
+	Files    []Entry   `json:"files"`
+}
+
+// a file of a snapshot, Path is the file's path in the app
+type Entry struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+/*
+*	Retention decides which snapshots Prune() keeps: the KeepLast newest snapshots of every app
+*	and instance, and every snapshot younger than KeepWithin. A value of 0 does not keep any.
+ */
+type Retention struct {
+	KeepLast   int
+	KeepWithin time.Duration
+}
+
+/*
+*	ParseRetention() reads a --keep-within value such as 30d, 12h or 2w.
+ */
+func ParseRetention(str string) (time.Duration, error) {
+	if str == "" {
+		return 0, nil
+	}
+
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if unit, ok := units[str[len(str)-1]]; ok {
+		count, err := strconv.Atoi(str[:len(str)-1])
+		if err != nil || count < 0 {
+			return 0, errors.New("invalid duration '" + str + "', expected e.g. 30d, 2w or 12h")
+		}
+		return time.Duration(count) * unit, nil
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil || duration < 0 {
+		return 0, errors.New("invalid duration '" + str + "', expected e.g. 30d, 2w or 12h")
+	}
+
+	return duration, nil
+}
+
+// objects written this recently are never pruned, a running snapshot may not have saved its
+// manifest yet
+const pruneGrace = 24 * time.Hour
+
+type store struct {
+	dir string
+}
+
+/*
+*	NewStore() returns the store kept in 'dir', objects are kept below dir/objects and manifests
+*	in dir/snapshots. The directories are created as needed.
+ */
+func NewStore(dir string) *store {
+	return &store{dir: dir}
+}
+
+/*
+*	Put() adds 'content' to the store and returns its hash. The boolean reports whether the
+*	content was new; content already in the store costs no extra space.
+ */
+func (s *store) Put(content []byte) (string, bool, error) {
+	hash := Hash(content)
+	path := s.objectPath(hash)
+
+	// mark the object as used, so a prune running at the same time keeps it
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return hash, false, nil
+	}
+
+	if err := writeAtomic(path, content); err != nil {
+		return "", false, err
+	}
+
+	return hash, true, nil
+}
+
+/*
+*	Read() returns the content stored under 'hash', after checking it still matches the hash.
+ */
+func (s *store) Read(hash string) ([]byte, error) {
+	content, err := ioutil.ReadFile(s.objectPath(hash))
+	if err != nil {
+		return nil, errors.New("object " + hash + " is missing from the store")
+	}
+	if Hash(content) != hash {
+		return nil, errors.New("object " + hash + " is corrupt")
+	}
+
+	return content, nil
+}
+
+/*
+*	Hash() returns the hash content is stored under.
+ */
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+*	Save() writes the manifest of a snapshot.
+ */
+func (s *store) Save(manifest Manifest) error {
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(s.manifestPath(manifest.ID), content)
+}
+
+func (s *store) Load(id string) (Manifest, error) {
+	var manifest Manifest
+
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return manifest, errors.New("invalid snapshot id '" + id + "'")
+	}
+
+	content, err := ioutil.ReadFile(s.manifestPath(id))
+	if err != nil {
+		return manifest, errors.New("snapshot '" + id + "' not found in " + s.dir)
+	}
+
+	err = json.Unmarshal(content, &manifest)
+	return manifest, err
+}
+
+/*
+*	List() returns every snapshot in the store, oldest first.
+ */
+func (s *store) List() ([]Manifest, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "snapshots"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []Manifest
+	for _, val := range infos {
+		if !strings.HasSuffix(val.Name(), ".json") {
+			continue
+		}
+
+		manifest, err := s.Load(strings.TrimSuffix(val.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool { return manifests[i].Created.Before(manifests[j].Created) })
+	return manifests, nil
+}
+
+/*
+*	Restore() writes the files of snapshot 'id' below 'dir', at their path in the app. Every object
+*	is checked against its hash and nothing is written outside of 'dir'.
+ */
+func (s *store) Restore(id, dir string) error {
+	manifest, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+
+	for _, val := range manifest.Files {
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(val.Path, "/")))
+		if rel, err := filepath.Rel(dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return errors.New("refusing to restore '" + val.Path + "' outside of " + dir)
+		}
+
+		content, err := s.Read(val.Hash)
+		if err != nil {
+			return errors.New("cannot restore '" + val.Path + "': " + err.Error())
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+*	Prune() removes the snapshots 'retention' does not keep, then the objects no remaining snapshot
+*	refers to. It returns the ids of the removed snapshots and the number of removed objects. On a
+*	dry run nothing is removed, and the objects that would be freed are counted.
+ */
+func (s *store) Prune(retention Retention, dryRun bool) ([]string, int, error) {
+	if retention.KeepLast <= 0 && retention.KeepWithin <= 0 {
+		return nil, 0, errors.New("a retention policy is required, refusing to remove every snapshot")
+	}
+
+	manifests, err := s.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// newest first, so the first KeepLast of each app and instance are kept
+	kept := make(map[string]int)
+	referenced := make(map[string]bool)
+	var removed []string
+	for i := len(manifests) - 1; i >= 0; i-- {
+		manifest := manifests[i]
+		key := manifest.App + ":" + manifest.Instance
+
+		if kept[key] < retention.KeepLast || time.Since(manifest.Created) < retention.KeepWithin {
+			kept[key]++
+			for _, val := range manifest.Files {
+				referenced[val.Hash] = true
+			}
+			continue
+		}
+
+		removed = append(removed, manifest.ID)
+		if !dryRun {
+			if err := os.Remove(s.manifestPath(manifest.ID)); err != nil {
+				return removed, 0, err
+			}
+		}
+	}
+
+	objects := 0
+	err = filepath.Walk(filepath.Join(s.dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || referenced[info.Name()] || time.Since(info.ModTime()) < pruneGrace {
+			return nil
+		}
+
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		objects++
+		return nil
+	})
+
+	return removed, objects, err
+}
+
+// objects are spread over directories named by the first two characters of their hash
+func (s *store) objectPath(hash string) string {
+	if len(hash) < 2 {
+		hash = "00" + hash
+	}
+
+	return filepath.Join(s.dir, "objects", hash[:2], hash)
+}
+
+func (s *store) manifestPath(id string) string {
+	return filepath.Join(s.dir, "snapshots", id+".json")
+}
+
+// writes a file under a temporary name first, so it is never seen half written
+func writeAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,226 @@
+package snapshot_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/ibmjstart/cf-download/snapshot"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "snapshot-test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// saves a snapshot of one file created 'age' ago
+	save := func(store Store, id, instance, content string, age time.Duration) string {
+		hash, _, err := store.Put([]byte(content))
+		Expect(err).To(BeNil())
+		Expect(store.Save(Manifest{ID: id, App: "app", Instance: instance, Created: time.Now().Add(-age), Complete: true,
+			Files: []Entry{{Path: "/app/" + id, Hash: hash, Size: int64(len(content))}}})).To(BeNil())
+		return hash
+	}
+
+	// backdates an object so it is past the prune grace period
+	age := func(hash string) {
+		old := time.Now().Add(-48 * time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, "store", "objects", hash[:2], hash), old, old)).To(BeNil())
+	}
+
+	Describe("Put", func() {
+		It("stores identical content once", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+
+			hash, added, err := store.Put([]byte("hello"))
+			Expect(err).To(BeNil())
+			Expect(added).To(BeTrue())
+			Expect(hash).To(Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"))
+
+			again, added, err := store.Put([]byte("hello"))
+			Expect(err).To(BeNil())
+			Expect(added).To(BeFalse())
+			Expect(again).To(Equal(hash))
+
+			objects, _ := filepath.Glob(filepath.Join(dir, "store", "objects", "*", "*"))
+			Expect(objects).To(HaveLen(1))
+		})
+	})
+
+	Describe("Read", func() {
+		It("returns stored content and detects corruption", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			hash, _, _ := store.Put([]byte("hello"))
+			Expect(hash).To(Equal(Hash([]byte("hello"))))
+
+			content, err := store.Read(hash)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("hello"))
+
+			ioutil.WriteFile(filepath.Join(dir, "store", "objects", hash[:2], hash), []byte("changed"), 0644)
+			_, err = store.Read(hash)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Save, Load and List", func() {
+		It("lists snapshots oldest first", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			save(store, "b", "0", "two", time.Hour)
+			save(store, "a", "0", "one", 2*time.Hour)
+
+			manifests, err := store.List()
+			Expect(err).To(BeNil())
+			Expect(manifests).To(HaveLen(2))
+			Expect(manifests[0].ID).To(Equal("a"))
+			Expect(manifests[1].ID).To(Equal("b"))
+
+			manifest, err := store.Load("b")
+			Expect(err).To(BeNil())
+			Expect(manifest.Files).To(HaveLen(1))
+			Expect(manifest.Files[0].Size).To(Equal(int64(3)))
+		})
+
+		It("lists nothing for an empty store", func() {
+			manifests, err := NewStore(filepath.Join(dir, "none")).List()
+			Expect(err).To(BeNil())
+			Expect(manifests).To(BeEmpty())
+		})
+
+		It("rejects ids that are paths", func() {
+			_, err := NewStore(dir).Load("../x")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Restore", func() {
+		It("writes the files of a snapshot", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			save(store, "s1", "0", "content", 0)
+
+			target := filepath.Join(dir, "restored")
+			Expect(store.Restore("s1", target)).To(BeNil())
+
+			content, err := ioutil.ReadFile(filepath.Join(target, "app", "s1"))
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("content"))
+		})
+
+		It("refuses a corrupt object", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			hash := save(store, "s1", "0", "content", 0)
+			Expect(ioutil.WriteFile(filepath.Join(dir, "store", "objects", hash[:2], hash), []byte("changed"), 0644)).To(BeNil())
+
+			Expect(store.Restore("s1", filepath.Join(dir, "restored"))).NotTo(BeNil())
+		})
+
+		It("refuses paths outside of the target", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			hash, _, _ := store.Put([]byte("x"))
+			Expect(store.Save(Manifest{ID: "evil", Files: []Entry{{Path: "/../../escaped", Hash: hash, Size: 1}}})).To(BeNil())
+
+			Expect(store.Restore("evil", filepath.Join(dir, "restored"))).NotTo(BeNil())
+			Expect(filepath.Join(dir, "escaped")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("Prune", func() {
+		It("keeps the newest snapshots of every instance and removes unreferenced objects", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			oldest := save(store, "old", "0", "one", 3*time.Hour)
+			save(store, "mid", "0", "two", 2*time.Hour)
+			save(store, "new", "0", "three", time.Hour)
+			save(store, "other", "1", "four", 4*time.Hour)
+			age(oldest)
+
+			removed, objects, err := store.Prune(Retention{KeepLast: 2}, false)
+			Expect(err).To(BeNil())
+			Expect(removed).To(Equal([]string{"old"}))
+			Expect(objects).To(Equal(1))
+
+			manifests, _ := store.List()
+			Expect(manifests).To(HaveLen(3))
+		})
+
+		It("keeps recent snapshots and objects within the grace period", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			save(store, "old", "0", "one", 10*24*time.Hour)
+			save(store, "new", "0", "two", time.Hour)
+
+			removed, objects, err := store.Prune(Retention{KeepWithin: 24 * time.Hour}, false)
+			Expect(err).To(BeNil())
+			Expect(removed).To(Equal([]string{"old"}))
+			Expect(objects).To(Equal(0))
+		})
+
+		It("removes nothing on a dry run", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			age(save(store, "old", "0", "one", 3*time.Hour))
+			save(store, "new", "0", "two", time.Hour)
+
+			removed, objects, err := store.Prune(Retention{KeepLast: 1}, true)
+			Expect(err).To(BeNil())
+			Expect(removed).To(Equal([]string{"old"}))
+			Expect(objects).To(Equal(1))
+
+			manifests, _ := store.List()
+			Expect(manifests).To(HaveLen(2))
+		})
+
+		It("requires a retention policy", func() {
+			_, _, err := NewStore(dir).Prune(Retention{}, false)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Recorder", func() {
+		It("records files and counts only new objects", func() {
+			store := NewStore(filepath.Join(dir, "store"))
+			store.Put([]byte("known"))
+
+			recorder := NewRecorder(store, "app", "0")
+			Expect(recorder.Add("/app/a", []byte("known"))).To(BeNil())
+			Expect(recorder.Add("/app/b", []byte("fresh"))).To(BeNil())
+
+			manifest, err := recorder.Finish([]string{"/app/"}, true)
+			Expect(err).To(BeNil())
+			Expect(manifest.Files).To(HaveLen(2))
+			Expect(manifest.Complete).To(BeTrue())
+
+			objects, bytes := recorder.GetNewObjects()
+			Expect(objects).To(Equal(1))
+			Expect(bytes).To(Equal(int64(5)))
+
+			loaded, err := store.Load(manifest.ID)
+			Expect(err).To(BeNil())
+			Expect(loaded.Paths).To(Equal([]string{"/app/"}))
+		})
+	})
+
+	Describe("ParseRetention", func() {
+		It("reads days, weeks and Go durations", func() {
+			Expect(ParseRetention("30d")).To(Equal(30 * 24 * time.Hour))
+			Expect(ParseRetention("2w")).To(Equal(14 * 24 * time.Hour))
+			Expect(ParseRetention("12h")).To(Equal(12 * time.Hour))
+			Expect(ParseRetention("")).To(Equal(time.Duration(0)))
+		})
+
+		It("rejects anything else", func() {
+			_, err := ParseRetention("soon")
+			Expect(err).NotTo(BeNil())
+			_, err = ParseRetention("-1d")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
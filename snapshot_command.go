@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/merge"
+	"github.com/ibmjstart/cf-download/snapshot"
+)
+
+// contains the flag values of cf download-snapshot prune
+type pruneFlagVal struct {
+	KeepLast_flag   int
+	KeepWithin_flag string
+	DryRun_flag     bool
+}
+
+/*
+*	RunSnapshot() is the entry point of cf download-snapshot, which lists, restores and prunes the
+*	snapshots kept in a store written by cf download --snapshot.
+ */
+func RunSnapshot(args []string) {
+	onWindows := IsWindows()
+
+	if len(args) < 3 {
+		fmt.Println(createMessage("\nError: Missing the action or the snapshot store", "red+b", onWindows))
+		printCommandHelp("download-snapshot")
+		os.Exit(1)
+	}
+
+	store := snapshot.NewStore(args[2])
+	switch args[1] {
+	case "list":
+		manifests, err := store.List()
+		check(err, "Cannot read the snapshots in "+args[2])
+		printSnapshots(manifests)
+
+	case "restore":
+		if len(args) < 5 {
+			fmt.Println(createMessage("\nError: restore needs a snapshot id and a target directory", "red+b", onWindows))
+			printCommandHelp("download-snapshot")
+			os.Exit(1)
+		}
+		if Exists(args[4]) {
+			fmt.Println("\nError: target path", args[4], "already exists.")
+			os.Exit(1)
+		}
+
+		err := store.Restore(args[3], args[4])
+		check(err, "Cannot restore snapshot "+args[3])
+		fmt.Println(createMessage("Snapshot "+args[3]+" restored to "+args[4], "green+b", onWindows))
+
+	case "prune":
+		flagVals := ParsePruneArgs(args)
+		keepWithin, err := snapshot.ParseRetention(flagVals.KeepWithin_flag)
+		check(err, "")
+
+		removed, objects, err := store.Prune(snapshot.Retention{KeepLast: flagVals.KeepLast_flag, KeepWithin: keepWithin}, flagVals.DryRun_flag)
+		check(err, "Cannot prune the snapshots in "+args[2])
+
+		verb := "Removed"
+		if flagVals.DryRun_flag {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d snapshots and %d unreferenced objects.\n", verb, len(removed), objects)
+		PrintSlice(removed)
+
+	default:
+		fmt.Println(createMessage("\nError: unknown action '"+args[1]+"', expected list, restore or prune", "red+b", onWindows))
+		printCommandHelp("download-snapshot")
+		os.Exit(1)
+	}
+}
+
+/*
+*	This function sets the flag values of cf download-snapshot prune.
+ */
+func ParsePruneArgs(args []string) pruneFlagVal {
+	f1 := newFlagSet("download-snapshot")
+
+	keepLastp := f1.Int("keep-last", 0, "--keep-last count")
+	keepWithinp := f1.String("keep-within", "", "--keep-within duration")
+	dryRunp := f1.Bool("dry-run", false, "--dry-run")
+
+	err := f1.Parse(args[3:])
+	if err == nil && *keepLastp < 0 {
+		err = fmt.Errorf("--keep-last must not be negative")
+	}
+	f1.ExitOnError(err)
+
+	return pruneFlagVal{
+		KeepLast_flag:   *keepLastp,
+		KeepWithin_flag: *keepWithinp,
+		DryRun_flag:     *dryRunp,
+	}
+}
+
+// lists the snapshots of a store, oldest first
+func printSnapshots(manifests []snapshot.Manifest) {
+	if len(manifests) == 0 {
+		fmt.Println("No snapshots found.")
+		return
+	}
+
+	for _, val := range manifests {
+		var size int64
+		for _, file := range val.Files {
+			size += file.Size
+		}
+
+		status := ""
+		if !val.Complete {
+			status = " (incomplete)"
+		}
+		fmt.Printf("%s  %s  %d files, %s  %s%s\n", val.ID, val.Created.Local().Format("2006-01-02 15:04"), len(val.Files), dir_parser.FormatSize(size), strings.Join(val.Paths, " "), status)
+	}
+}
+
+/*
+*	This function reports the snapshot a download was saved as, and how much of it was new.
+ */
+func printSnapshotInfo(manifest snapshot.Manifest, recorder snapshot.Recorder, dir string) {
+	objects, bytes := recorder.GetNewObjects()
+
+	fmt.Printf("\nSnapshot %s saved in %s: %d files, %d new objects (%s).\n", manifest.ID, dir, len(manifest.Files), objects, dir_parser.FormatSize(bytes))
+	if !manifest.Complete {
+		fmt.Println("The snapshot is incomplete, some files were not downloaded.")
+	}
+}
+
+// the letter a merged file is listed with, conflicts and skipped files are listed as C and S
+var mergeLetters = map[merge.Status]string{
+	merge.Updated: "U",
+	merge.Added:   "A",
+	merge.Removed: "D",
+	merge.Merged:  "M",
+}
+
+/*
+*	This function merges the changes between the previous snapshot of the app and 'manifest' into
+*	'localDir', and prints what happened to each file. It exits with 1 if there were conflicts.
+ */
+func mergeInto(store snapshot.Store, manifest snapshot.Manifest, root, localDir string, onWindows bool) {
+	// files missing from an incomplete snapshot would look deleted
+	if !manifest.Complete {
+		fmt.Println(createMessage("\nError: not merging into "+localDir+", the download is incomplete.", "red+b", onWindows))
+		os.Exit(1)
+	}
+
+	base, err := merge.FindBase(store, manifest)
+	check(err, "Cannot read the snapshot store")
+
+	if base == nil {
+		fmt.Println("\nNo earlier snapshot of", manifest.App, "to merge from, only files missing locally are added. The next merge uses this snapshot.")
+	} else {
+		fmt.Println("\nMerging the changes since snapshot", base.ID, "into", localDir)
+	}
+
+	counts := map[merge.Status]int{}
+	for _, val := range merge.Tree(store, base, manifest, root, localDir) {
+		counts[val.Status]++
+
+		switch val.Status {
+		case merge.Conflicted:
+			fmt.Println(createMessage("C  "+val.Path, "red", onWindows))
+		case merge.Skipped:
+			fmt.Println(createMessage("S  "+val.Path+" ("+val.Reason+")", "yellow", onWindows))
+		default:
+			fmt.Println(createMessage(mergeLetters[val.Status]+"  "+val.Path, "green", onWindows))
+		}
+	}
+
+	fmt.Printf("\n%d updated, %d added, %d removed, %d merged, %d conflicted, %d skipped.\n", counts[merge.Updated], counts[merge.Added], counts[merge.Removed], counts[merge.Merged], counts[merge.Conflicted], counts[merge.Skipped])
+	if counts[merge.Conflicted] > 0 {
+		fmt.Println("Resolve the conflict markers in the conflicted files.")
+		os.Exit(1)
+	}
+}
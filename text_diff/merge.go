@@ -0,0 +1,109 @@
+package text_diff
+
+import (
+	"strings"
+)
+
+/*
+*	Merge() applies the changes 'local' and 'remote' each made to 'base' to one text, the way
+*	diff3 and git merge do. Where both changed the same lines differently, both versions are kept
+*	between conflict markers labelled 'nameLocal' and 'nameRemote'. It returns the merged text and
+*	the number of conflicts.
+ */
+func Merge(base, local, remote, nameLocal, nameRemote string) (string, int) {
+	linesBase := splitLines(base)
+	linesLocal := splitLines(local)
+	linesRemote := splitLines(remote)
+
+	matchLocal := matches(linesBase, linesLocal)
+	matchRemote := matches(linesBase, linesRemote)
+
+	var out strings.Builder
+	conflicts := 0
+	i, l, r := 0, 0, 0
+	for {
+		// lines unchanged on both sides
+		for i < len(linesBase) && matchLocal[i] == l && matchRemote[i] == r {
+			out.WriteString(linesBase[i])
+			i, l, r = i+1, l+1, r+1
+		}
+		if i == len(linesBase) && l == len(linesLocal) && r == len(linesRemote) {
+			break
+		}
+
+		// the next base line both sides kept ends the changed chunk
+		j := i
+		for j < len(linesBase) && (matchLocal[j] < 0 || matchRemote[j] < 0) {
+			j++
+		}
+		endLocal, endRemote := len(linesLocal), len(linesRemote)
+		if j < len(linesBase) {
+			endLocal, endRemote = matchLocal[j], matchRemote[j]
+		}
+
+		chunkBase := linesBase[i:j]
+		chunkLocal := linesLocal[l:endLocal]
+		chunkRemote := linesRemote[r:endRemote]
+
+		switch {
+		case sameLines(chunkLocal, chunkBase):
+			writeLines(&out, chunkRemote)
+		case sameLines(chunkRemote, chunkBase), sameLines(chunkLocal, chunkRemote):
+			writeLines(&out, chunkLocal)
+		default:
+			conflicts++
+			out.WriteString("<<<<<<< " + nameLocal + "\n")
+			writeTerminated(&out, chunkLocal)
+			out.WriteString("=======\n")
+			writeTerminated(&out, chunkRemote)
+			out.WriteString(">>>>>>> " + nameRemote + "\n")
+		}
+
+		i, l, r = j, endLocal, endRemote
+	}
+
+	return out.String(), conflicts
+}
+
+// returns for every line of 'a' the line of 'b' it was kept as, or -1 if it was removed
+func matches(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+
+	for _, val := range diffLines(a, b) {
+		if val.op == equal {
+			match[val.a] = val.b
+		}
+	}
+
+	return match
+}
+
+func sameLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, val := range lines {
+		out.WriteString(val)
+	}
+}
+
+// like writeLines(), but ends the last line so a conflict marker can follow it
+func writeTerminated(out *strings.Builder, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
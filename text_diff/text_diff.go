@@ -0,0 +1,273 @@
+package text_diff
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// number of unchanged lines shown around each change
+const DefaultContext = 3
+
+// files that differ in more lines than this are shown as one replacement instead of line by line
+const maxEdits = 4000
+
+type op int
+
+const (
+	equal op = iota
+	del
+	ins
+)
+
+// one line of the edit script, a and b are the line's position in either text
+type edit struct {
+	op op
+	a  int
+	b  int
+}
+
+/*
+*	Unified() returns the differences between 'a' and 'b' in unified diff format, with 'context'
+*	unchanged lines around each change, or "" if the texts are equal.
+ */
+func Unified(nameA, nameB, a, b string, context int) string {
+	linesA := splitLines(a)
+	linesB := splitLines(b)
+	edits := diffLines(linesA, linesB)
+
+	var out strings.Builder
+	for i, val := range hunks(edits, context) {
+		if i == 0 {
+			out.WriteString("--- " + nameA + "\n+++ " + nameB + "\n")
+		}
+
+		hunk := edits[val.start:val.end]
+		countA, countB := 0, 0
+		for _, e := range hunk {
+			if e.op != ins {
+				countA++
+			}
+			if e.op != del {
+				countB++
+			}
+		}
+		out.WriteString("@@ -" + hunkRange(hunk[0].a, countA) + " +" + hunkRange(hunk[0].b, countB) + " @@\n")
+
+		for _, e := range hunk {
+			switch e.op {
+			case equal:
+				writeLine(&out, " ", linesA[e.a])
+			case del:
+				writeLine(&out, "-", linesA[e.a])
+			case ins:
+				writeLine(&out, "+", linesB[e.b])
+			}
+		}
+	}
+
+	return out.String()
+}
+
+/*
+*	IsText() reports whether 'data' looks like text rather than a binary file, the way diff and
+*	git decide it: a NUL byte near the start makes it binary.
+ */
+func IsText(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// splits a text into lines that keep their line ending
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func writeLine(out *strings.Builder, prefix, line string) {
+	out.WriteString(prefix + line)
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// formats a hunk's line range, an empty range is given by the line before it
+func hunkRange(pos, count int) string {
+	if count == 0 {
+		return strconv.Itoa(pos) + ",0"
+	}
+	if count == 1 {
+		return strconv.Itoa(pos + 1)
+	}
+
+	return strconv.Itoa(pos+1) + "," + strconv.Itoa(count)
+}
+
+type span struct {
+	start int
+	end   int
+}
+
+// groups the changes of an edit script into hunks, changes closer than twice the context share one
+func hunks(edits []edit, context int) []span {
+	var spans []span
+	for i, val := range edits {
+		if val.op == equal {
+			continue
+		}
+
+		start, end := i-context, i+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		if len(spans) > 0 && start <= spans[len(spans)-1].end {
+			spans[len(spans)-1].end = end
+		} else {
+			spans = append(spans, span{start, end})
+		}
+	}
+
+	return spans
+}
+
+// returns the edit script turning 'a' into 'b', the common start and end are split off first
+func diffLines(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, edit{equal, i, i})
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	middle, ok := myers(middleA, middleB)
+	if !ok {
+		middle = replace(middleA, middleB)
+	}
+	for _, val := range middle {
+		edits = append(edits, edit{val.op, val.a + prefix, val.b + prefix})
+	}
+
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, edit{equal, len(a) - i, len(b) - i})
+	}
+
+	return edits
+}
+
+// deletes all of 'a' and inserts all of 'b'
+func replace(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := range a {
+		edits = append(edits, edit{del, i, 0})
+	}
+	for i := range b {
+		edits = append(edits, edit{ins, len(a), i})
+	}
+
+	return edits
+}
+
+/*
+*	myers() finds a shortest edit script with Myers' O(ND) algorithm. Only the diagonals reached
+*	in each round are kept for the way back, so memory grows with the square of the number of
+*	edits. It gives up, returning false, past maxEdits edits.
+ */
+func myers(a, b []string) ([]edit, bool) {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil, true
+	}
+
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	offset := n + m
+	v := make([]int, 2*(n+m)+2)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(trace, n, m), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	return nil, false
+}
+
+// walks the rounds of myers() backwards to recover the edit script
+func backtrack(trace [][]int, n, m int) []edit {
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		get := func(k int) int { return prev[k+d-1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{equal, x - 1, y - 1})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, edit{ins, x, y - 1})
+		} else {
+			edits = append(edits, edit{del, x - 1, y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		edits = append(edits, edit{equal, x - 1, y - 1})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
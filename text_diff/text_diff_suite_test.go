@@ -0,0 +1,13 @@
+package text_diff_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTextDiff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Text Diff Suite")
+}
@@ -0,0 +1,97 @@
+package text_diff_test
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/ibmjstart/cf-download/text_diff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TextDiff", func() {
+	Describe("Test Unified()", func() {
+		It("Should return nothing for equal texts", func() {
+			Ω(Unified("a", "b", "one\ntwo\n", "one\ntwo\n", 3)).To(Equal(""))
+		})
+
+		It("Should show a changed line with its context", func() {
+			a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+			b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n"
+
+			Ω(Unified("a/f", "b/f", a, b, 3)).To(Equal("--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"))
+		})
+
+		It("Should split changes far apart into separate hunks", func() {
+			var lines []string
+			for i := 0; i < 20; i++ {
+				lines = append(lines, "line "+strconv.Itoa(i))
+			}
+			a := strings.Join(lines, "\n") + "\n"
+			lines[0], lines[19] = "first", "last"
+			b := strings.Join(lines, "\n") + "\n"
+
+			diff := Unified("a", "b", a, b, 1)
+			Ω(diff).To(Equal("--- a\n+++ b\n@@ -1,2 +1,2 @@\n-line 0\n+first\n line 1\n@@ -19,2 +19,2 @@\n line 18\n-line 19\n+last\n"))
+		})
+
+		It("Should diff against an empty text", func() {
+			Ω(Unified("/dev/null", "b", "", "new\n", 3)).To(Equal("--- /dev/null\n+++ b\n@@ -0,0 +1 @@\n+new\n"))
+			Ω(Unified("a", "/dev/null", "old\nlines\n", "", 3)).To(Equal("--- a\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-old\n-lines\n"))
+		})
+
+		It("Should mark a missing newline at the end", func() {
+			Ω(Unified("a", "b", "x\n", "x", 3)).To(Equal("--- a\n+++ b\n@@ -1 +1 @@\n-x\n+x\n\\ No newline at end of file\n"))
+		})
+
+		It("Should find the shortest set of changes", func() {
+			diff := Unified("a", "b", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 0)
+			removed := strings.Count(diff, "\n-")
+			added := strings.Count(diff, "\n+") - 1
+			Ω(removed + added).To(Equal(5))
+		})
+	})
+
+	Describe("Test IsText()", func() {
+		It("Should tell text from binary data", func() {
+			Ω(IsText([]byte("plain text\n"))).To(BeTrue())
+			Ω(IsText([]byte{0x7f, 'E', 'L', 'F', 0, 1})).To(BeFalse())
+		})
+	})
+
+	Describe("Test Merge()", func() {
+		base := "1\n2\n3\n4\n5\n6\n7\n"
+
+		It("Should combine changes to different lines", func() {
+			local := "one\n2\n3\n4\n5\n6\n7\n"
+			remote := "1\n2\n3\n4\n5\n6\nseven\neight\n"
+
+			merged, conflicts := Merge(base, local, remote, "local", "remote")
+			Ω(conflicts).To(Equal(0))
+			Ω(merged).To(Equal("one\n2\n3\n4\n5\n6\nseven\neight\n"))
+		})
+
+		It("Should take a change made on both sides once", func() {
+			changed := "1\n2\nthree\n4\n5\n6\n7\n"
+
+			merged, conflicts := Merge(base, changed, changed, "local", "remote")
+			Ω(conflicts).To(Equal(0))
+			Ω(merged).To(Equal(changed))
+		})
+
+		It("Should mark overlapping changes as a conflict", func() {
+			local := "1\n2\nthree\n4\n5\n6\n7\n"
+			remote := "1\n2\nTHREE\n4\n5\n6\n7"
+
+			merged, conflicts := Merge(base, local, remote, "local", "remote")
+			Ω(conflicts).To(Equal(1))
+			Ω(merged).To(Equal("1\n2\n<<<<<<< local\nthree\n=======\nTHREE\n>>>>>>> remote\n4\n5\n6\n7"))
+		})
+
+		It("Should merge into an empty base", func() {
+			merged, conflicts := Merge("", "", "new\n", "local", "remote")
+			Ω(conflicts).To(Equal(0))
+			Ω(merged).To(Equal("new\n"))
+		})
+	})
+})
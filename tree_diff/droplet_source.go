@@ -0,0 +1,137 @@
+package tree_diff
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+type dropletSource struct {
+	tarball string
+	root    string
+	dirs    map[string]map[string]Entry
+}
+
+/*
+*	NewDropletSource() returns the tree below 'root' in a droplet, the gzipped tarball staging
+*	produced. The droplet holds what an instance starts with in its home directory, so 'root' is
+*	the same path as on the instance, e.g. /app/. The listing is read once, file contents are read
+*	from the tarball when asked for.
+ */
+func NewDropletSource(tarball, root string) (*dropletSource, error) {
+	d := &dropletSource{
+		tarball: tarball,
+		root:    strings.TrimPrefix(root, "/"),
+		dirs:    map[string]map[string]Entry{"": {}},
+	}
+
+	err := d.scan(func(name string, header *tar.Header, content io.Reader) bool {
+		switch header.Typeflag {
+		case tar.TypeDir:
+			d.add(name, Entry{Name: path.Base(name), Dir: true, Size: -1})
+		case tar.TypeSymlink:
+			// lstat reports the length of the target as the size of a link
+			d.add(name, Entry{Name: path.Base(name), Size: int64(len(header.Linkname))})
+		case tar.TypeReg, tar.TypeRegA:
+			d.add(name, Entry{Name: path.Base(name), Size: header.Size})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.New(tarball + " is not a droplet: " + err.Error())
+	}
+
+	return d, nil
+}
+
+func (d *dropletSource) List(dir string) ([]Entry, bool) {
+	byName, ok := d.dirs[strings.TrimSuffix(d.root+dir, "/")]
+	if !ok {
+		return nil, false
+	}
+
+	entries := make([]Entry, 0, len(byName))
+	for _, val := range byName {
+		entries = append(entries, val)
+	}
+
+	return entries, true
+}
+
+func (d *dropletSource) Read(file string) ([]byte, error) {
+	var content []byte
+	var found bool
+
+	err := d.scan(func(name string, header *tar.Header, r io.Reader) bool {
+		if name != d.root+file || header.Typeflag == tar.TypeDir {
+			return true
+		}
+
+		var err error
+		content, err = ioutil.ReadAll(r)
+		found = err == nil
+		return false
+	})
+	if err == nil && !found {
+		err = errors.New("'" + file + "' is not in the droplet")
+	}
+
+	return content, err
+}
+
+// records an entry and every directory above it, tarballs need not list directories themselves
+func (d *dropletSource) add(name string, entry Entry) {
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+
+	if d.dirs[parent] == nil {
+		d.add(parent, Entry{Name: path.Base(parent), Dir: true, Size: -1})
+	}
+	if entry.Dir && d.dirs[name] == nil {
+		d.dirs[name] = map[string]Entry{}
+	}
+
+	d.dirs[parent][entry.Name] = entry
+}
+
+// calls 'visit' for every entry of the tarball until it returns false, names are cleaned of ./
+func (d *dropletSource) scan(visit func(name string, header *tar.Header, content io.Reader) bool) error {
+	file, err := os.Open(d.tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+
+		if !visit(name, header, archive) {
+			return nil
+		}
+	}
+}
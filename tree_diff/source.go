@@ -0,0 +1,136 @@
+package tree_diff
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/metadata"
+)
+
+// an entry of a listed directory, Size is -1 for directories and sizes that are not known
+type Entry struct {
+	Name string
+	Dir  bool
+	Size int64
+}
+
+/*
+*	Source is one side of a comparison. Paths are relative to the source's root, use forward
+*	slashes and end in a slash for directories; "" is the root itself. List() reports false if the
+*	directory could not be listed.
+ */
+type Source interface {
+	List(path string) ([]Entry, bool)
+	Read(path string) ([]byte, error)
+}
+
+type remoteSource struct {
+	cmdExec   cmd_exec.CmdExec
+	parser    dir_parser.Parser
+	appName   string
+	instances instance_manager.InstanceManager
+	root      string
+}
+
+/*
+*	NewRemoteSource() returns the tree below 'root' on the given instance of an app, as listed by
+*	cf files.
+ */
+func NewRemoteSource(cmdExec cmd_exec.CmdExec, appName, instance, root string, onWindows, verbose bool) *remoteSource {
+	return &remoteSource{
+		cmdExec:   cmdExec,
+		parser:    dir_parser.NewParser(cmdExec, appName, instance, onWindows, verbose),
+		appName:   appName,
+		instances: instance_manager.NewInstanceManager(cmdExec, appName, instance, false),
+		root:      root,
+	}
+}
+
+/*
+*	SetInstanceManager() lets the source follow the instance selected by the given manager
+*	instead of the fixed instance passed to NewRemoteSource.
+ */
+func (r *remoteSource) SetInstanceManager(instances instance_manager.InstanceManager) {
+	r.instances = instances
+	r.parser.SetInstanceManager(instances)
+}
+
+func (r *remoteSource) List(path string) ([]Entry, bool) {
+	listing, listed := r.parser.ListDir(r.root + path)
+
+	entries := make([]Entry, 0, len(listing))
+	for _, val := range listing {
+		if val.Kind == dir_parser.Dir {
+			entries = append(entries, Entry{Name: strings.TrimSuffix(val.Name, "/"), Dir: true, Size: -1})
+		} else {
+			entries = append(entries, Entry{Name: val.Name, Size: val.Size})
+		}
+	}
+
+	return entries, listed
+}
+
+// fetches a file, without the header cf files puts in front of it
+func (r *remoteSource) Read(path string) ([]byte, error) {
+	output, err := r.cmdExec.GetFile(r.appName, r.root+path, r.instances.Current())
+	file := strings.SplitAfterN(string(output), "\n", 3)
+
+	if len(file) < 3 || !strings.Contains(file[1], "OK") {
+		if err == nil {
+			err = errors.New("'" + r.root + path + "' could not be downloaded")
+		}
+		return nil, err
+	}
+
+	// see WriteFile() in the downloader
+	if strings.Contains(file[2], "No files found") {
+		return []byte{}, nil
+	}
+
+	return []byte(file[2]), nil
+}
+
+type localSource struct {
+	root string
+}
+
+/*
+*	NewLocalSource() returns the local directory tree below 'root'. Git's own .git directories are
+*	left out, so a checkout can be compared with an app, as is the metadata cf download leaves in
+*	its destination.
+ */
+func NewLocalSource(root string) *localSource {
+	return &localSource{root: root}
+}
+
+func (l *localSource) List(path string) ([]Entry, bool) {
+	infos, err := ioutil.ReadDir(filepath.Join(l.root, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, false
+	}
+
+	entries := make([]Entry, 0, len(infos))
+	for _, val := range infos {
+		if val.IsDir() {
+			if val.Name() != ".git" {
+				entries = append(entries, Entry{Name: val.Name(), Dir: true, Size: -1})
+			}
+			continue
+		}
+		if path == "" && val.Name() == metadata.FileName {
+			continue
+		}
+		entries = append(entries, Entry{Name: val.Name(), Size: val.Size()})
+	}
+
+	return entries, true
+}
+
+func (l *localSource) Read(path string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(l.root, filepath.FromSlash(path)))
+}
@@ -0,0 +1,299 @@
+package tree_diff
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/filter"
+	"github.com/ibmjstart/cf-download/text_diff"
+)
+
+type Differ interface {
+	Diff() []Change
+	GetFailed() []string
+	GetRemovedDirs() []string
+}
+
+// how an entry of the second tree differs from the first
+type Kind int
+
+const (
+	// only in the second tree
+	Added Kind = iota
+	// only in the first tree
+	Removed
+	// in both trees with different sizes
+	Modified
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "modified"
+	}
+}
+
+// kinds are written to JSON by name
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// a file that differs between the trees, a size is -1 on the side the file is missing from
+type Change struct {
+	Path  string `json:"path"`
+	Kind  Kind   `json:"kind"`
+	SizeA int64  `json:"size_a"`
+	SizeB int64  `json:"size_b"`
+}
+
+// number of directories compared at the same time
+const DefaultWorkers = 4
+
+type differ struct {
+	a          Source
+	b          Source
+	filterRoot string
+	filterList []string
+	workers    int
+	changes    []Change
+	failed     []string
+	removedDir []string
+	mu         sync.Mutex
+}
+
+/*
+*	NewDiffer() returns a differ comparing tree 'a' with tree 'b' on 'workers' goroutines. Entries
+*	are checked against the filter list as if they were below the remote path 'filterRoot', the
+*	way a download checks them.
+ */
+func NewDiffer(a, b Source, filterRoot string, filterList []string, workers int) *differ {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &differ{
+		a:          a,
+		b:          b,
+		filterRoot: filterRoot,
+		filterList: filterList,
+		workers:    workers,
+	}
+}
+
+// which of the trees a directory was found in
+type side int
+
+const (
+	both side = iota
+	onlyA
+	onlyB
+)
+
+type task struct {
+	path string
+	side side
+}
+
+/*
+*	Diff() walks both trees and returns the files that differ, sorted by path. Directories found in
+*	both trees are listed on either side at the same time. Files are compared by size, rounded the
+*	way cf files lists them. A directory that can not be listed on either side is skipped and
+*	reported by GetFailed(), instead of counting its entries as missing.
+ */
+func (d *differ) Diff() []Change {
+	var pending sync.WaitGroup
+	queue := make(chan task, d.workers)
+
+	var walk func(t task)
+	walk = func(t task) {
+		for _, val := range d.compare(t) {
+			// hand the directory to an idle worker, or walk it here if none is free
+			pending.Add(1)
+			select {
+			case queue <- val:
+			default:
+				pending.Done()
+				walk(val)
+			}
+		}
+	}
+
+	for i := 0; i < d.workers; i++ {
+		go func() {
+			for t := range queue {
+				walk(t)
+				pending.Done()
+			}
+		}()
+	}
+
+	pending.Add(1)
+	walk(task{path: "", side: both})
+	pending.Done()
+
+	pending.Wait()
+	close(queue)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sort.Slice(d.changes, func(i, j int) bool { return d.changes[i].Path < d.changes[j].Path })
+	return append([]Change(nil), d.changes...)
+}
+
+/*
+*	GetFailed() returns the directories that could not be listed, and so were not compared.
+ */
+func (d *differ) GetFailed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.failed...)
+}
+
+/*
+*	GetRemovedDirs() returns the directories of tree 'a' that tree 'b' does not have, sorted. Only
+*	the outermost one is returned, the files below it are reported by Diff() as removed.
+ */
+func (d *differ) GetRemovedDirs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dirs := append([]string(nil), d.removedDir...)
+	sort.Strings(dirs)
+	return dirs
+}
+
+// compares one directory and returns its sub directories
+func (d *differ) compare(t task) []task {
+	var entriesA, entriesB []Entry
+	listedA, listedB := true, true
+
+	var wg sync.WaitGroup
+	if t.side != onlyB {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entriesA, listedA = d.a.List(t.path)
+		}()
+	}
+	if t.side != onlyA {
+		entriesB, listedB = d.b.List(t.path)
+	}
+	wg.Wait()
+
+	if !listedA || !listedB {
+		d.mu.Lock()
+		d.failed = append(d.failed, d.filterRoot+t.path)
+		d.mu.Unlock()
+		return nil
+	}
+
+	inA := index(entriesA)
+	inB := index(entriesB)
+	var changes []Change
+	var dirs []task
+	var removedDirs []string
+
+	for name, a := range inA {
+		path := t.path + name
+		if d.filtered(path) {
+			continue
+		}
+		b, ok := inB[name]
+
+		switch {
+		case a.Dir && ok && b.Dir:
+			dirs = append(dirs, task{path: path + "/", side: both})
+		case a.Dir:
+			dirs = append(dirs, task{path: path + "/", side: onlyA})
+			if t.side == both {
+				removedDirs = append(removedDirs, path+"/")
+			}
+		case !ok || b.Dir:
+			changes = append(changes, Change{Path: path, Kind: Removed, SizeA: a.Size, SizeB: -1})
+		case !sameSize(a.Size, b.Size):
+			changes = append(changes, Change{Path: path, Kind: Modified, SizeA: a.Size, SizeB: b.Size})
+		}
+	}
+
+	for name, b := range inB {
+		path := t.path + name
+		if d.filtered(path) {
+			continue
+		}
+		a, ok := inA[name]
+
+		switch {
+		case b.Dir && ok && a.Dir:
+			// compared above
+		case b.Dir:
+			dirs = append(dirs, task{path: path + "/", side: onlyB})
+		case !ok || a.Dir:
+			changes = append(changes, Change{Path: path, Kind: Added, SizeA: -1, SizeB: b.Size})
+		}
+	}
+
+	d.mu.Lock()
+	d.changes = append(d.changes, changes...)
+	d.removedDir = append(d.removedDir, removedDirs...)
+	d.mu.Unlock()
+
+	return dirs
+}
+
+func (d *differ) filtered(path string) bool {
+	return filter.CheckToFilter(strings.TrimSuffix(d.filterRoot+path, "/"), d.filterList)
+}
+
+func index(entries []Entry) map[string]Entry {
+	byName := make(map[string]Entry, len(entries))
+	for _, val := range entries {
+		byName[val.Name] = val
+	}
+
+	return byName
+}
+
+// listed sizes are rounded, so sizes are compared the way cf files shows them
+func sameSize(a, b int64) bool {
+	if a < 0 || b < 0 {
+		return a == b
+	}
+
+	return dir_parser.FormatSize(a) == dir_parser.FormatSize(b)
+}
+
+/*
+*	Patch() fetches both sides of a changed file and returns their differences in unified diff
+*	format. A file missing on one side is diffed against an empty file, binary files are only
+*	reported as differing.
+ */
+func Patch(a, b Source, change Change) (string, error) {
+	var contentA, contentB []byte
+	var err error
+
+	nameA, nameB := "a/"+change.Path, "b/"+change.Path
+	if change.Kind == Added {
+		nameA = "/dev/null"
+	} else if contentA, err = a.Read(change.Path); err != nil {
+		return "", err
+	}
+	if change.Kind == Removed {
+		nameB = "/dev/null"
+	} else if contentB, err = b.Read(change.Path); err != nil {
+		return "", err
+	}
+
+	if !text_diff.IsText(contentA) || !text_diff.IsText(contentB) {
+		return "Binary files " + nameA + " and " + nameB + " differ\n", nil
+	}
+
+	return text_diff.Unified(nameA, nameB, string(contentA), string(contentB), text_diff.DefaultContext), nil
+}
@@ -0,0 +1,13 @@
+package tree_diff_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTreeDiff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tree Diff Suite")
+}
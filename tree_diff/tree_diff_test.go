@@ -0,0 +1,268 @@
+package tree_diff_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	. "github.com/ibmjstart/cf-download/tree_diff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a tree held in memory, keyed by file path
+type memTree struct {
+	files   map[string]string
+	failing string
+}
+
+func (m *memTree) List(path string) ([]Entry, bool) {
+	if m.failing != "" && path == m.failing {
+		return nil, false
+	}
+
+	seen := map[string]bool{}
+	var entries []Entry
+	for name, content := range m.files {
+		if !strings.HasPrefix(name, path) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, path)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if !seen[rest[:i]] {
+				seen[rest[:i]] = true
+				entries = append(entries, Entry{Name: rest[:i], Dir: true, Size: -1})
+			}
+			continue
+		}
+		entries = append(entries, Entry{Name: rest, Size: int64(len(content))})
+	}
+
+	return entries, true
+}
+
+func (m *memTree) Read(path string) ([]byte, error) {
+	content, ok := m.files[path]
+	if !ok {
+		return nil, errors.New("no such file")
+	}
+	return []byte(content), nil
+}
+
+// writes a gzipped tarball laid out like a droplet, names ending in / are directories and
+// contents starting with -> are symbolic links
+func writeDroplet(path string, entries [][2]string) {
+	file, err := os.Create(path)
+	Ω(err).To(BeNil())
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	defer gz.Close()
+	archive := tar.NewWriter(gz)
+	defer archive.Close()
+
+	for _, val := range entries {
+		switch {
+		case strings.HasSuffix(val[0], "/"):
+			archive.WriteHeader(&tar.Header{Name: val[0], Typeflag: tar.TypeDir, Mode: 0755})
+		case strings.HasPrefix(val[1], "->"):
+			archive.WriteHeader(&tar.Header{Name: val[0], Typeflag: tar.TypeSymlink, Linkname: strings.TrimPrefix(val[1], "->"), Mode: 0777})
+		default:
+			archive.WriteHeader(&tar.Header{Name: val[0], Typeflag: tar.TypeReg, Size: int64(len(val[1])), Mode: 0644})
+			archive.Write([]byte(val[1]))
+		}
+	}
+}
+
+var _ = Describe("TreeDiff", func() {
+	var a, b *memTree
+
+	BeforeEach(func() {
+		a = &memTree{files: map[string]string{
+			"app.js":            "one\ntwo\n",
+			"same.txt":          "same",
+			"lib/util.js":       "util",
+			"old/gone.txt":      "gone",
+			"old/deep/gone.txt": "gone",
+			"node_modules/x.js": "x",
+		}}
+		b = &memTree{files: map[string]string{
+			"app.js":            "one\ntwo\nthree\n",
+			"same.txt":          "same",
+			"lib/util.js":       "util",
+			"lib/new.js":        "new",
+			"fresh/file.txt":    "fresh",
+			"node_modules/y.js": "y",
+		}}
+	})
+
+	Describe("Test Diff()", func() {
+		It("Should report added, removed and modified files", func() {
+			changes := NewDiffer(a, b, "/app/", []string{"/app/node_modules"}, 2).Diff()
+
+			Ω(changes).To(Equal([]Change{
+				{Path: "app.js", Kind: Modified, SizeA: 8, SizeB: 14},
+				{Path: "fresh/file.txt", Kind: Added, SizeA: -1, SizeB: 5},
+				{Path: "lib/new.js", Kind: Added, SizeA: -1, SizeB: 3},
+				{Path: "old/deep/gone.txt", Kind: Removed, SizeA: 4, SizeB: -1},
+				{Path: "old/gone.txt", Kind: Removed, SizeA: 4, SizeB: -1},
+			}))
+		})
+
+		It("Should skip directories that can not be listed", func() {
+			b.failing = "lib/"
+			differ := NewDiffer(a, b, "/app/", []string{"/app/node_modules"}, 2)
+
+			for _, val := range differ.Diff() {
+				Ω(val.Path).NotTo(HavePrefix("lib/"))
+			}
+			Ω(differ.GetFailed()).To(Equal([]string{"/app/lib/"}))
+		})
+
+		It("Should report the outermost directories missing from the second tree", func() {
+			differ := NewDiffer(a, b, "/app/", []string{"/app/node_modules"}, 2)
+			differ.Diff()
+
+			Ω(differ.GetRemovedDirs()).To(Equal([]string{"old/"}))
+		})
+
+		It("Should compare sizes the way they are listed", func() {
+			a.files = map[string]string{"big.bin": strings.Repeat("x", 1100)}
+			b.files = map[string]string{"big.bin": strings.Repeat("x", 1120)}
+
+			Ω(NewDiffer(a, b, "/", nil, 1).Diff()).To(BeEmpty())
+		})
+	})
+
+	Describe("Test Change", func() {
+		It("Should write its kind to JSON by name", func() {
+			output, err := json.Marshal(Change{Path: "lib/new.js", Kind: Added, SizeA: -1, SizeB: 3})
+			Ω(err).To(BeNil())
+			Ω(string(output)).To(Equal(`{"path":"lib/new.js","kind":"added","size_a":-1,"size_b":3}`))
+		})
+	})
+
+	Describe("Test Patch()", func() {
+		It("Should diff both sides of a modified file", func() {
+			patch, err := Patch(a, b, Change{Path: "app.js", Kind: Modified})
+			Ω(err).To(BeNil())
+			Ω(patch).To(Equal("--- a/app.js\n+++ b/app.js\n@@ -1,2 +1,3 @@\n one\n two\n+three\n"))
+		})
+
+		It("Should not diff binary files", func() {
+			a.files["app.js"] = "\x00\x01"
+			patch, err := Patch(a, b, Change{Path: "app.js", Kind: Modified})
+			Ω(err).To(BeNil())
+			Ω(patch).To(Equal("Binary files a/app.js and b/app.js differ\n"))
+		})
+	})
+
+	Describe("Test NewLocalSource()", func() {
+		It("Should list a local directory without .git", func() {
+			dir, _ := ioutil.TempDir("", "tree_diff")
+			defer os.RemoveAll(dir)
+			os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+			os.MkdirAll(filepath.Join(dir, "src"), 0755)
+			ioutil.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0644)
+
+			entries, listed := NewLocalSource(dir).List("")
+			Ω(listed).To(BeTrue())
+			Ω(entries).To(Equal([]Entry{{Name: "src", Dir: true, Size: -1}}))
+
+			entries, _ = NewLocalSource(dir).List("src/")
+			Ω(entries).To(Equal([]Entry{{Name: "main.go", Size: 13}}))
+
+			_, listed = NewLocalSource(dir).List("missing/")
+			Ω(listed).To(BeFalse())
+		})
+	})
+
+	Describe("Test NewDropletSource()", func() {
+		var dir, tarball string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "tree_diff")
+			tarball = filepath.Join(dir, "droplet.tgz")
+
+			// app/lib/ is only implied by the file in it
+			writeDroplet(tarball, [][2]string{
+				{"./", ""},
+				{"./app/", ""},
+				{"./app/server.js", "x"},
+				{"./app/config.yml", strings.Repeat("x", 5000)},
+				{"./app/gone.txt", "x"},
+				{"./app/lib/util.js", "util"},
+				{"./app/current", "->server.js"},
+				{"./staging_info.yml", "{}"},
+			})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("Should list and read the droplet below its root", func() {
+			droplet, err := NewDropletSource(tarball, "/")
+			Ω(err).To(BeNil())
+
+			entries, listed := droplet.List("")
+			Ω(listed).To(BeTrue())
+			Ω(entries).To(ConsistOf(Entry{Name: "app", Dir: true, Size: -1}, Entry{Name: "staging_info.yml", Size: 2}))
+
+			droplet, _ = NewDropletSource(tarball, "/app/")
+			entries, _ = droplet.List("")
+			Ω(entries).To(ConsistOf(
+				Entry{Name: "server.js", Size: 1},
+				Entry{Name: "config.yml", Size: 5000},
+				Entry{Name: "gone.txt", Size: 1},
+				Entry{Name: "lib", Dir: true, Size: -1},
+				Entry{Name: "current", Size: 9},
+			))
+
+			content, err := droplet.Read("lib/util.js")
+			Ω(err).To(BeNil())
+			Ω(string(content)).To(Equal("util"))
+
+			_, err = droplet.Read("missing.js")
+			Ω(err).NotTo(BeNil())
+			_, listed = droplet.List("missing/")
+			Ω(listed).To(BeFalse())
+		})
+
+		It("Should reject a file that is not a droplet", func() {
+			ioutil.WriteFile(tarball, []byte(`{"errors": []}`), 0644)
+			_, err := NewDropletSource(tarball, "/")
+			Ω(err).NotTo(BeNil())
+		})
+
+		It("Should report what an instance changed at runtime", func() {
+			// the fake lists every live file as 1B
+			live := filepath.Join(dir, "live") + "/"
+			os.MkdirAll(live+"lib", 0755)
+			os.MkdirAll(live+"uploads", 0755)
+			for _, val := range []string{"server.js", "config.yml", "current", "lib/util.js", "uploads/new.png"} {
+				ioutil.WriteFile(live+val, []byte("x"), 0644)
+			}
+
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetFakeDir(true)
+
+			droplet, _ := NewDropletSource(tarball, "/app/")
+			differ := NewDiffer(droplet, NewRemoteSource(cmdExec, "app", "0", live, false, false), "/app/", []string{"/app/current"}, DefaultWorkers)
+
+			Ω(differ.Diff()).To(ConsistOf(
+				Change{Path: "config.yml", Kind: Modified, SizeA: 5000, SizeB: 1},
+				Change{Path: "gone.txt", Kind: Removed, SizeA: 1, SizeB: -1},
+				Change{Path: "lib/util.js", Kind: Modified, SizeA: 4, SizeB: 1},
+				Change{Path: "uploads/new.png", Kind: Added, SizeA: -1, SizeB: 1},
+			))
+			Ω(differ.GetFailed()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,154 @@
+package walker
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/filter"
+)
+
+type Walker interface {
+	Walk(root string, filterList []string, visit VisitFunc)
+	Stop()
+}
+
+// a file found in a listing, Size is the listed size in bytes or -1 if it is unknown
+type File struct {
+	Name     string
+	ReadPath string
+	Size     int64
+}
+
+// the contents of one directory, entries excluded by the filter list are kept apart in Omitted.
+// Failed is set if the directory could not be listed, its entries are then unknown rather than none.
+// Elapsed is how long the listing took
+type Listing struct {
+	ReadPath string
+	Files    []File
+	Dirs     []string
+	Omitted  []string
+	Failed   bool
+	Elapsed  time.Duration
+}
+
+/*
+*	VisitFunc is called once for every directory that is listed. Returning false keeps the walker
+*	from descending into the directory's sub directories. When the walker runs more than one
+*	worker the function is called from several goroutines at once.
+ */
+type VisitFunc func(listing Listing) bool
+
+// number of directories listed at the same time
+const DefaultWorkers = 4
+
+type walker struct {
+	parser  dir_parser.Parser
+	workers int
+	queue   int
+	stopped int32
+}
+
+/*
+*	NewWalker() returns a walker listing directories on 'workers' goroutines. Sub directories are
+*	handed to idle workers through a queue holding at most 'queue' directories; when the queue is
+*	full the worker that found a directory walks it itself. The walker therefore never holds more
+*	than the queue plus one listing per level of the tree for each worker, however large the tree.
+*	With one worker and a queue of 0 directories are visited in depth first order.
+ */
+func NewWalker(parser dir_parser.Parser, workers, queue int) *walker {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &walker{
+		parser:  parser,
+		workers: workers,
+		queue:   queue,
+	}
+}
+
+/*
+*	Walk() lists 'root' and every directory below it that is not filtered, and returns once all
+*	of them have been visited.
+ */
+func (w *walker) Walk(root string, filterList []string, visit VisitFunc) {
+	var pending sync.WaitGroup
+	queue := make(chan string, w.queue)
+
+	var walk func(readPath string)
+	walk = func(readPath string) {
+		if w.isStopped() {
+			return
+		}
+
+		listing := w.list(readPath, filterList)
+		if !visit(listing) {
+			return
+		}
+
+		for _, val := range listing.Dirs {
+			dirRPath := readPath + val
+
+			// hand the directory to an idle worker, or walk it here if none is free
+			pending.Add(1)
+			select {
+			case queue <- dirRPath:
+			default:
+				pending.Done()
+				walk(dirRPath)
+			}
+		}
+	}
+
+	for i := 0; i < w.workers; i++ {
+		go func() {
+			for readPath := range queue {
+				walk(readPath)
+				pending.Done()
+			}
+		}()
+	}
+
+	pending.Add(1)
+	walk(root)
+	pending.Done()
+
+	pending.Wait()
+	close(queue)
+}
+
+/*
+*	Stop() ends the walk in progress, and any later one, without listing further directories.
+ */
+func (w *walker) Stop() {
+	atomic.StoreInt32(&w.stopped, 1)
+}
+
+func (w *walker) isStopped() bool {
+	return atomic.LoadInt32(&w.stopped) == 1
+}
+
+// lists a single directory and splits off the entries on the filter list
+func (w *walker) list(readPath string, filterList []string) Listing {
+	start := time.Now()
+	entries, listed := w.parser.ListDir(readPath)
+
+	listing := Listing{ReadPath: readPath, Failed: !listed, Elapsed: time.Since(start)}
+	for _, val := range entries {
+		if filter.CheckToFilter(strings.TrimSuffix(readPath+val.Name, "/"), filterList) {
+			listing.Omitted = append(listing.Omitted, val.Name)
+			continue
+		}
+
+		if val.Kind == dir_parser.Dir {
+			listing.Dirs = append(listing.Dirs, val.Name)
+		} else {
+			listing.Files = append(listing.Files, File{Name: val.Name, ReadPath: readPath + val.Name, Size: val.Size})
+		}
+	}
+
+	return listing
+}
@@ -0,0 +1,13 @@
+package walker_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestWalker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Walker Suite")
+}
@@ -0,0 +1,150 @@
+package walker_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/dir_parser"
+	"github.com/ibmjstart/cf-download/scheduler"
+	. "github.com/ibmjstart/cf-download/walker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+*	syntheticTree returns a fake answering cf files requests for a tree that only exists as a
+*	formula. The root and every directory above 'depth' hold 'width' sub directories, the
+*	directories at 'depth' hold 'width' files each, so the tree has width^(depth+1) files.
+ */
+func syntheticTree(width, depth int) cmd_exec_fake.FakeCmdExec {
+	tree := cmd_exec_fake.NewCmdExec()
+	tree.SetListingFunc(func(readPath string) (string, bool) {
+		level := strings.Count(readPath, "/") - 1
+
+		var listing []string
+		for i := 0; i < width; i++ {
+			if level < depth {
+				listing = append(listing, fmt.Sprintf("dir%d/                    -", i))
+			} else {
+				listing = append(listing, fmt.Sprintf("file%d.log               1.5K", i))
+			}
+		}
+
+		return strings.Join(listing, "\n") + "\n", true
+	})
+
+	return tree
+}
+
+var _ = Describe("Walker", func() {
+	Describe("Test Walk() order and filtering", func() {
+		It("should visit a small tree depth first with one worker", func() {
+			tree := syntheticTree(2, 1)
+			w := NewWalker(dir_parser.NewParser(tree, "synthetic", "0", false, false), 1, 0)
+
+			var visited []string
+			var omitted []string
+			w.Walk("/", []string{"/dir1/file0.log"}, func(listing Listing) bool {
+				visited = append(visited, listing.ReadPath)
+				omitted = append(omitted, listing.Omitted...)
+				return true
+			})
+
+			Ω(visited).To(Equal([]string{"/", "/dir0/", "/dir1/"}))
+			Ω(omitted).To(Equal([]string{"file0.log"}))
+		})
+
+		It("should not descend when the visitor returns false", func() {
+			tree := syntheticTree(3, 2)
+			w := NewWalker(dir_parser.NewParser(tree, "synthetic", "0", false, false), 1, 0)
+
+			count := 0
+			w.Walk("/", nil, func(listing Listing) bool {
+				count++
+				return listing.ReadPath == "/"
+			})
+
+			Ω(count).To(Equal(4))
+		})
+
+		It("should list the fake directory from disk", func() {
+			cmdExec := cmd_exec_fake.NewCmdExec()
+			cmdExec.SetFakeDir(true)
+			w := NewWalker(dir_parser.NewParser(cmdExec, "synthetic", "0", false, false), 2, 2)
+
+			var mu sync.Mutex
+			files := 0
+			w.Walk("../downloader/testFiles/", nil, func(listing Listing) bool {
+				mu.Lock()
+				files += len(listing.Files)
+				mu.Unlock()
+				return true
+			})
+
+			Ω(files).To(Equal(5))
+		})
+	})
+
+	/*
+	*	Walks a tree of 100 x 100 directories holding 100 files each, one million files in all, and
+	*	queues every file on a scheduler that does not download anything. Neither the number of
+	*	goroutines nor the heap may grow with the size of the tree.
+	 */
+	Describe("Test Walk() over a million entries", func() {
+		It("should keep goroutines and memory flat", func() {
+			tree := syntheticTree(100, 2)
+			w := NewWalker(dir_parser.NewParser(tree, "synthetic", "0", false, false), DefaultWorkers, DefaultWorkers)
+
+			var wg sync.WaitGroup
+			var downloaded int64
+			s := scheduler.NewScheduler(scheduler.DefaultWorkers, scheduler.DefaultQueueSize, func(file scheduler.File) {
+				atomic.AddInt64(&downloaded, 1)
+				wg.Done()
+			})
+			defer s.Close()
+
+			var mu sync.Mutex
+			var dirs, maxGoroutines, maxPending int
+			var maxHeap uint64
+			var stats runtime.MemStats
+
+			w.Walk("/", nil, func(listing Listing) bool {
+				jobs := make([]scheduler.File, 0, len(listing.Files))
+				for _, val := range listing.Files {
+					jobs = append(jobs, scheduler.File{ReadPath: val.ReadPath, Size: val.Size})
+				}
+				wg.Add(len(jobs))
+				s.Submit(jobs)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				dirs++
+				if n := runtime.NumGoroutine(); n > maxGoroutines {
+					maxGoroutines = n
+				}
+				if n := s.Pending(); n > maxPending {
+					maxPending = n
+				}
+				if dirs%500 == 0 {
+					runtime.ReadMemStats(&stats)
+					if stats.HeapAlloc > maxHeap {
+						maxHeap = stats.HeapAlloc
+					}
+				}
+				return true
+			})
+			wg.Wait()
+
+			Ω(dirs).To(Equal(1 + 100 + 100*100))
+			Ω(atomic.LoadInt64(&downloaded)).To(BeEquivalentTo(1000000))
+			Ω(maxPending).To(BeNumerically("<=", scheduler.DefaultQueueSize))
+			Ω(maxGoroutines).To(BeNumerically("<", 100))
+			Ω(maxHeap).To(BeNumerically("<", 64<<20))
+		})
+	})
+})
@@ -0,0 +1,216 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibmjstart/cf-download/cmd_exec"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	"github.com/ibmjstart/cf-download/scheduler"
+	"github.com/ibmjstart/cf-download/tree_diff"
+)
+
+type Watcher interface {
+	Check() []Event
+}
+
+// what happened to a file since the last check
+type Kind int
+
+const (
+	Appeared Kind = iota
+	Grew
+	Shrank
+	Vanished
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Appeared:
+		return "appeared"
+	case Grew:
+		return "grew"
+	case Shrank:
+		return "shrank"
+	default:
+		return "vanished"
+	}
+}
+
+// one line of the change feed, OldSize is the size of the local copy before it was updated
+type Event struct {
+	Kind    Kind
+	Path    string
+	Size    int64
+	OldSize int64
+	At      time.Time
+}
+
+type watcher struct {
+	dloader    downloader.Downloader
+	readRoot   string
+	writeRoot  string
+	filterList []string
+	mirror     bool
+	remote     tree_diff.Source
+	local      tree_diff.Source
+	vanished   map[string]bool
+}
+
+/*
+*	NewWatcher() returns a watcher keeping the local copy below 'writeRoot' in step with 'readRoot'
+*	on the instance selected by 'instances'. Files are fetched through 'dloader', which fails over
+*	like a download does, but are not counted against its limits. If 'mirror' is set files and
+*	directories that vanish from the app are removed locally as well.
+ */
+func NewWatcher(cmdExec cmd_exec.CmdExec, dloader downloader.Downloader, instances instance_manager.InstanceManager, appName, readRoot, writeRoot string, filterList []string, mirror, onWindows, verbose bool) *watcher {
+	remote := tree_diff.NewRemoteSource(cmdExec, appName, instances.Current(), readRoot, onWindows, verbose)
+	remote.SetInstanceManager(instances)
+
+	return &watcher{
+		dloader:    dloader,
+		readRoot:   readRoot,
+		writeRoot:  writeRoot,
+		filterList: filterList,
+		mirror:     mirror,
+		remote:     remote,
+		local:      tree_diff.NewLocalSource(writeRoot),
+		vanished:   make(map[string]bool),
+	}
+}
+
+/*
+*	Check() lists the app again, fetches the files that appeared or whose listed size changed and
+*	returns what happened, sorted by path. Only files that were written are reported; one that
+*	failed shows up again on the next check. A vanished file is reported once.
+ */
+func (w *watcher) Check() []Event {
+	differ := tree_diff.NewDiffer(w.local, w.remote, w.readRoot, w.filterList, tree_diff.DefaultWorkers)
+	changes := differ.Diff()
+
+	var events []Event
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan bool, scheduler.DefaultWorkers)
+
+	vanished := make(map[string]bool)
+	for _, val := range changes {
+		if val.Kind != tree_diff.Removed {
+			continue
+		}
+		vanished[val.Path] = true
+		if w.vanished[val.Path] {
+			continue
+		}
+		if w.mirror && !w.remove(val.Path) {
+			continue
+		}
+		events = append(events, Event{Kind: Vanished, Path: val.Path, OldSize: val.SizeA, At: time.Now()})
+	}
+	w.vanished = vanished
+
+	// with the files gone, directories that vanished are empty unless they hold omitted paths
+	if w.mirror {
+		for _, val := range differ.GetRemovedDirs() {
+			w.removeDir(val)
+		}
+	}
+
+	for _, val := range changes {
+		if val.Kind == tree_diff.Removed {
+			continue
+		}
+
+		wg.Add(1)
+		workers <- true
+		go func(change tree_diff.Change) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			if event, ok := w.fetch(change); ok {
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			}
+		}(val)
+	}
+	wg.Wait()
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// downloads one changed file and describes the change if the file was written
+func (w *watcher) fetch(change tree_diff.Change) (Event, bool) {
+	readPath := w.readRoot + change.Path
+	writePath := filepath.Join(w.writeRoot, filepath.FromSlash(change.Path))
+
+	if err := os.MkdirAll(filepath.Dir(writePath), 0755); err != nil {
+		return Event{}, false
+	}
+
+	content, err := w.dloader.Fetch(readPath)
+	if err != nil || ioutil.WriteFile(writePath, content, 0644) != nil {
+		return Event{}, false
+	}
+
+	event := Event{Kind: Appeared, Path: change.Path, Size: change.SizeB, OldSize: change.SizeA, At: time.Now()}
+	if info, err := os.Stat(writePath); err == nil {
+		event.Size = info.Size()
+	}
+	if change.Kind == tree_diff.Modified {
+		event.Kind = Grew
+		if event.Size < change.SizeA {
+			event.Kind = Shrank
+		}
+	}
+
+	return event, true
+}
+
+// removes a vanished file, never anything outside the local copy
+func (w *watcher) remove(path string) bool {
+	local, ok := w.localPath(path)
+	if !ok {
+		return false
+	}
+
+	return os.Remove(local) == nil
+}
+
+// removes the empty directories of a vanished directory, deepest first
+func (w *watcher) removeDir(path string) {
+	local, ok := w.localPath(path)
+	if !ok {
+		return
+	}
+
+	var dirs []string
+	filepath.Walk(local, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
+
+// the local path of 'path', if it lies below the local copy
+func (w *watcher) localPath(path string) (string, bool) {
+	local := filepath.Join(w.writeRoot, filepath.FromSlash(path))
+	rel, err := filepath.Rel(w.writeRoot, local)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+
+	return local, true
+}
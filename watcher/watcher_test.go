@@ -0,0 +1,144 @@
+package watcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ibmjstart/cf-download/cmd_exec/cmd_exec_fake"
+	"github.com/ibmjstart/cf-download/downloader"
+	"github.com/ibmjstart/cf-download/instance_manager"
+	. "github.com/ibmjstart/cf-download/watcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watcher", func() {
+	var (
+		wg       sync.WaitGroup
+		cmdExec  cmd_exec_fake.FakeCmdExec
+		remote   string
+		local    string
+		newWatch func(mirror bool) Watcher
+		dloaders []downloader.Downloader
+	)
+
+	// the fake lists every file as 1B, so the app's files hold a single byte
+	write := func(path, content string) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	kinds := func(events []Event) map[string]Kind {
+		byPath := make(map[string]Kind)
+		for _, val := range events {
+			byPath[val.Path] = val.Kind
+		}
+		return byPath
+	}
+
+	BeforeEach(func() {
+		remote, _ = ioutil.TempDir("", "watch-remote")
+		local, _ = ioutil.TempDir("", "watch-local")
+		write(filepath.Join(remote, "a.txt"), "a")
+		write(filepath.Join(remote, "sub", "b.txt"), "b")
+
+		cmdExec = cmd_exec_fake.NewCmdExec()
+		cmdExec.SetFakeDir(true)
+
+		newWatch = func(mirror bool) Watcher {
+			d := downloader.NewDownloader(cmdExec, &wg, "app", "0", false, false)
+			dloaders = append(dloaders, d)
+			instances := instance_manager.NewInstanceManager(cmdExec, "app", "0", false)
+			return NewWatcher(cmdExec, d, instances, "app", remote+"/", local+"/", nil, mirror, false, false)
+		}
+	})
+
+	AfterEach(func() {
+		for _, val := range dloaders {
+			val.Close()
+		}
+		dloaders = nil
+		os.RemoveAll(remote)
+		os.RemoveAll(local)
+	})
+
+	Describe("Test Check()", func() {
+		It("should fetch files as they appear and report them once", func() {
+			w := newWatch(false)
+
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"a.txt": Appeared, "sub/b.txt": Appeared}))
+			contents, _ := ioutil.ReadFile(filepath.Join(local, "sub", "b.txt"))
+			Ω(string(contents)).To(Equal("b"))
+
+			Ω(w.Check()).To(BeEmpty())
+		})
+
+		It("should not report a file that failed to download, and fetch it on the next check", func() {
+			w := newWatch(false)
+			cmdExec.SetFailingPath(filepath.Join(remote, "sub", "b.txt"))
+
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"a.txt": Appeared}))
+			_, err := os.Stat(filepath.Join(local, "sub", "b.txt"))
+			Ω(os.IsNotExist(err)).To(BeTrue())
+
+			cmdExec.SetFailingPath("")
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"sub/b.txt": Appeared}))
+		})
+
+		It("should keep fetching once the download was stopped by its limits", func() {
+			w := newWatch(false)
+			dloaders[0].SetLimits(downloader.Limits{MaxFiles: 1})
+			wg.Add(2)
+			dloaders[0].DownloadFile(filepath.Join(remote, "a.txt"), filepath.Join(local, "a.txt"))
+			dloaders[0].DownloadFile(filepath.Join(remote, "sub", "b.txt"), filepath.Join(local, "b.txt"))
+			Ω(dloaders[0].GetAbortReason()).NotTo(BeEmpty())
+
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"sub/b.txt": Appeared}))
+		})
+
+		It("should report files that changed size or vanished", func() {
+			w := newWatch(false)
+			w.Check()
+
+			write(filepath.Join(remote, "c.txt"), "c")
+			os.Remove(filepath.Join(remote, "a.txt"))
+			write(filepath.Join(local, "sub", "b.txt"), "stale")
+
+			events := w.Check()
+			Ω(kinds(events)).To(Equal(map[string]Kind{"a.txt": Vanished, "c.txt": Appeared, "sub/b.txt": Shrank}))
+			Ω(events[2].OldSize).To(BeEquivalentTo(5))
+			Ω(events[2].Size).To(BeEquivalentTo(1))
+
+			// kept locally, but only reported once
+			_, err := os.Stat(filepath.Join(local, "a.txt"))
+			Ω(err).To(BeNil())
+			Ω(w.Check()).To(BeEmpty())
+		})
+
+		It("should remove vanished files when mirroring", func() {
+			w := newWatch(true)
+			w.Check()
+
+			os.Remove(filepath.Join(remote, "a.txt"))
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"a.txt": Vanished}))
+
+			_, err := os.Stat(filepath.Join(local, "a.txt"))
+			Ω(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should remove vanished directories when mirroring", func() {
+			w := newWatch(true)
+			w.Check()
+
+			os.RemoveAll(filepath.Join(remote, "sub"))
+			Ω(kinds(w.Check())).To(Equal(map[string]Kind{"sub/b.txt": Vanished}))
+
+			_, err := os.Stat(filepath.Join(local, "sub"))
+			Ω(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(filepath.Join(local, "a.txt"))
+			Ω(err).To(BeNil())
+		})
+	})
+})